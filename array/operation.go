@@ -7,7 +7,6 @@ import (
 
 	"github.com/PaesslerAG/jsonpath"
 	"github.com/integronlabs/integron/helpers"
-	"github.com/sirupsen/logrus"
 )
 
 func Run(ctx context.Context, stepMap map[string]interface{}, stepOutputs map[string]interface{}) (interface{}, string, error) {
@@ -29,24 +28,25 @@ func Run(ctx context.Context, stepMap map[string]interface{}, stepOutputs map[st
 		return err.Error(), "error", err
 	}
 
-	logrus.WithContext(ctx).Debugf("inputString: %v", inputString)
-	logrus.WithContext(ctx).Debugf("output: %v", output)
-	logrus.WithContext(ctx).Debugf("next: %v", next)
+	helpers.Log(ctx).Debugf("inputString: %v", inputString)
+	helpers.Log(ctx).Debugf("output: %v", output)
+	helpers.Log(ctx).Debugf("next: %v", next)
 
 	// replace placeholders in input
 	inputMap, err := jsonpath.Get(inputString, stepOutputs)
 	if err != nil {
-		logrus.WithContext(ctx).Errorf("could not read value from input: %v", err)
+		helpers.Log(ctx).Errorf("could not read value from input: %v", err)
 		return err.Error(), "error", err
 	}
 
-	logrus.WithContext(ctx).Debugf("inputMap: %v", inputMap)
+	helpers.Log(ctx).Debugf("inputMap: %v", inputMap)
 
 	inputArray, ok := inputMap.([]interface{})
 	if !ok {
 		err := fmt.Errorf("invalid input format")
 		return err.Error(), "error", err
 	}
+	helpers.RecordInput(ctx, "input", inputArray)
 
 	body := helpers.TransformArray(inputArray, output)
 
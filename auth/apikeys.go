@@ -0,0 +1,56 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// APIKeys maps API keys to the claims of their owner.
+type APIKeys map[string]map[string]interface{}
+
+// LoadAPIKeys reads a JSON key store. Each key maps to the claims of its owner, or to a string
+// that becomes the "sub" claim:
+//
+//	{"3f9c...": "partner-a", "8d1e...": {"sub": "partner-b", "scope": "facts:read"}}
+func LoadAPIKeys(path string) (APIKeys, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var entries map[string]interface{}
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("invalid API key store %s: %w", path, err)
+	}
+	keys := make(APIKeys, len(entries))
+	for key, entry := range entries {
+		switch value := entry.(type) {
+		case string:
+			keys[key] = map[string]interface{}{"sub": value}
+		case map[string]interface{}:
+			keys[key] = value
+		default:
+			return nil, fmt.Errorf("invalid API key store %s: invalid entry %v", path, entry)
+		}
+	}
+	return keys, nil
+}
+
+// Lookup returns the claims of a key, comparing keys in constant time.
+func (k APIKeys) Lookup(key string) (map[string]interface{}, bool) {
+	var found map[string]interface{}
+	for candidate, claims := range k {
+		if subtle.ConstantTimeCompare([]byte(candidate), []byte(key)) == 1 {
+			found = claims
+		}
+	}
+	if found == nil {
+		return nil, false
+	}
+	claims := make(map[string]interface{}, len(found))
+	for name, value := range found {
+		claims[name] = value
+	}
+	return claims, true
+}
@@ -0,0 +1,46 @@
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const EXPECTED_NIL_GOT = "Expected nil, got %v"
+const EXPECTED_ERROR_GOT_NIL = "Expected error, got nil"
+const EXPECTED_BUT_GOT = "Expected %v, got %v"
+
+func writeFile(t *testing.T, name string, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf(EXPECTED_NIL_GOT, err)
+	}
+	return path
+}
+
+func TestLoadAPIKeys(t *testing.T) {
+	path := writeFile(t, "keys.json", `{"key-a": "partner-a", "key-b": {"sub": "partner-b", "scope": "facts:read"}}`)
+
+	keys, err := LoadAPIKeys(path)
+
+	if err != nil {
+		t.Fatalf(EXPECTED_NIL_GOT, err)
+	}
+	if claims, ok := keys.Lookup("key-a"); !ok || claims["sub"] != "partner-a" {
+		t.Errorf(EXPECTED_BUT_GOT, "partner-a", claims)
+	}
+	if claims, ok := keys.Lookup("key-b"); !ok || claims["scope"] != "facts:read" {
+		t.Errorf(EXPECTED_BUT_GOT, "facts:read", claims)
+	}
+	if _, ok := keys.Lookup("key-c"); ok {
+		t.Error("Expected unknown key to be rejected")
+	}
+}
+
+func TestLoadAPIKeysInvalid(t *testing.T) {
+	for _, content := range []string{`[]`, `{"key": 1}`} {
+		if _, err := LoadAPIKeys(writeFile(t, "keys.json", content)); err == nil {
+			t.Errorf("Expected error for %s, got nil", content)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/getkin/kin-openapi/openapi3filter"
+)
+
+// ErrUnauthenticated is returned when a request carries no valid credentials for a scheme.
+var ErrUnauthenticated = errors.New("unauthenticated")
+
+// ErrForbidden is returned when valid credentials lack a required scope.
+var ErrForbidden = errors.New("insufficient scope")
+
+// Authenticator enforces the securitySchemes of an OpenAPI document.
+type Authenticator struct {
+	APIKeys APIKeys
+	JWT     *JWTVerifier
+	Users   Htpasswd
+}
+
+type claimsKey struct{}
+
+// verified collects the claims of the schemes of the security requirement being checked, by
+// scheme name. openapi3filter checks the schemes of a requirement one by one and stops at the first
+// that fails, so a failure drops the claims of the schemes of its requirement checked before it.
+type verified struct {
+	mutex  sync.Mutex
+	claims map[string]interface{}
+}
+
+// NewContext prepares a context to collect the claims verified by Authenticate.
+func NewContext(ctx context.Context) context.Context {
+	return context.WithValue(ctx, claimsKey{}, &verified{})
+}
+
+// FromContext returns the claims verified for a request by scheme name, or nil.
+func FromContext(ctx context.Context) map[string]interface{} {
+	v, ok := ctx.Value(claimsKey{}).(*verified)
+	if !ok {
+		return nil
+	}
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	return v.claims
+}
+
+// Authenticate is the openapi3filter.AuthenticationFunc that verifies a single security scheme.
+func (a *Authenticator) Authenticate(ctx context.Context, input *openapi3filter.AuthenticationInput) error {
+	claims, err := a.verify(input)
+	v, ok := ctx.Value(claimsKey{}).(*verified)
+	if !ok {
+		return err
+	}
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	if err != nil {
+		v.claims = nil
+		return err
+	}
+	if v.claims == nil {
+		v.claims = make(map[string]interface{})
+	}
+	v.claims[input.SecuritySchemeName] = claims
+	return nil
+}
+
+func (a *Authenticator) verify(input *openapi3filter.AuthenticationInput) (map[string]interface{}, error) {
+	r := input.RequestValidationInput.Request
+	scheme := input.SecurityScheme
+
+	var claims map[string]interface{}
+	var err error
+	switch scheme.Type {
+	case "apiKey":
+		claims, err = a.apiKey(r, scheme.In, scheme.Name)
+	case "http":
+		switch strings.ToLower(scheme.Scheme) {
+		case "bearer":
+			claims, err = a.bearer(r)
+		case "basic":
+			claims, err = a.basic(r)
+		default:
+			err = fmt.Errorf("unsupported http scheme %q", scheme.Scheme)
+		}
+	case "oauth2", "openIdConnect":
+		claims, err = a.bearer(r)
+	default:
+		err = fmt.Errorf("unsupported security scheme type %q", scheme.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrUnauthenticated, input.SecuritySchemeName, err)
+	}
+
+	granted := Scopes(claims)
+	for _, scope := range input.Scopes {
+		if !granted[scope] {
+			return nil, fmt.Errorf("%w: %s: missing scope %q", ErrForbidden, input.SecuritySchemeName, scope)
+		}
+	}
+	return claims, nil
+}
+
+func (a *Authenticator) apiKey(r *http.Request, in string, name string) (map[string]interface{}, error) {
+	if a.APIKeys == nil {
+		return nil, errors.New("no API key store configured")
+	}
+	var key string
+	switch in {
+	case "header":
+		key = r.Header.Get(name)
+	case "query":
+		key = r.URL.Query().Get(name)
+	case "cookie":
+		if cookie, err := r.Cookie(name); err == nil {
+			key = cookie.Value
+		}
+	}
+	if key == "" {
+		return nil, fmt.Errorf("missing API key %s in %s", name, in)
+	}
+	claims, ok := a.APIKeys.Lookup(key)
+	if !ok {
+		return nil, errors.New("invalid API key")
+	}
+	return claims, nil
+}
+
+func (a *Authenticator) bearer(r *http.Request) (map[string]interface{}, error) {
+	if a.JWT == nil {
+		return nil, errors.New("no JWT keys configured")
+	}
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return nil, errors.New("missing bearer token")
+	}
+	return a.JWT.Verify(token)
+}
+
+func (a *Authenticator) basic(r *http.Request) (map[string]interface{}, error) {
+	if a.Users == nil {
+		return nil, errors.New("no htpasswd file configured")
+	}
+	user, password, ok := r.BasicAuth()
+	if !ok {
+		return nil, errors.New("missing basic credentials")
+	}
+	if !a.Users.Verify(user, password) {
+		return nil, errors.New("invalid user or password")
+	}
+	return map[string]interface{}{"sub": user}, nil
+}
+
+// Scopes returns the scopes granted by the "scope" (space separated), "scp" or "scopes" claims.
+func Scopes(claims map[string]interface{}) map[string]bool {
+	granted := make(map[string]bool)
+	for _, name := range []string{"scope", "scp", "scopes"} {
+		switch value := claims[name].(type) {
+		case string:
+			for _, scope := range strings.Fields(value) {
+				granted[scope] = true
+			}
+		case []interface{}:
+			for _, scope := range value {
+				if scope, ok := scope.(string); ok {
+					granted[scope] = true
+				}
+			}
+		}
+	}
+	return granted
+}
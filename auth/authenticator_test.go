@@ -0,0 +1,94 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func authenticate(a *Authenticator, ctx context.Context, r *http.Request, scheme *openapi3.SecurityScheme, scopes ...string) error {
+	return a.Authenticate(ctx, &openapi3filter.AuthenticationInput{
+		RequestValidationInput: &openapi3filter.RequestValidationInput{Request: r},
+		SecuritySchemeName:     "test",
+		SecurityScheme:         scheme,
+		Scopes:                 scopes,
+	})
+}
+
+func TestAuthenticateAPIKey(t *testing.T) {
+	a := &Authenticator{APIKeys: APIKeys{"key-a": {"sub": "partner-a"}}}
+	scheme := &openapi3.SecurityScheme{Type: "apiKey", In: "header", Name: "X-API-Key"}
+	ctx := NewContext(context.Background())
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("X-API-Key", "key-a")
+
+	if err := authenticate(a, ctx, r, scheme); err != nil {
+		t.Fatalf(EXPECTED_NIL_GOT, err)
+	}
+	claims, _ := FromContext(ctx)["test"].(map[string]interface{})
+	if claims["sub"] != "partner-a" {
+		t.Errorf(EXPECTED_BUT_GOT, "partner-a claims", FromContext(ctx))
+	}
+
+	r.Header.Set("X-API-Key", "key-b")
+	if err := authenticate(a, ctx, r, scheme); !errors.Is(err, ErrUnauthenticated) {
+		t.Errorf(EXPECTED_BUT_GOT, ErrUnauthenticated, err)
+	}
+	if claims := FromContext(ctx); claims != nil {
+		t.Errorf(EXPECTED_BUT_GOT, "no claims of a failed requirement", claims)
+	}
+}
+
+func TestAuthenticateBearerScopes(t *testing.T) {
+	public, private, _ := ed25519.GenerateKey(rand.Reader)
+	a := &Authenticator{JWT: &JWTVerifier{Keys: map[string]crypto.PublicKey{"": public}}}
+	scheme := &openapi3.SecurityScheme{Type: "http", Scheme: "bearer", BearerFormat: "JWT"}
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Authorization", "Bearer "+sign(t, jwt.SigningMethodEdDSA, private, "", validClaims()))
+
+	if err := authenticate(a, NewContext(context.Background()), r, scheme, "facts:read"); err != nil {
+		t.Errorf(EXPECTED_NIL_GOT, err)
+	}
+	if err := authenticate(a, NewContext(context.Background()), r, scheme, "facts:delete"); !errors.Is(err, ErrForbidden) {
+		t.Errorf(EXPECTED_BUT_GOT, ErrForbidden, err)
+	}
+
+	r.Header.Del("Authorization")
+	if err := authenticate(a, NewContext(context.Background()), r, scheme); !errors.Is(err, ErrUnauthenticated) {
+		t.Errorf(EXPECTED_BUT_GOT, ErrUnauthenticated, err)
+	}
+}
+
+func TestAuthenticateWithoutConfiguration(t *testing.T) {
+	a := &Authenticator{}
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.SetBasicAuth("alice", "secret")
+
+	for _, scheme := range []*openapi3.SecurityScheme{
+		{Type: "http", Scheme: "basic"},
+		{Type: "oauth2"},
+		{Type: "apiKey", In: "query", Name: "key"},
+		{Type: "mutualTLS"},
+	} {
+		if err := authenticate(a, context.Background(), r, scheme); !errors.Is(err, ErrUnauthenticated) {
+			t.Errorf(EXPECTED_BUT_GOT, ErrUnauthenticated, err)
+		}
+	}
+}
+
+func TestScopes(t *testing.T) {
+	granted := Scopes(map[string]interface{}{"scope": "a b", "scp": []interface{}{"c"}})
+
+	if !granted["a"] || !granted["b"] || !granted["c"] || granted["d"] {
+		t.Errorf(EXPECTED_BUT_GOT, "a, b and c", granted)
+	}
+}
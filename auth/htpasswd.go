@@ -0,0 +1,56 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Htpasswd maps user names to password hashes.
+type Htpasswd map[string]string
+
+// LoadHtpasswd reads an htpasswd file with bcrypt ($2y$) or SHA-1 ({SHA}) hashes.
+func LoadHtpasswd(path string) (Htpasswd, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	users := make(Htpasswd)
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		entry := strings.TrimSpace(scanner.Text())
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+		user, hash, ok := strings.Cut(entry, ":")
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: missing password hash", path, line)
+		}
+		if !strings.HasPrefix(hash, "$2") && !strings.HasPrefix(hash, "{SHA}") {
+			return nil, fmt.Errorf("%s:%d: unsupported hash for %s, use bcrypt or {SHA}", path, line, user)
+		}
+		users[user] = hash
+	}
+	return users, scanner.Err()
+}
+
+// Verify checks a user's password against its hash.
+func (h Htpasswd) Verify(user string, password string) bool {
+	hash, ok := h[user]
+	if !ok {
+		return false
+	}
+	if encoded, ok := strings.CutPrefix(hash, "{SHA}"); ok {
+		sum := sha1.Sum([]byte(password))
+		return subtle.ConstantTimeCompare([]byte(encoded), []byte(base64.StdEncoding.EncodeToString(sum[:]))) == 1
+	}
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
@@ -0,0 +1,41 @@
+package auth
+
+import (
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestHtpasswd(t *testing.T) {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	// {SHA} of "password"
+	path := writeFile(t, "htpasswd", "# users\nalice:"+string(hash)+"\nbob:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=\n")
+
+	users, err := LoadHtpasswd(path)
+
+	if err != nil {
+		t.Fatalf(EXPECTED_NIL_GOT, err)
+	}
+	tests := []struct {
+		user     string
+		password string
+		expected bool
+	}{
+		{"alice", "secret", true},
+		{"alice", "wrong", false},
+		{"bob", "password", true},
+		{"bob", "wrong", false},
+		{"carol", "secret", false},
+	}
+	for _, test := range tests {
+		if result := users.Verify(test.user, test.password); result != test.expected {
+			t.Errorf(EXPECTED_BUT_GOT, test.expected, result)
+		}
+	}
+}
+
+func TestLoadHtpasswdUnsupportedHash(t *testing.T) {
+	if _, err := LoadHtpasswd(writeFile(t, "htpasswd", "alice:$apr1$salt$hash\n")); err == nil {
+		t.Error(EXPECTED_ERROR_GOT_NIL)
+	}
+}
@@ -0,0 +1,106 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func sign(t *testing.T, method jwt.SigningMethod, key interface{}, kid string, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf(EXPECTED_NIL_GOT, err)
+	}
+	return signed
+}
+
+func validClaims() jwt.MapClaims {
+	return jwt.MapClaims{
+		"sub":   "user-1",
+		"iss":   "https://issuer.example.com",
+		"aud":   "integron",
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"scope": "facts:read facts:write",
+	}
+}
+
+func TestLoadJWTKeysJWKS(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	encode := func(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+	jwks, _ := json.Marshal(map[string]interface{}{"keys": []interface{}{
+		map[string]interface{}{"kid": "rsa", "kty": "RSA", "use": "sig", "n": encode(rsaKey.N.Bytes()), "e": encode(big.NewInt(int64(rsaKey.E)).Bytes())},
+		map[string]interface{}{"kid": "ec", "kty": "EC", "crv": "P-256", "x": encode(ecKey.X.Bytes()), "y": encode(ecKey.Y.Bytes())},
+		map[string]interface{}{"kid": "enc", "kty": "RSA", "use": "enc"},
+	}})
+
+	keys, err := LoadJWTKeys(writeFile(t, "jwks.json", string(jwks)))
+
+	if err != nil {
+		t.Fatalf(EXPECTED_NIL_GOT, err)
+	}
+	verifier := &JWTVerifier{Keys: keys, Issuer: "https://issuer.example.com", Audience: "integron"}
+	for kid, token := range map[string]string{
+		"rsa": sign(t, jwt.SigningMethodRS256, rsaKey, "rsa", validClaims()),
+		"ec":  sign(t, jwt.SigningMethodES256, ecKey, "ec", validClaims()),
+	} {
+		claims, err := verifier.Verify(token)
+		if err != nil {
+			t.Fatalf(EXPECTED_NIL_GOT, err)
+		}
+		if claims["sub"] != "user-1" {
+			t.Errorf(EXPECTED_BUT_GOT, "user-1 for "+kid, claims["sub"])
+		}
+	}
+}
+
+func TestJWTVerifierRejects(t *testing.T) {
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+	der, _ := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	keys, err := LoadJWTKeys(writeFile(t, "key.pem", string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))))
+	if err != nil {
+		t.Fatalf(EXPECTED_NIL_GOT, err)
+	}
+	verifier := &JWTVerifier{Keys: keys, Issuer: "https://issuer.example.com", Audience: "integron"}
+	otherKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	expired := validClaims()
+	expired["exp"] = time.Now().Add(-time.Hour).Unix()
+	noExpiry := validClaims()
+	delete(noExpiry, "exp")
+	wrongIssuer := validClaims()
+	wrongIssuer["iss"] = "https://evil.example.com"
+	wrongAudience := validClaims()
+	wrongAudience["aud"] = "other"
+
+	tests := map[string]string{
+		"expired":        sign(t, jwt.SigningMethodRS256, key, "", expired),
+		"no expiry":      sign(t, jwt.SigningMethodRS256, key, "", noExpiry),
+		"wrong issuer":   sign(t, jwt.SigningMethodRS256, key, "", wrongIssuer),
+		"wrong audience": sign(t, jwt.SigningMethodRS256, key, "", wrongAudience),
+		"wrong key":      sign(t, jwt.SigningMethodRS256, otherKey, "", validClaims()),
+		"hmac":           sign(t, jwt.SigningMethodHS256, der, "", validClaims()),
+	}
+	for name, token := range tests {
+		if _, err := verifier.Verify(token); err == nil {
+			t.Errorf("Expected error for %s token, got nil", name)
+		}
+	}
+	if _, err := verifier.Verify(sign(t, jwt.SigningMethodRS256, key, "", validClaims())); err != nil {
+		t.Errorf(EXPECTED_NIL_GOT, err)
+	}
+}
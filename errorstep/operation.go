@@ -0,0 +1,72 @@
+package errorstep
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/PaesslerAG/jsonpath"
+	"github.com/integronlabs/integron/helpers"
+	"github.com/sirupsen/logrus"
+)
+
+const DEFAULT_MESSAGE = "error step triggered"
+
+// defaults derives status, code and message from the failure recorded under $._error.
+func defaults(stepOutputs map[string]interface{}) (interface{}, string, string) {
+	status, code, message := interface{}(500), "EXCEPTION", DEFAULT_MESSAGE
+	if kind, _ := jsonpath.Get("$._error.kind", stepOutputs); kind == helpers.ERROR_KIND_TIMEOUT {
+		status, code = 504, "TIMEOUT"
+	}
+	if errorMessage, err := jsonpath.Get("$._error.message", stepOutputs); err == nil && errorMessage != nil {
+		message = fmt.Sprintf("%v", errorMessage)
+	}
+	return status, code, message
+}
+
+func Run(ctx context.Context, stepMap map[string]interface{}, stepOutputs map[string]interface{}) (interface{}, string, error) {
+	// get values
+
+	status, code, message := defaults(stepOutputs)
+
+	if statusTemplate, ok := stepMap["status"]; ok {
+		status = helpers.TransformBody(stepOutputs, statusTemplate)
+	}
+	if codeTemplate, ok := stepMap["code"]; ok {
+		codeString, ok := codeTemplate.(string)
+		if !ok {
+			err := fmt.Errorf("invalid code format")
+			return err.Error(), "error", err
+		}
+		code = fmt.Sprintf("%v", helpers.TransformBody(stepOutputs, codeString))
+	}
+
+	headers := map[string]interface{}{}
+	if headersTemplate, ok := stepMap["headers"]; ok {
+		headersMap, ok := headersTemplate.(map[string]interface{})
+		if !ok {
+			err := fmt.Errorf("invalid headers format")
+			return err.Error(), "error", err
+		}
+		for key, value := range helpers.TransformBody(stepOutputs, headersMap).(map[string]interface{}) {
+			headers[key] = fmt.Sprintf("%v", value)
+		}
+	}
+
+	var body interface{} = map[string]interface{}{"message": message}
+	if output, ok := stepMap["output"]; ok {
+		body = helpers.TransformBody(stepOutputs, output)
+	}
+
+	helpers.RecordInput(ctx, "error", map[string]interface{}{"status": status, "code": code, "message": message})
+
+	helpers.Log(ctx).WithFields(logrus.Fields{
+		"errorCode":  code,
+		"statusCode": status,
+	}).Errorf("Error: %s", message)
+
+	return map[string]interface{}{
+		"status":  status,
+		"headers": headers,
+		"body":    body,
+	}, "", nil
+}
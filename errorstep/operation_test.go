@@ -0,0 +1,144 @@
+package errorstep
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/integronlabs/integron/helpers"
+)
+
+const EXPECTED_NIL_GOT = "Expected nil, got %v"
+const EXPECTED_ERROR_GOT_NIL = "Expected error, got nil"
+const EXPECTED_BUT_GOT = "Expected %v, got %v"
+
+var stepOutputs = map[string]interface{}{
+	"_error": map[string]interface{}{
+		"step":    "dogFacts",
+		"message": "could not find actions for status 404",
+		"kind":    "upstream_status",
+		"status":  404,
+	},
+}
+
+func TestRunDefaults(t *testing.T) {
+	output, next, err := Run(context.Background(), map[string]interface{}{}, stepOutputs)
+
+	if err != nil {
+		t.Errorf(EXPECTED_NIL_GOT, err)
+	}
+	if next != "" {
+		t.Errorf(EXPECTED_BUT_GOT, "", next)
+	}
+	outputMap := output.(map[string]interface{})
+	if outputMap["status"] != 500 {
+		t.Errorf(EXPECTED_BUT_GOT, 500, outputMap["status"])
+	}
+	message := outputMap["body"].(map[string]interface{})["message"]
+	if message != "could not find actions for status 404" {
+		t.Errorf(EXPECTED_BUT_GOT, "could not find actions for status 404", message)
+	}
+}
+
+func TestRunDefaultsWithoutError(t *testing.T) {
+	output, _, err := Run(context.Background(), map[string]interface{}{}, map[string]interface{}{})
+
+	if err != nil {
+		t.Errorf(EXPECTED_NIL_GOT, err)
+	}
+	message := output.(map[string]interface{})["body"].(map[string]interface{})["message"]
+	if message != DEFAULT_MESSAGE {
+		t.Errorf(EXPECTED_BUT_GOT, DEFAULT_MESSAGE, message)
+	}
+}
+
+func TestRunTimeoutDefaults(t *testing.T) {
+	timeoutOutputs := map[string]interface{}{
+		"_error": map[string]interface{}{
+			"message": "context deadline exceeded",
+			"kind":    "timeout",
+		},
+	}
+
+	output, _, err := Run(context.Background(), map[string]interface{}{}, timeoutOutputs)
+
+	if err != nil {
+		t.Errorf(EXPECTED_NIL_GOT, err)
+	}
+	if output.(map[string]interface{})["status"] != 504 {
+		t.Errorf(EXPECTED_BUT_GOT, 504, output.(map[string]interface{})["status"])
+	}
+}
+
+func TestRunConfigured(t *testing.T) {
+	stepMap := map[string]interface{}{
+		"status": "$._error.status",
+		"code":   "NOT_FOUND",
+		"headers": map[string]interface{}{
+			"X-Failed-Step": "$._error.step",
+		},
+		"output": map[string]interface{}{
+			"error":  "Dog not found",
+			"detail": "$._error.message",
+		},
+	}
+
+	output, next, err := Run(context.Background(), stepMap, stepOutputs)
+
+	if err != nil {
+		t.Errorf(EXPECTED_NIL_GOT, err)
+	}
+	if next != "" {
+		t.Errorf(EXPECTED_BUT_GOT, "", next)
+	}
+	outputMap := output.(map[string]interface{})
+	if outputMap["status"] != 404 {
+		t.Errorf(EXPECTED_BUT_GOT, 404, outputMap["status"])
+	}
+	if outputMap["headers"].(map[string]interface{})["X-Failed-Step"] != "dogFacts" {
+		t.Errorf(EXPECTED_BUT_GOT, "dogFacts", outputMap["headers"])
+	}
+	body := outputMap["body"].(map[string]interface{})
+	if body["error"] != "Dog not found" || body["detail"] != "could not find actions for status 404" {
+		t.Errorf(EXPECTED_BUT_GOT, "configured body", body)
+	}
+}
+
+func TestRunInvalidHeaders(t *testing.T) {
+	output, next, err := Run(context.Background(), map[string]interface{}{"headers": "invalid"}, stepOutputs)
+
+	if err == nil {
+		t.Error(EXPECTED_ERROR_GOT_NIL)
+	}
+	if output != "invalid headers format" {
+		t.Errorf(EXPECTED_BUT_GOT, "invalid headers format", output)
+	}
+	if next != "error" {
+		t.Errorf(EXPECTED_BUT_GOT, "error", next)
+	}
+}
+
+func TestRunInvalidCode(t *testing.T) {
+	_, next, err := Run(context.Background(), map[string]interface{}{"code": 404}, stepOutputs)
+
+	if err == nil {
+		t.Error(EXPECTED_ERROR_GOT_NIL)
+	}
+	if next != "error" {
+		t.Errorf(EXPECTED_BUT_GOT, "error", next)
+	}
+}
+
+func TestRunRecordsResolvedError(t *testing.T) {
+	recorded := make(map[string]interface{})
+	ctx := helpers.WithInputRecorder(context.Background(), func(name string, value interface{}) {
+		recorded[name] = value
+	})
+
+	Run(ctx, map[string]interface{}{"code": "NOT_FOUND"}, stepOutputs)
+
+	expected := map[string]interface{}{"status": 500, "code": "NOT_FOUND", "message": "could not find actions for status 404"}
+	if !reflect.DeepEqual(recorded["error"], expected) {
+		t.Errorf(EXPECTED_BUT_GOT, expected, recorded["error"])
+	}
+}
@@ -0,0 +1,57 @@
+package helpers
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"sort"
+)
+
+// EncodeCSV writes a list of flat objects as CSV with a header row of every key, in sorted order.
+func EncodeCSV(value interface{}) ([]byte, error) {
+	items, ok := value.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("CSV body must be a list of objects")
+	}
+
+	rows := make([]map[string]interface{}, len(items))
+	columnSet := make(map[string]bool)
+	for i, item := range items {
+		row, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("CSV row %d is not an object", i)
+		}
+		for key, cell := range row {
+			switch cell.(type) {
+			case map[string]interface{}, []interface{}:
+				return nil, fmt.Errorf("CSV row %d field %q is not a flat value", i, key)
+			}
+			columnSet[key] = true
+		}
+		rows[i] = row
+	}
+	columns := make([]string, 0, len(columnSet))
+	for column := range columnSet {
+		columns = append(columns, column)
+	}
+	sort.Strings(columns)
+
+	var buffer bytes.Buffer
+	writer := csv.NewWriter(&buffer)
+	if err := writer.Write(columns); err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		record := make([]string, len(columns))
+		for i, column := range columns {
+			if cell, ok := row[column]; ok && cell != nil {
+				record[i] = fmt.Sprintf("%v", cell)
+			}
+		}
+		if err := writer.Write(record); err != nil {
+			return nil, err
+		}
+	}
+	writer.Flush()
+	return buffer.Bytes(), writer.Error()
+}
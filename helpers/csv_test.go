@@ -0,0 +1,33 @@
+package helpers
+
+import "testing"
+
+func TestEncodeCSV(t *testing.T) {
+	value := []interface{}{
+		map[string]interface{}{"id": "1", "fact": "Dogs, mostly, bark"},
+		map[string]interface{}{"id": "2", "age": float64(3), "fact": nil},
+	}
+	expected := "age,fact,id\n,\"Dogs, mostly, bark\",1\n3,,2\n"
+
+	output, err := EncodeCSV(value)
+
+	if err != nil {
+		t.Fatalf(EXPECTED_NIL_GOT, err)
+	}
+	if string(output) != expected {
+		t.Errorf(EXPECTED_BUT_GOT, expected, string(output))
+	}
+}
+
+func TestEncodeCSVInvalid(t *testing.T) {
+	values := []interface{}{
+		map[string]interface{}{"id": "1"},
+		[]interface{}{"not an object"},
+		[]interface{}{map[string]interface{}{"nested": map[string]interface{}{}}},
+	}
+	for _, value := range values {
+		if _, err := EncodeCSV(value); err == nil {
+			t.Errorf(EXPECTED_BUT_GOT, "error", value)
+		}
+	}
+}
@@ -0,0 +1,18 @@
+package helpers
+
+import "context"
+
+type inputRecorderKey struct{}
+
+// WithInputRecorder makes RecordInput pass the inputs of the step run with the context to record.
+func WithInputRecorder(ctx context.Context, record func(name string, value interface{})) context.Context {
+	return context.WithValue(ctx, inputRecorderKey{}, record)
+}
+
+// RecordInput reports an input of the running step as resolved from the step outputs, such as the
+// url of an http call, when the request is being debugged.
+func RecordInput(ctx context.Context, name string, value interface{}) {
+	if record, ok := ctx.Value(inputRecorderKey{}).(func(string, interface{})); ok {
+		record(name, value)
+	}
+}
@@ -0,0 +1,97 @@
+package helpers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+const ERROR_KIND_TIMEOUT = "timeout"
+const ERROR_KIND_CONNECTION = "connection"
+const ERROR_KIND_UPSTREAM_STATUS = "upstream_status"
+const ERROR_KIND_STEP = "step"
+
+type retryableStatusesKey struct{}
+
+// WithRetryableStatuses tells the step run with the context that the engine will try it again when
+// its upstream answers with one of the statuses.
+func WithRetryableStatuses(ctx context.Context, statuses map[int]bool) context.Context {
+	return context.WithValue(ctx, retryableStatusesKey{}, statuses)
+}
+
+// RetryableStatus reports whether the step run with ctx should fail with an UpstreamError on status,
+// even when it handles the status, because the engine will try it again.
+func RetryableStatus(ctx context.Context, status int) bool {
+	statuses, _ := ctx.Value(retryableStatusesKey{}).(map[int]bool)
+	return statuses[status]
+}
+
+// UpstreamError is returned by steps whose upstream answered with a status the step does not handle.
+type UpstreamError struct {
+	StatusCode int
+	RetryAfter time.Duration
+	Err        error
+}
+
+func (e *UpstreamError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *UpstreamError) Unwrap() error {
+	return e.Err
+}
+
+// ErrorKind classifies a step error as a timeout, connection, upstream status or plain step failure.
+func ErrorKind(err error) string {
+	var upstreamError *UpstreamError
+	if errors.As(err, &upstreamError) {
+		return ERROR_KIND_UPSTREAM_STATUS
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return ERROR_KIND_TIMEOUT
+	}
+	var netError net.Error
+	if errors.As(err, &netError) && netError.Timeout() {
+		return ERROR_KIND_TIMEOUT
+	}
+	var opError *net.OpError
+	if errors.As(err, &opError) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) {
+		return ERROR_KIND_CONNECTION
+	}
+	return ERROR_KIND_STEP
+}
+
+// ParseRetryAfter reads a Retry-After header given either in seconds or as an HTTP date.
+func ParseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil && date.After(now) {
+		return date.Sub(now)
+	}
+	return 0
+}
+
+// ParseDuration reads a duration given as a Go duration string or as seconds.
+func ParseDuration(value interface{}) (time.Duration, error) {
+	switch v := value.(type) {
+	case string:
+		return time.ParseDuration(v)
+	case float64:
+		return time.Duration(v * float64(time.Second)), nil
+	case int:
+		return time.Duration(v) * time.Second, nil
+	}
+	return 0, fmt.Errorf("invalid duration %v", value)
+}
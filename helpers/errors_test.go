@@ -0,0 +1,73 @@
+package helpers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestErrorKind(t *testing.T) {
+	tests := []struct {
+		err      error
+		expected string
+	}{
+		{&UpstreamError{StatusCode: 503, Err: errors.New("unavailable")}, ERROR_KIND_UPSTREAM_STATUS},
+		{fmt.Errorf("wrapped: %w", context.DeadlineExceeded), ERROR_KIND_TIMEOUT},
+		{&net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}, ERROR_KIND_CONNECTION},
+		{fmt.Errorf("read: %w", syscall.ECONNRESET), ERROR_KIND_CONNECTION},
+		{errors.New("invalid output format"), ERROR_KIND_STEP},
+	}
+	for _, test := range tests {
+		if kind := ErrorKind(test.err); kind != test.expected {
+			t.Errorf(EXPECTED_BUT_GOT, test.expected, kind)
+		}
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value    string
+		expected time.Duration
+	}{
+		{"", 0},
+		{"3", 3 * time.Second},
+		{"-1", 0},
+		{"Wed, 01 Jan 2025 00:00:10 GMT", 10 * time.Second},
+		{"Tue, 31 Dec 2024 23:59:00 GMT", 0},
+		{"soon", 0},
+	}
+	for _, test := range tests {
+		if result := ParseRetryAfter(test.value, now); result != test.expected {
+			t.Errorf(EXPECTED_BUT_GOT, test.expected, result)
+		}
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		value    interface{}
+		expected time.Duration
+	}{
+		{"150ms", 150 * time.Millisecond},
+		{1.5, 1500 * time.Millisecond},
+		{2, 2 * time.Second},
+	}
+	for _, test := range tests {
+		result, err := ParseDuration(test.value)
+		if err != nil {
+			t.Errorf(EXPECTED_NIL_GOT, err)
+		}
+		if result != test.expected {
+			t.Errorf(EXPECTED_BUT_GOT, test.expected, result)
+		}
+	}
+
+	if _, err := ParseDuration(true); err == nil {
+		t.Error(EXPECTED_ERROR_GOT_NIL)
+	}
+}
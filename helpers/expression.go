@@ -0,0 +1,52 @@
+package helpers
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/PaesslerAG/gval"
+	"github.com/PaesslerAG/jsonpath"
+)
+
+var expressionLanguage = gval.Full(
+	jsonpath.PlaceholderExtension(),
+	gval.Constant("null", nil),
+)
+
+// Expression is a parsed boolean expression such as `$.request.amount > 10`.
+type Expression struct {
+	source    string
+	evaluable gval.Evaluable
+}
+
+// CompileExpression parses a boolean expression once, e.g. when flows are compiled, so that it can
+// be evaluated on every run.
+func CompileExpression(expression string) (*Expression, error) {
+	evaluable, err := expressionLanguage.NewEvaluable(expression)
+	if err != nil {
+		return nil, fmt.Errorf("invalid expression %q: %w", expression, err)
+	}
+	return &Expression{source: expression, evaluable: evaluable}, nil
+}
+
+// Evaluate runs the expression against stepOutputs.
+func (e *Expression) Evaluate(ctx context.Context, stepOutputs interface{}) (bool, error) {
+	value, err := e.evaluable(ctx, stepOutputs)
+	if err != nil {
+		return false, fmt.Errorf("could not evaluate expression %q: %w", e.source, err)
+	}
+	result, ok := value.(bool)
+	if !ok {
+		return false, fmt.Errorf("expression %q did not evaluate to a boolean", e.source)
+	}
+	return result, nil
+}
+
+// Evaluate runs a boolean expression such as `$.request.amount > 10` against stepOutputs.
+func Evaluate(ctx context.Context, expression string, stepOutputs interface{}) (bool, error) {
+	compiled, err := CompileExpression(expression)
+	if err != nil {
+		return false, err
+	}
+	return compiled.Evaluate(ctx, stepOutputs)
+}
@@ -0,0 +1,69 @@
+package helpers
+
+import (
+	"context"
+	"testing"
+)
+
+func TestEvaluate(t *testing.T) {
+	stepOutputs := map[string]interface{}{
+		"request": map[string]interface{}{
+			"amount": "12",
+			"count":  int64(12),
+		},
+		"dogFacts": map[string]interface{}{
+			"response": map[string]interface{}{
+				"data": nil,
+			},
+		},
+	}
+	tests := []struct {
+		expression string
+		expected   bool
+	}{
+		{"$.request.amount > 10", true},
+		{"$.request.amount <= 10", false},
+		{"$.request.count > 10 && $.request.count == 12", true},
+		{"$.dogFacts.response.data == null", true},
+		{"$.request.amount > 10 && $.dogFacts.response.data != null", false},
+	}
+	for _, test := range tests {
+		result, err := Evaluate(context.Background(), test.expression, stepOutputs)
+		if err != nil {
+			t.Errorf(EXPECTED_NIL_GOT, err)
+		}
+		if result != test.expected {
+			t.Errorf(EXPECTED_BUT_GOT, test.expected, result)
+		}
+	}
+}
+
+func TestEvaluateNotBoolean(t *testing.T) {
+	_, err := Evaluate(context.Background(), "$.name", map[string]interface{}{"name": "world"})
+	if err == nil {
+		t.Error(EXPECTED_ERROR_GOT_NIL)
+	}
+}
+
+func TestEvaluateInvalidExpression(t *testing.T) {
+	_, err := Evaluate(context.Background(), "$.name >", map[string]interface{}{"name": "world"})
+	if err == nil {
+		t.Error(EXPECTED_ERROR_GOT_NIL)
+	}
+}
+
+func TestCompileExpression(t *testing.T) {
+	expression, err := CompileExpression("$.amount > 10")
+	if err != nil {
+		t.Fatalf(EXPECTED_NIL_GOT, err)
+	}
+	for amount, expected := range map[float64]bool{12: true, 8: false} {
+		result, err := expression.Evaluate(context.Background(), map[string]interface{}{"amount": amount})
+		if err != nil || result != expected {
+			t.Errorf(EXPECTED_BUT_GOT, expected, result)
+		}
+	}
+	if _, err := CompileExpression("$.amount >"); err == nil {
+		t.Error(EXPECTED_ERROR_GOT_NIL)
+	}
+}
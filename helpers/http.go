@@ -1,9 +1,15 @@
 package helpers
 
 import (
+	"encoding/json"
+	"io"
 	"net/http"
+	"strings"
 )
 
+const REQUEST_FORMAT_STRUCTURED = "structured"
+const REQUEST_FORMAT_LEGACY = "legacy"
+
 func ExtractParams(pathParams map[string]string, queryParams map[string][]string) map[string]interface{} {
 	params := make(map[string]interface{})
 	for key, value := range pathParams {
@@ -15,6 +21,66 @@ func ExtractParams(pathParams map[string]string, queryParams map[string][]string
 	return params
 }
 
+// ExtractRequest exposes everything about a request to flows as $.request.
+func ExtractRequest(r *http.Request, pathParams map[string]string) map[string]interface{} {
+	path := make(map[string]interface{}, len(pathParams))
+	for key, value := range pathParams {
+		path[key] = value
+	}
+
+	query := make(map[string]interface{})
+	for key, values := range r.URL.Query() {
+		items := make([]interface{}, len(values))
+		for i, value := range values {
+			items[i] = value
+		}
+		query[key] = items
+	}
+
+	headers := make(map[string]interface{}, len(r.Header))
+	for key, values := range r.Header {
+		headers[strings.ToLower(key)] = strings.Join(values, ", ")
+	}
+
+	cookies := make(map[string]interface{})
+	for _, cookie := range r.Cookies() {
+		cookies[cookie.Name] = cookie.Value
+	}
+
+	var rawBody []byte
+	if r.Body != nil {
+		rawBody, _ = io.ReadAll(r.Body)
+	}
+	var body interface{}
+	if len(rawBody) > 0 {
+		if err := json.Unmarshal(rawBody, &body); err != nil {
+			body = string(rawBody)
+		}
+	}
+
+	url := *r.URL
+	if url.Host == "" {
+		url.Host = r.Host
+	}
+	if url.Scheme == "" {
+		url.Scheme = "http"
+		if r.TLS != nil {
+			url.Scheme = "https"
+		}
+	}
+
+	return map[string]interface{}{
+		"method":  r.Method,
+		"url":     url.String(),
+		"path":    path,
+		"query":   query,
+		"headers": headers,
+		"cookies": cookies,
+		"body":    body,
+		"rawBody": string(rawBody),
+	}
+}
+
 func FillResponseHeaders(responseHeaders http.Header, w http.ResponseWriter) {
 	for k, v := range responseHeaders {
 		w.Header().Set(k, v[0])
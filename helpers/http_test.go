@@ -2,6 +2,7 @@ package helpers
 
 import (
 	"net/http"
+	"strings"
 	"testing"
 )
 
@@ -41,3 +42,54 @@ func TestExtractParams(t *testing.T) {
 		t.Errorf("Expected value2, got %s", params["key2"])
 	}
 }
+
+func TestExtractRequest(t *testing.T) {
+	r, _ := http.NewRequest("POST", "http://example.com/facts/1?tag=a&tag=b", strings.NewReader(`{"name": "world"}`))
+	r.Header.Set("X-Tenant-Id", "acme")
+	r.AddCookie(&http.Cookie{Name: "session", Value: "abc"})
+
+	request := ExtractRequest(r, map[string]string{"id": "1"})
+
+	if request["method"] != "POST" || request["url"] != "http://example.com/facts/1?tag=a&tag=b" {
+		t.Errorf("Expected POST http://example.com/facts/1?tag=a&tag=b, got %v %v", request["method"], request["url"])
+	}
+	if request["path"].(map[string]interface{})["id"] != "1" {
+		t.Errorf("Expected 1, got %v", request["path"])
+	}
+	tags := request["query"].(map[string]interface{})["tag"].([]interface{})
+	if len(tags) != 2 || tags[0] != "a" || tags[1] != "b" {
+		t.Errorf("Expected [a b], got %v", tags)
+	}
+	if request["headers"].(map[string]interface{})["x-tenant-id"] != "acme" {
+		t.Errorf("Expected acme, got %v", request["headers"])
+	}
+	if request["cookies"].(map[string]interface{})["session"] != "abc" {
+		t.Errorf("Expected abc, got %v", request["cookies"])
+	}
+	if request["body"].(map[string]interface{})["name"] != "world" {
+		t.Errorf("Expected world, got %v", request["body"])
+	}
+	if request["rawBody"] != `{"name": "world"}` {
+		t.Errorf("Expected raw body, got %v", request["rawBody"])
+	}
+}
+
+func TestExtractRequestNonJsonBody(t *testing.T) {
+	r, _ := http.NewRequest("POST", "http://example.com/", strings.NewReader("hello"))
+
+	request := ExtractRequest(r, nil)
+
+	if request["body"] != "hello" {
+		t.Errorf("Expected hello, got %v", request["body"])
+	}
+}
+
+func TestExtractRequestEmptyBody(t *testing.T) {
+	r, _ := http.NewRequest("GET", "http://example.com/", nil)
+
+	request := ExtractRequest(r, nil)
+
+	if request["body"] != nil {
+		t.Errorf("Expected nil, got %v", request["body"])
+	}
+}
@@ -1,30 +1,93 @@
 package helpers
 
 import (
+	"context"
+	"fmt"
 	"os"
 	"strings"
 
 	"github.com/sirupsen/logrus"
 )
 
-func SetupLogging() {
-	logrus.SetFormatter(&logrus.JSONFormatter{})
-	logrus.SetOutput(logrus.StandardLogger().Out)
-	logrus.SetReportCaller(true)
-
-	logLevelStr := os.Getenv("LOG_LEVEL")
-	logLevelStr = strings.ToLower(logLevelStr)
-
-	logLevels := map[string]logrus.Level{
-		"debug": logrus.DebugLevel,
-		"info":  logrus.InfoLevel,
-		"warn":  logrus.WarnLevel,
-		"error": logrus.ErrorLevel,
-		"fatal": logrus.FatalLevel,
-		"panic": logrus.PanicLevel,
+const LOG_FORMAT_JSON = "json"
+const LOG_FORMAT_TEXT = "text"
+
+const DEFAULT_LOG_LEVEL = logrus.InfoLevel
+
+type loggerKey struct{}
+
+// ParseLogLevel reads a level such as "debug" or "warn", defaulting to info when empty.
+func ParseLogLevel(value string) (logrus.Level, error) {
+	if strings.TrimSpace(value) == "" {
+		return DEFAULT_LOG_LEVEL, nil
+	}
+	return logrus.ParseLevel(strings.TrimSpace(value))
+}
+
+// configureLogger sets the level and format of a logger, redacting secrets and adding request ids.
+func configureLogger(logger *logrus.Logger, level string, format string) error {
+	parsedLevel, err := ParseLogLevel(level)
+	if err != nil {
+		return err
+	}
+	switch strings.ToLower(format) {
+	case LOG_FORMAT_JSON, "":
+		logger.SetFormatter(&logrus.JSONFormatter{})
+	case LOG_FORMAT_TEXT:
+		logger.SetFormatter(&logrus.TextFormatter{})
+	default:
+		return fmt.Errorf("unknown log format %q", format)
 	}
+	logger.SetLevel(parsedLevel)
+	logger.SetReportCaller(true)
+	logger.ReplaceHooks(make(logrus.LevelHooks))
+	logger.AddHook(RedactHook{})
+	logger.AddHook(RequestIDHook{})
+	return nil
+}
 
-	logLevel := logLevels[logLevelStr]
+// NewLogger returns a logger writing to stderr in the json or text format at the given level.
+func NewLogger(level string, format string) (*logrus.Logger, error) {
+	logger := logrus.New()
+	logger.SetOutput(os.Stderr)
+	if err := configureLogger(logger, level, format); err != nil {
+		return nil, err
+	}
+	return logger, nil
+}
 
-	logrus.SetLevel(logLevel)
+// SetupLogging configures the standard logger like NewLogger, so that the entries written outside
+// of requests, such as at startup, look like those of the server logger.
+func SetupLogging(level string, format string) error {
+	return configureLogger(logrus.StandardLogger(), level, format)
+}
+
+// LoggerWithLevel returns a logger sharing the output, format and hooks of logger at another level.
+func LoggerWithLevel(logger *logrus.Logger, level logrus.Level) *logrus.Logger {
+	if logger.GetLevel() == level {
+		return logger
+	}
+	return &logrus.Logger{
+		Out:          logger.Out,
+		Hooks:        logger.Hooks,
+		Formatter:    logger.Formatter,
+		ReportCaller: logger.ReportCaller,
+		Level:        level,
+		ExitFunc:     logger.ExitFunc,
+	}
+}
+
+// WithLogger stores the logger of the request being served in the context.
+func WithLogger(ctx context.Context, logger *logrus.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// Log returns an entry of the logger of the request being served, or of the standard logger,
+// carrying ctx for the hooks.
+func Log(ctx context.Context) *logrus.Entry {
+	logger, ok := ctx.Value(loggerKey{}).(*logrus.Logger)
+	if !ok {
+		logger = logrus.StandardLogger()
+	}
+	return logger.WithContext(ctx)
 }
@@ -1,10 +1,85 @@
 package helpers
 
 import (
+	"bytes"
+	"context"
+	"strings"
 	"testing"
+
+	"github.com/sirupsen/logrus"
 )
 
 func TestSetupLogging(t *testing.T) {
-	// setup logging
-	SetupLogging()
+	err := SetupLogging("warn", LOG_FORMAT_JSON)
+
+	if err != nil {
+		t.Fatalf(EXPECTED_NIL_GOT, err)
+	}
+	logger := logrus.StandardLogger()
+	if logger.GetLevel() != logrus.WarnLevel {
+		t.Errorf(EXPECTED_BUT_GOT, logrus.WarnLevel, logger.GetLevel())
+	}
+	if _, ok := logger.Formatter.(*logrus.JSONFormatter); !ok {
+		t.Errorf(EXPECTED_BUT_GOT, "a JSON formatter", logger.Formatter)
+	}
+}
+
+func TestNewLogger(t *testing.T) {
+	logger, err := NewLogger("", LOG_FORMAT_TEXT)
+
+	if err != nil {
+		t.Fatalf(EXPECTED_NIL_GOT, err)
+	}
+	if logger.GetLevel() != DEFAULT_LOG_LEVEL {
+		t.Errorf(EXPECTED_BUT_GOT, DEFAULT_LOG_LEVEL, logger.GetLevel())
+	}
+	if _, ok := logger.Formatter.(*logrus.TextFormatter); !ok {
+		t.Errorf(EXPECTED_BUT_GOT, "a text formatter", logger.Formatter)
+	}
+}
+
+func TestNewLoggerInvalid(t *testing.T) {
+	for _, config := range [][2]string{{"verbose", LOG_FORMAT_JSON}, {"info", "xml"}} {
+		if _, err := NewLogger(config[0], config[1]); err == nil {
+			t.Errorf("Expected error for %v, got nil", config)
+		}
+	}
+}
+
+func TestLoggerWithLevel(t *testing.T) {
+	var output bytes.Buffer
+	logger, err := NewLogger("info", LOG_FORMAT_JSON)
+	if err != nil {
+		t.Fatalf(EXPECTED_NIL_GOT, err)
+	}
+	logger.SetOutput(&output)
+
+	debugLogger := LoggerWithLevel(logger, logrus.DebugLevel)
+	debugLogger.WithContext(WithRequestID(context.Background(), "req-1")).Debug("kept")
+	logger.Debug("dropped")
+
+	if logger.GetLevel() != logrus.InfoLevel {
+		t.Errorf(EXPECTED_BUT_GOT, logrus.InfoLevel, logger.GetLevel())
+	}
+	lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf(EXPECTED_BUT_GOT, 1, lines)
+	}
+	if !strings.Contains(lines[0], `"requestId":"req-1"`) {
+		t.Errorf(EXPECTED_BUT_GOT, "requestId req-1", lines[0])
+	}
+	if LoggerWithLevel(logger, logrus.InfoLevel) != logger {
+		t.Errorf(EXPECTED_BUT_GOT, "the same logger", "another logger")
+	}
+}
+
+func TestLog(t *testing.T) {
+	logger := logrus.New()
+
+	if entry := Log(WithLogger(context.Background(), logger)); entry.Logger != logger {
+		t.Errorf(EXPECTED_BUT_GOT, logger, entry.Logger)
+	}
+	if entry := Log(context.Background()); entry.Logger != logrus.StandardLogger() {
+		t.Errorf(EXPECTED_BUT_GOT, logrus.StandardLogger(), entry.Logger)
+	}
 }
@@ -118,3 +118,18 @@ func TestCreateStepsMapInvalidStepDefinition6(t *testing.T) {
 		t.Errorf(EXPECTED_NIL_GOT, steps)
 	}
 }
+
+func TestCreateStepsMapMissingName(t *testing.T) {
+	stepsArray := []interface{}{
+		map[string]interface{}{
+			"type": "http",
+		},
+	}
+	steps, err := CreateStepsMap(stepsArray)
+	if err == nil {
+		t.Error(EXPECTED_ERROR_GOT_NIL)
+	}
+	if steps != nil {
+		t.Errorf(EXPECTED_NIL_GOT, steps)
+	}
+}
@@ -0,0 +1,183 @@
+package helpers
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi3"
+)
+
+// DecodeParameter decodes a path or query parameter into the JSON type of its schema,
+// following the parameter's style and explode settings.
+//
+// openapi3filter decodes parameters the same way while validating a request, but keeps the decoded
+// values to itself: ValidateRequest and ValidateParameter only return errors, and its decoders are
+// unexported. Parameters are therefore decoded again once validation accepted them, and the tests
+// check that every value openapi3filter accepts decodes to one that its schema accepts.
+func DecodeParameter(parameter *openapi3.Parameter, pathParams map[string]string, query url.Values) (interface{}, bool) {
+	if parameter.Schema == nil || parameter.Schema.Value == nil {
+		return nil, false
+	}
+	schema := parameter.Schema.Value
+	method, err := parameter.SerializationMethod()
+	if err != nil {
+		return nil, false
+	}
+
+	switch parameter.In {
+	case openapi3.ParameterInPath:
+		raw, ok := pathParams[parameter.Name]
+		if !ok {
+			return nil, false
+		}
+		return decodePathParameter(parameter.Name, raw, method, schema), true
+	case openapi3.ParameterInQuery:
+		return decodeQueryParameter(parameter.Name, query, method, schema)
+	}
+	return nil, false
+}
+
+func decodePathParameter(name string, raw string, method *openapi3.SerializationMethod, schema *openapi3.Schema) interface{} {
+	separator := ","
+	switch method.Style {
+	case openapi3.SerializationLabel:
+		raw = strings.TrimPrefix(raw, ".")
+		if method.Explode {
+			separator = "."
+		}
+	case openapi3.SerializationMatrix:
+		prefix := ";" + name + "="
+		if method.Explode && schema.Type.Is(openapi3.TypeArray) {
+			return coerceArray(strings.Split(strings.TrimPrefix(raw, prefix), prefix), schema)
+		}
+		if method.Explode && schema.Type.Is(openapi3.TypeObject) {
+			raw = strings.TrimPrefix(raw, ";")
+			separator = ";"
+		} else {
+			raw = strings.TrimPrefix(raw, prefix)
+		}
+	}
+
+	switch {
+	case schema.Type.Is(openapi3.TypeArray):
+		return coerceArray(strings.Split(raw, separator), schema)
+	case schema.Type.Is(openapi3.TypeObject):
+		return coerceObject(strings.Split(raw, separator), method.Explode, schema)
+	}
+	return coerce(raw, schema)
+}
+
+func decodeQueryParameter(name string, query url.Values, method *openapi3.SerializationMethod, schema *openapi3.Schema) (interface{}, bool) {
+	switch {
+	case schema.Type.Is(openapi3.TypeArray):
+		values, ok := query[name]
+		if !ok {
+			return nil, false
+		}
+		if method.Explode {
+			return coerceArray(values, schema), true
+		}
+		separator := ","
+		switch method.Style {
+		case openapi3.SerializationSpaceDelimited:
+			separator = " "
+		case openapi3.SerializationPipeDelimited:
+			separator = "|"
+		}
+		return coerceArray(strings.Split(values[0], separator), schema), true
+	case schema.Type.Is(openapi3.TypeObject):
+		object := make(map[string]interface{})
+		switch {
+		case method.Style == openapi3.SerializationDeepObject:
+			prefix := name + "["
+			for key, values := range query {
+				if strings.HasPrefix(key, prefix) && strings.HasSuffix(key, "]") {
+					property := key[len(prefix) : len(key)-1]
+					object[property] = coerce(values[0], propertySchema(schema, property))
+				}
+			}
+		case method.Explode:
+			for property, propertyRef := range schema.Properties {
+				if values, ok := query[property]; ok && propertyRef.Value != nil {
+					object[property] = coerce(values[0], propertyRef.Value)
+				}
+			}
+		default:
+			values, ok := query[name]
+			if !ok {
+				return nil, false
+			}
+			return coerceObject(strings.Split(values[0], ","), false, schema), true
+		}
+		if len(object) == 0 {
+			return nil, false
+		}
+		return object, true
+	}
+
+	values, ok := query[name]
+	if !ok {
+		return nil, false
+	}
+	return coerce(values[0], schema), true
+}
+
+func propertySchema(schema *openapi3.Schema, property string) *openapi3.Schema {
+	if propertyRef, ok := schema.Properties[property]; ok && propertyRef.Value != nil {
+		return propertyRef.Value
+	}
+	return &openapi3.Schema{}
+}
+
+func coerceArray(values []string, schema *openapi3.Schema) []interface{} {
+	items := &openapi3.Schema{}
+	if schema.Items != nil && schema.Items.Value != nil {
+		items = schema.Items.Value
+	}
+	array := make([]interface{}, len(values))
+	for i, value := range values {
+		array[i] = coerce(value, items)
+	}
+	return array
+}
+
+// coerceObject reads "key,value,key,value" or, exploded, "key=value,key=value" pairs.
+func coerceObject(parts []string, explode bool, schema *openapi3.Schema) map[string]interface{} {
+	object := make(map[string]interface{})
+	if explode {
+		for _, part := range parts {
+			if key, value, ok := strings.Cut(part, "="); ok {
+				object[key] = coerce(value, propertySchema(schema, key))
+			}
+		}
+		return object
+	}
+	for i := 0; i+1 < len(parts); i += 2 {
+		object[parts[i]] = coerce(parts[i+1], propertySchema(schema, parts[i]))
+	}
+	return object
+}
+
+// coerce converts a single value to a number or boolean when its schema asks for one. Integers
+// stay int64 so that large ids keep every digit.
+func coerce(value string, schema *openapi3.Schema) interface{} {
+	switch {
+	case schema.Type.Is(openapi3.TypeInteger):
+		if integer, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return integer
+		}
+		if number, err := strconv.ParseFloat(value, 64); err == nil {
+			return number
+		}
+	case schema.Type.Is(openapi3.TypeNumber):
+		if number, err := strconv.ParseFloat(value, 64); err == nil {
+			return number
+		}
+	case schema.Type.Is(openapi3.TypeBoolean):
+		if boolean, err := strconv.ParseBool(value); err == nil {
+			return boolean
+		}
+	}
+	return value
+}
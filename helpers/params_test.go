@@ -0,0 +1,96 @@
+package helpers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+)
+
+func parameter(in string, name string, style string, explode *bool, schema *openapi3.Schema) *openapi3.Parameter {
+	return &openapi3.Parameter{
+		In:      in,
+		Name:    name,
+		Style:   style,
+		Explode: explode,
+		Schema:  openapi3.NewSchemaRef("", schema),
+	}
+}
+
+func TestDecodeParameter(t *testing.T) {
+	explode := true
+	noExplode := false
+	integerArray := openapi3.NewArraySchema().WithItems(openapi3.NewIntegerSchema())
+	object := openapi3.NewObjectSchema().
+		WithProperty("min", openapi3.NewIntegerSchema()).
+		WithProperty("active", openapi3.NewBoolSchema())
+
+	tests := []struct {
+		name       string
+		parameter  *openapi3.Parameter
+		pathParams map[string]string
+		query      string
+		expected   interface{}
+		valid      bool
+	}{
+		{"integer query", parameter("query", "amount", "", nil, openapi3.NewIntegerSchema()), nil, "amount=5", int64(5), true},
+		{"boolean query", parameter("query", "active", "", nil, openapi3.NewBoolSchema()), nil, "active=true", true, true},
+		{"string query", parameter("query", "name", "", nil, openapi3.NewStringSchema()), nil, "name=5", "5", true},
+		{"exploded array", parameter("query", "ids", "", nil, integerArray), nil, "ids=1&ids=2", []interface{}{int64(1), int64(2)}, true},
+		{"form array", parameter("query", "ids", "form", &noExplode, integerArray), nil, "ids=1,2", []interface{}{int64(1), int64(2)}, true},
+		{"pipe array", parameter("query", "ids", "pipeDelimited", &noExplode, integerArray), nil, "ids=1|2", []interface{}{int64(1), int64(2)}, true},
+		{"deep object", parameter("query", "filter", "deepObject", &explode, object), nil, "filter[min]=3&filter[active]=false", map[string]interface{}{"min": int64(3), "active": false}, true},
+		{"form exploded object", parameter("query", "filter", "form", &explode, object), nil, "min=3&other=x", map[string]interface{}{"min": int64(3)}, true},
+		{"form object", parameter("query", "filter", "form", &noExplode, object), nil, "filter=min,3,active,true", map[string]interface{}{"min": int64(3), "active": true}, true},
+		{"integer path", parameter("path", "id", "", nil, openapi3.NewIntegerSchema()), map[string]string{"id": "42"}, "", int64(42), true},
+		{"simple array path", parameter("path", "ids", "", nil, integerArray), map[string]string{"ids": "1,2"}, "", []interface{}{int64(1), int64(2)}, true},
+		{"label array path", parameter("path", "ids", "label", &explode, integerArray), map[string]string{"ids": ".1.2"}, "", []interface{}{int64(1), int64(2)}, true},
+		{"matrix array path", parameter("path", "ids", "matrix", &explode, integerArray), map[string]string{"ids": ";ids=1;ids=2"}, "", []interface{}{int64(1), int64(2)}, true},
+		{"exploded object path", parameter("path", "filter", "", &explode, object), map[string]string{"filter": "min=3,active=true"}, "", map[string]interface{}{"min": int64(3), "active": true}, true},
+		{"invalid integer", parameter("query", "amount", "", nil, openapi3.NewIntegerSchema()), nil, "amount=many", "many", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			query, _ := url.ParseQuery(test.query)
+			value, found := DecodeParameter(test.parameter, test.pathParams, query)
+			if !found {
+				t.Fatalf(EXPECTED_BUT_GOT, test.expected, "not found")
+			}
+			if !reflect.DeepEqual(value, test.expected) {
+				t.Errorf(EXPECTED_BUT_GOT, test.expected, value)
+			}
+
+			// the decoding must agree with the one openapi3filter validates
+			err := openapi3filter.ValidateParameter(context.Background(), &openapi3filter.RequestValidationInput{
+				Request:    httptest.NewRequest(http.MethodGet, "/?"+test.query, nil),
+				PathParams: test.pathParams,
+			}, test.parameter)
+			if err != nil {
+				if test.valid {
+					t.Errorf(EXPECTED_NIL_GOT, err)
+				}
+				return
+			}
+			if err := test.parameter.Schema.Value.VisitJSON(value); err != nil {
+				t.Errorf(EXPECTED_NIL_GOT, err)
+			}
+		})
+	}
+}
+
+func TestDecodeParameterMissing(t *testing.T) {
+	_, found := DecodeParameter(parameter("query", "amount", "", nil, openapi3.NewIntegerSchema()), nil, url.Values{})
+	if found {
+		t.Error("Expected missing parameter not to be found")
+	}
+	_, found = DecodeParameter(&openapi3.Parameter{In: "query", Name: "amount"}, nil, url.Values{"amount": {"1"}})
+	if found {
+		t.Error("Expected parameter without schema not to be decoded")
+	}
+}
@@ -3,18 +3,27 @@ package helpers
 import (
 	"fmt"
 	"regexp"
+	"strconv"
 	"strings"
 
 	"github.com/PaesslerAG/jsonpath"
 )
 
+// formatValue renders a value replacing a path, writing numbers such as 1000000 without an exponent.
+func formatValue(value interface{}) string {
+	if number, ok := value.(float64); ok {
+		return strconv.FormatFloat(number, 'f', -1, 64)
+	}
+	return fmt.Sprintf("%v", value)
+}
+
 func Replace(input string, stepOutputs interface{}) string {
-	re := regexp.MustCompile(`\$\.[a-zA-Z0-9_\[\]\.]+`)
+	re := regexp.MustCompile(`\$(\.[a-zA-Z0-9_]+|\[[^\]]+\])+`)
 	matches := re.FindAllString(input, -1)
 
 	for _, match := range matches {
 		value, _ := jsonpath.Get(match, stepOutputs)
-		input = strings.ReplaceAll(input, match, fmt.Sprintf("%v", value))
+		input = strings.ReplaceAll(input, match, formatValue(value))
 	}
 
 	return input
@@ -116,3 +116,30 @@ func TestTransformBodyOutputInteger(t *testing.T) {
 		t.Errorf(EXPECTED_BUT_GOT, expected, result)
 	}
 }
+
+func TestReplaceBracketKey(t *testing.T) {
+	input := "Tenant $.headers[\"x-tenant-id\"] on $.hosts[0]"
+	values := map[string]interface{}{
+		"headers": map[string]interface{}{"x-tenant-id": "acme"},
+		"hosts":   []interface{}{"example.com"},
+	}
+	expected := "Tenant acme on example.com"
+	result := Replace(input, values)
+	if result != expected {
+		t.Errorf(EXPECTED_BUT_GOT, expected, result)
+	}
+}
+
+func TestReplaceNumbers(t *testing.T) {
+	input := "/items/$.float/$.integer/$.fraction"
+	values := map[string]interface{}{
+		"float":    float64(1000000),
+		"integer":  int64(9007199254740993),
+		"fraction": 0.5,
+	}
+	expected := "/items/1000000/9007199254740993/0.5"
+	result := Replace(input, values)
+	if result != expected {
+		t.Errorf(EXPECTED_BUT_GOT, expected, result)
+	}
+}
@@ -0,0 +1,59 @@
+package helpers
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"regexp"
+
+	"github.com/sirupsen/logrus"
+)
+
+const REQUEST_ID_HEADER = "X-Request-ID"
+
+// validRequestID limits accepted request ids to what is safe to log and forward.
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:@/+=-]{1,128}$`)
+
+type requestIDKey struct{}
+
+// NewRequestID returns a random version 4 UUID.
+func NewRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// ValidRequestID reports whether a request id sent by a client can be used as is.
+func ValidRequestID(id string) bool {
+	return validRequestID.MatchString(id)
+}
+
+// WithRequestID stores the id of the request being served in the context.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestIDFromContext returns the id of the request being served, or "".
+func RequestIDFromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// RequestIDHook adds the request id of the context of an entry to its fields.
+type RequestIDHook struct{}
+
+func (RequestIDHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (RequestIDHook) Fire(entry *logrus.Entry) error {
+	if id := RequestIDFromContext(entry.Context); id != "" {
+		entry.Data["requestId"] = id
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package helpers
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestNewRequestID(t *testing.T) {
+	id := NewRequestID()
+
+	if len(id) != 36 || id[14] != '4' || !ValidRequestID(id) {
+		t.Errorf(EXPECTED_BUT_GOT, "a version 4 UUID", id)
+	}
+	if id == NewRequestID() {
+		t.Errorf("Expected unique ids, got %s twice", id)
+	}
+}
+
+func TestValidRequestID(t *testing.T) {
+	for _, id := range []string{"req-1", "4bf92f35-77b3-4da6-a3ce-929d0e0e4736", "trace:abc/1+2="} {
+		if !ValidRequestID(id) {
+			t.Errorf(EXPECTED_BUT_GOT, true, id)
+		}
+	}
+	for _, id := range []string{"", "two words", "line\nbreak", `"quoted"`, strings.Repeat("a", 129)} {
+		if ValidRequestID(id) {
+			t.Errorf(EXPECTED_BUT_GOT, false, id)
+		}
+	}
+}
+
+func TestRequestIDHook(t *testing.T) {
+	var output bytes.Buffer
+	logger := logrus.New()
+	logger.SetOutput(&output)
+	logger.SetFormatter(&logrus.JSONFormatter{})
+	logger.AddHook(RequestIDHook{})
+
+	logger.WithContext(WithRequestID(context.Background(), "req-1")).Info("with id")
+	logger.WithContext(context.Background()).Info("without id")
+	logger.Info("without context")
+
+	lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+	if !strings.Contains(lines[0], `"requestId":"req-1"`) {
+		t.Errorf(EXPECTED_BUT_GOT, "requestId req-1", lines[0])
+	}
+	for _, line := range lines[1:] {
+		if strings.Contains(line, "requestId") {
+			t.Errorf(EXPECTED_BUT_GOT, "no requestId", line)
+		}
+	}
+}
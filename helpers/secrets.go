@@ -0,0 +1,188 @@
+package helpers
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+const REDACTED = "[REDACTED]"
+
+// MIN_REDACTED_LENGTH keeps very short secrets from redacting unrelated log text.
+const MIN_REDACTED_LENGTH = 4
+
+var secretReference = regexp.MustCompile(`\$\{([a-zA-Z][a-zA-Z0-9_-]*):([^}]+)\}`)
+
+// SecretProvider resolves the name part of a ${provider:name} reference.
+type SecretProvider interface {
+	Resolve(ctx context.Context, name string) (string, error)
+}
+
+// EnvProvider resolves ${env:NAME} from the environment.
+type EnvProvider struct{}
+
+func (EnvProvider) Resolve(ctx context.Context, name string) (string, error) {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return "", fmt.Errorf("environment variable %s is not set", name)
+	}
+	return value, nil
+}
+
+// FileProvider resolves ${file:/path} to the content of the file without its trailing newline.
+type FileProvider struct{}
+
+func (FileProvider) Resolve(ctx context.Context, name string) (string, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+var secretProviders = sync.Map{}
+
+// secretValues holds every resolved secret so that logs can be redacted.
+var secretValues = sync.Map{}
+
+func init() {
+	RegisterSecretProvider("env", EnvProvider{})
+	RegisterSecretProvider("file", FileProvider{})
+}
+
+// RegisterSecretProvider makes a provider available as ${name:...}.
+func RegisterSecretProvider(name string, provider SecretProvider) {
+	secretProviders.Store(name, provider)
+}
+
+// SecretReferences lists the provider names referenced in a value, recursing into objects and lists.
+func SecretReferences(value interface{}) []string {
+	var providers []string
+	switch v := value.(type) {
+	case string:
+		for _, match := range secretReference.FindAllStringSubmatch(v, -1) {
+			providers = append(providers, match[1])
+		}
+	case map[string]interface{}:
+		for _, item := range v {
+			providers = append(providers, SecretReferences(item)...)
+		}
+	case []interface{}:
+		for _, item := range v {
+			providers = append(providers, SecretReferences(item)...)
+		}
+	}
+	return providers
+}
+
+// HasSecretProvider reports whether a provider is registered under name.
+func HasSecretProvider(name string) bool {
+	_, ok := secretProviders.Load(name)
+	return ok
+}
+
+// ResolveSecrets replaces every ${provider:name} reference in a string.
+func ResolveSecrets(ctx context.Context, input string) (string, error) {
+	var resolveErr error
+	output := secretReference.ReplaceAllStringFunc(input, func(reference string) string {
+		match := secretReference.FindStringSubmatch(reference)
+		provider, ok := secretProviders.Load(match[1])
+		if !ok {
+			resolveErr = fmt.Errorf("unknown secret provider %q", match[1])
+			return reference
+		}
+		value, err := provider.(SecretProvider).Resolve(ctx, match[2])
+		if err != nil {
+			resolveErr = fmt.Errorf("could not resolve secret %s: %w", reference, err)
+			return reference
+		}
+		if len(value) >= MIN_REDACTED_LENGTH {
+			secretValues.Store(value, true)
+		}
+		return value
+	})
+	return output, resolveErr
+}
+
+// ResolveSecretsIn returns a copy of a step definition with every secret reference resolved.
+func ResolveSecretsIn(ctx context.Context, value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case string:
+		return ResolveSecrets(ctx, v)
+	case map[string]interface{}:
+		resolved := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			var err error
+			if resolved[key], err = ResolveSecretsIn(ctx, item); err != nil {
+				return nil, err
+			}
+		}
+		return resolved, nil
+	case []interface{}:
+		resolved := make([]interface{}, len(v))
+		for i, item := range v {
+			var err error
+			if resolved[i], err = ResolveSecretsIn(ctx, item); err != nil {
+				return nil, err
+			}
+		}
+		return resolved, nil
+	}
+	return value, nil
+}
+
+// Redact replaces every resolved secret in a string.
+func Redact(input string) string {
+	secretValues.Range(func(value, _ interface{}) bool {
+		input = strings.ReplaceAll(input, value.(string), REDACTED)
+		return true
+	})
+	return input
+}
+
+// RedactIn returns a copy of a decoded JSON value with every resolved secret replaced in its
+// strings and keys.
+func RedactIn(value interface{}) interface{} {
+	switch v := value.(type) {
+	case string:
+		return Redact(v)
+	case map[string]interface{}:
+		redacted := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			redacted[Redact(key)] = RedactIn(item)
+		}
+		return redacted
+	case []interface{}:
+		redacted := make([]interface{}, len(v))
+		for i, item := range v {
+			redacted[i] = RedactIn(item)
+		}
+		return redacted
+	}
+	return value
+}
+
+// RedactHook removes resolved secrets from log messages and fields.
+type RedactHook struct{}
+
+func (RedactHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (RedactHook) Fire(entry *logrus.Entry) error {
+	entry.Message = Redact(entry.Message)
+	for key, value := range entry.Data {
+		switch v := value.(type) {
+		case string:
+			entry.Data[key] = Redact(v)
+		case error:
+			entry.Data[key] = Redact(v.Error())
+		}
+	}
+	return nil
+}
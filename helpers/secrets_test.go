@@ -0,0 +1,90 @@
+package helpers
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestResolveSecrets(t *testing.T) {
+	t.Setenv("INTEGRON_TEST_KEY", "env-secret-value")
+	path := filepath.Join(t.TempDir(), "key")
+	if err := os.WriteFile(path, []byte("file-secret-value\n"), 0o600); err != nil {
+		t.Fatalf(EXPECTED_NIL_GOT, err)
+	}
+
+	output, err := ResolveSecretsIn(context.Background(), map[string]interface{}{
+		"url":     "https://dogapi.dog/api?key=${env:INTEGRON_TEST_KEY}",
+		"headers": []interface{}{"Bearer ${file:" + path + "}"},
+		"amount":  float64(1),
+	})
+
+	if err != nil {
+		t.Fatalf(EXPECTED_NIL_GOT, err)
+	}
+	resolved := output.(map[string]interface{})
+	if resolved["url"] != "https://dogapi.dog/api?key=env-secret-value" {
+		t.Errorf(EXPECTED_BUT_GOT, "the env secret", resolved["url"])
+	}
+	if resolved["headers"].([]interface{})[0] != "Bearer file-secret-value" {
+		t.Errorf(EXPECTED_BUT_GOT, "the file secret", resolved["headers"])
+	}
+	if resolved["amount"] != float64(1) {
+		t.Errorf(EXPECTED_BUT_GOT, 1, resolved["amount"])
+	}
+}
+
+func TestResolveSecretsErrors(t *testing.T) {
+	for _, input := range []string{"${env:INTEGRON_TEST_MISSING}", "${file:/does/not/exist}", "${vault:kv/key}"} {
+		if _, err := ResolveSecrets(context.Background(), input); err == nil {
+			t.Errorf("Expected error for %s, got nil", input)
+		}
+	}
+}
+
+func TestSecretReferences(t *testing.T) {
+	providers := SecretReferences(map[string]interface{}{
+		"url":  "${env:A}/${file:/b}",
+		"list": []interface{}{"${vault:c}", "$.request.body"},
+	})
+
+	sort.Strings(providers)
+	if strings.Join(providers, ",") != "env,file,vault" {
+		t.Errorf(EXPECTED_BUT_GOT, "env, file and vault", providers)
+	}
+	if HasSecretProvider("vault") || !HasSecretProvider("env") {
+		t.Error("Expected env and file to be the only providers")
+	}
+}
+
+type staticProvider map[string]string
+
+func (p staticProvider) Resolve(ctx context.Context, name string) (string, error) {
+	return p[name], nil
+}
+
+func TestRedactHook(t *testing.T) {
+	RegisterSecretProvider("test", staticProvider{"key": "hunter2-secret"})
+	if _, err := ResolveSecrets(context.Background(), "${test:key}"); err != nil {
+		t.Fatalf(EXPECTED_NIL_GOT, err)
+	}
+
+	var buffer bytes.Buffer
+	logger := logrus.New()
+	logger.SetOutput(&buffer)
+	logger.AddHook(RedactHook{})
+	logger.WithField("url", "https://x?key=hunter2-secret").Infof("Step outputs: %v", map[string]interface{}{"auth": "hunter2-secret"})
+
+	if strings.Contains(buffer.String(), "hunter2-secret") {
+		t.Errorf(EXPECTED_BUT_GOT, "redacted log", buffer.String())
+	}
+	if strings.Count(buffer.String(), REDACTED) != 2 {
+		t.Errorf(EXPECTED_BUT_GOT, "two redactions", buffer.String())
+	}
+}
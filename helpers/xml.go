@@ -0,0 +1,160 @@
+package helpers
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// DecodeXML turns an XML document into a JSON-like tree keyed by the root element name.
+// Attributes become "@name" keys, repeated elements become arrays, elements with only
+// text become strings and mixed text is kept under "#text".
+func DecodeXML(decoder *xml.Decoder) (interface{}, error) {
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return nil, errors.New("empty XML document")
+		}
+		if err != nil {
+			return nil, err
+		}
+		if start, ok := token.(xml.StartElement); ok {
+			value, err := decodeXMLElement(decoder, start)
+			if err != nil {
+				return nil, err
+			}
+			return map[string]interface{}{start.Name.Local: value}, nil
+		}
+	}
+}
+
+func decodeXMLElement(decoder *xml.Decoder, start xml.StartElement) (interface{}, error) {
+	element := make(map[string]interface{})
+	for _, attr := range start.Attr {
+		element["@"+attr.Name.Local] = attr.Value
+	}
+	var text strings.Builder
+
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			child, err := decodeXMLElement(decoder, t)
+			if err != nil {
+				return nil, err
+			}
+			name := t.Name.Local
+			switch existing := element[name].(type) {
+			case nil:
+				element[name] = child
+			case []interface{}:
+				element[name] = append(existing, child)
+			default:
+				element[name] = []interface{}{existing, child}
+			}
+		case xml.CharData:
+			text.Write(t)
+		case xml.EndElement:
+			content := strings.TrimSpace(text.String())
+			if len(element) == 0 {
+				return content, nil
+			}
+			if content != "" {
+				element["#text"] = content
+			}
+			return element, nil
+		}
+	}
+}
+
+// EncodeXML is the inverse of DecodeXML. Without a root name, a single-key object names the
+// root element and anything else is wrapped in <response>; lists at the root become <item> elements.
+func EncodeXML(root string, value interface{}) ([]byte, error) {
+	if root == "" {
+		root = "response"
+		if object, ok := value.(map[string]interface{}); ok && len(object) == 1 {
+			for key, child := range object {
+				if !strings.HasPrefix(key, "@") && key != "#text" {
+					root, value = key, child
+				}
+			}
+		}
+	}
+
+	var buffer bytes.Buffer
+	encoder := xml.NewEncoder(&buffer)
+	if items, ok := value.([]interface{}); ok {
+		start := xml.StartElement{Name: xml.Name{Local: root}}
+		if err := encoder.EncodeToken(start); err != nil {
+			return nil, err
+		}
+		if err := encodeXMLElement(encoder, "item", items); err != nil {
+			return nil, err
+		}
+		if err := encoder.EncodeToken(start.End()); err != nil {
+			return nil, err
+		}
+	} else if err := encodeXMLElement(encoder, root, value); err != nil {
+		return nil, err
+	}
+	if err := encoder.Flush(); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+func encodeXMLElement(encoder *xml.Encoder, name string, value interface{}) error {
+	if items, ok := value.([]interface{}); ok {
+		for _, item := range items {
+			if err := encodeXMLElement(encoder, name, item); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	start := xml.StartElement{Name: xml.Name{Local: name}}
+	object, isObject := value.(map[string]interface{})
+	keys := make([]string, 0, len(object))
+	for key := range object {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if strings.HasPrefix(key, "@") {
+			start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: key[1:]}, Value: fmt.Sprintf("%v", object[key])})
+		}
+	}
+	if err := encoder.EncodeToken(start); err != nil {
+		return err
+	}
+
+	switch {
+	case isObject:
+		if text, ok := object["#text"]; ok {
+			if err := encoder.EncodeToken(xml.CharData(fmt.Sprintf("%v", text))); err != nil {
+				return err
+			}
+		}
+		for _, key := range keys {
+			if strings.HasPrefix(key, "@") || key == "#text" {
+				continue
+			}
+			if err := encodeXMLElement(encoder, key, object[key]); err != nil {
+				return err
+			}
+		}
+	case value != nil:
+		if err := encoder.EncodeToken(xml.CharData(fmt.Sprintf("%v", value))); err != nil {
+			return err
+		}
+	}
+	return encoder.EncodeToken(start.End())
+}
@@ -0,0 +1,91 @@
+package helpers
+
+import (
+	"encoding/xml"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDecodeXML(t *testing.T) {
+	document := `<?xml version="1.0"?>
+<facts count="2">
+  <fact lang="en">Dogs bark</fact>
+  <fact>Dogs <b>wag</b></fact>
+  <empty/>
+</facts>`
+	expected := map[string]interface{}{
+		"facts": map[string]interface{}{
+			"@count": "2",
+			"fact": []interface{}{
+				map[string]interface{}{"@lang": "en", "#text": "Dogs bark"},
+				map[string]interface{}{"#text": "Dogs", "b": "wag"},
+			},
+			"empty": "",
+		},
+	}
+
+	output, err := DecodeXML(xml.NewDecoder(strings.NewReader(document)))
+
+	if err != nil {
+		t.Fatalf(EXPECTED_NIL_GOT, err)
+	}
+	if !reflect.DeepEqual(output, expected) {
+		t.Errorf(EXPECTED_BUT_GOT, expected, output)
+	}
+}
+
+func TestDecodeXMLEmpty(t *testing.T) {
+	if _, err := DecodeXML(xml.NewDecoder(strings.NewReader(""))); err == nil {
+		t.Error(EXPECTED_ERROR_GOT_NIL)
+	}
+}
+
+func TestEncodeXML(t *testing.T) {
+	value := map[string]interface{}{
+		"fact": map[string]interface{}{
+			"@id":  "1",
+			"text": "Woof",
+			"tag":  []interface{}{"a", "b"},
+		},
+	}
+	expected := `<fact id="1"><tag>a</tag><tag>b</tag><text>Woof</text></fact>`
+
+	output, err := EncodeXML("", value)
+
+	if err != nil {
+		t.Fatalf(EXPECTED_NIL_GOT, err)
+	}
+	if string(output) != expected {
+		t.Errorf(EXPECTED_BUT_GOT, expected, string(output))
+	}
+	decoded, err := DecodeXML(xml.NewDecoder(strings.NewReader(string(output))))
+	if err != nil {
+		t.Fatalf(EXPECTED_NIL_GOT, err)
+	}
+	if !reflect.DeepEqual(decoded, value) {
+		t.Errorf(EXPECTED_BUT_GOT, value, decoded)
+	}
+}
+
+func TestEncodeXMLRoot(t *testing.T) {
+	tests := []struct {
+		root     string
+		value    interface{}
+		expected string
+	}{
+		{"", map[string]interface{}{"a": "1", "b": nil}, "<response><a>1</a><b></b></response>"},
+		{"", []interface{}{float64(1), float64(2)}, "<response><item>1</item><item>2</item></response>"},
+		{"dogs", []interface{}{"Rex"}, "<dogs><item>Rex</item></dogs>"},
+		{"message", "Woof", "<message>Woof</message>"},
+	}
+	for _, test := range tests {
+		output, err := EncodeXML(test.root, test.value)
+		if err != nil {
+			t.Fatalf(EXPECTED_NIL_GOT, err)
+		}
+		if string(output) != test.expected {
+			t.Errorf(EXPECTED_BUT_GOT, test.expected, string(output))
+		}
+	}
+}
@@ -0,0 +1,392 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/integronlabs/integron/helpers"
+)
+
+const AUTH_OAUTH2 = "oauth2"
+const AUTH_BASIC = "basic"
+const AUTH_BEARER = "bearer"
+const AUTH_HMAC = "hmac"
+
+const DEFAULT_REFRESH_BEFORE = 30 * time.Second
+const DEFAULT_SIGNATURE_HEADER = "X-Signature"
+const DEFAULT_TIMESTAMP_HEADER = "X-Timestamp"
+const DEFAULT_CANONICAL = "{method}\n{path}\n{timestamp}\n{body}"
+const DEFAULT_MAX_TOKENS = 1000
+
+// tokens caches OAuth2 access tokens across requests and steps.
+var tokens = &tokenCache{max: DEFAULT_MAX_TOKENS, entries: make(map[string]*tokenEntry)}
+
+type tokenEntry struct {
+	mutex  sync.Mutex
+	token  string
+	expiry time.Time
+}
+
+// expired reports whether the entry holds no token usable at now. Callers hold its mutex.
+func (e *tokenEntry) expired(now time.Time) bool {
+	return e.token == "" || (!e.expiry.IsZero() && !now.Before(e.expiry))
+}
+
+// tokenCache keeps up to max entries; credentials resolved from requests would grow it without end.
+type tokenCache struct {
+	mutex   sync.Mutex
+	max     int
+	entries map[string]*tokenEntry
+}
+
+func (c *tokenCache) entry(key string, now time.Time) *tokenEntry {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	entry, ok := c.entries[key]
+	if !ok {
+		if len(c.entries) >= c.max {
+			c.evict(now)
+		}
+		entry = &tokenEntry{}
+		c.entries[key] = entry
+	}
+	return entry
+}
+
+// evict drops the expired entries, or any entry when none has expired. Entries fetching a
+// token are left alone; dropping an entry in use only costs its next request a new token.
+func (c *tokenCache) evict(now time.Time) {
+	for key, entry := range c.entries {
+		if !entry.mutex.TryLock() {
+			continue
+		}
+		if entry.expired(now) {
+			delete(c.entries, key)
+		}
+		entry.mutex.Unlock()
+	}
+	for key := range c.entries {
+		if len(c.entries) < c.max {
+			break
+		}
+		delete(c.entries, key)
+	}
+}
+
+// authConfig is the resolved auth block of an http step.
+type authConfig struct {
+	Type string
+	// basic
+	Username string
+	Password string
+	// bearer
+	Token string
+	// oauth2 client credentials
+	TokenURL      string
+	ClientID      string
+	ClientSecret  string
+	Scopes        []string
+	Params        map[string]string
+	ClientAuth    string
+	RefreshBefore time.Duration
+	// hmac
+	Secret          string
+	Algorithm       string
+	Encoding        string
+	Header          string
+	TimestampHeader string
+	Canonical       string
+}
+
+// parseAuth reads the auth block of a step, resolving every string against the step outputs.
+// Without step outputs, as when flows are compiled, strings are read as written.
+func parseAuth(value interface{}, stepOutputs map[string]interface{}) (*authConfig, error) {
+	definition, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid auth %v", value)
+	}
+	field := func(name string) string {
+		value, _ := definition[name].(string)
+		if stepOutputs == nil {
+			return value
+		}
+		return helpers.Replace(value, stepOutputs)
+	}
+
+	config := &authConfig{Type: field("type")}
+	switch config.Type {
+	case AUTH_BASIC:
+		config.Username, config.Password = field("username"), field("password")
+		if config.Username == "" {
+			return nil, fmt.Errorf("basic auth requires username")
+		}
+	case AUTH_BEARER:
+		config.Token = field("token")
+		if config.Token == "" {
+			return nil, fmt.Errorf("bearer auth requires token")
+		}
+	case AUTH_OAUTH2:
+		config.TokenURL, config.ClientID, config.ClientSecret = field("tokenUrl"), field("clientId"), field("clientSecret")
+		if config.TokenURL == "" || config.ClientID == "" {
+			return nil, fmt.Errorf("oauth2 auth requires tokenUrl and clientId")
+		}
+		if scopes, ok := definition["scopes"].([]interface{}); ok {
+			for _, scope := range scopes {
+				config.Scopes = append(config.Scopes, fmt.Sprintf("%v", scope))
+			}
+		}
+		config.Params = make(map[string]string)
+		if params, ok := definition["params"].(map[string]interface{}); ok {
+			for key, value := range params {
+				config.Params[key] = helpers.Replace(fmt.Sprintf("%v", value), stepOutputs)
+			}
+		}
+		config.ClientAuth = field("clientAuth")
+		if config.ClientAuth == "" {
+			config.ClientAuth = "header"
+		}
+		if config.ClientAuth != "header" && config.ClientAuth != "body" {
+			return nil, fmt.Errorf("invalid clientAuth %q", config.ClientAuth)
+		}
+		config.RefreshBefore = DEFAULT_REFRESH_BEFORE
+		if refreshBefore, ok := definition["refreshBefore"]; ok {
+			duration, err := helpers.ParseDuration(refreshBefore)
+			if err != nil {
+				return nil, fmt.Errorf("invalid refreshBefore %v", refreshBefore)
+			}
+			config.RefreshBefore = duration
+		}
+	case AUTH_HMAC:
+		config.Secret, config.Algorithm, config.Encoding = field("secret"), field("algorithm"), field("encoding")
+		config.Header, config.TimestampHeader, config.Canonical = field("header"), field("timestampHeader"), field("canonical")
+		if config.Secret == "" {
+			return nil, fmt.Errorf("hmac auth requires secret")
+		}
+		if config.Algorithm == "" {
+			config.Algorithm = "sha256"
+		}
+		if _, err := newHash(config.Algorithm); err != nil {
+			return nil, err
+		}
+		if config.Encoding == "" {
+			config.Encoding = "hex"
+		}
+		if config.Encoding != "hex" && config.Encoding != "base64" {
+			return nil, fmt.Errorf("invalid hmac encoding %q", config.Encoding)
+		}
+		if config.Header == "" {
+			config.Header = DEFAULT_SIGNATURE_HEADER
+		}
+		if config.TimestampHeader == "" {
+			config.TimestampHeader = DEFAULT_TIMESTAMP_HEADER
+		}
+		if config.Canonical == "" {
+			config.Canonical = DEFAULT_CANONICAL
+		}
+	default:
+		return nil, fmt.Errorf("unsupported auth type %q", config.Type)
+	}
+	return config, nil
+}
+
+func newHash(algorithm string) (func() hash.Hash, error) {
+	switch algorithm {
+	case "sha1":
+		return sha1.New, nil
+	case "sha256":
+		return sha256.New, nil
+	case "sha512":
+		return sha512.New, nil
+	}
+	return nil, fmt.Errorf("unsupported hmac algorithm %q", algorithm)
+}
+
+// authTransport authenticates every request sent through it.
+type authTransport struct {
+	config *authConfig
+	// client fetches OAuth2 tokens
+	client *http.Client
+	now    func() time.Time
+}
+
+// withAuth returns a copy of the client that authenticates its requests.
+func withAuth(client *http.Client, config *authConfig) *http.Client {
+	authClient := *client
+	authClient.Transport = &authTransport{config: config, client: client, now: time.Now}
+	return &authClient
+}
+
+func (t *authTransport) base() http.RoundTripper {
+	if t.client.Transport != nil {
+		return t.client.Transport
+	}
+	return http.DefaultTransport
+}
+
+// sameOrigin reports whether a request has the scheme and host of the request it was redirected from,
+// if any, so that credentials are never sent to another host.
+func sameOrigin(r *http.Request) bool {
+	first := r
+	for first.Response != nil && first.Response.Request != nil {
+		first = first.Response.Request
+	}
+	return first.URL.Scheme == r.URL.Scheme && first.URL.Host == r.URL.Host
+}
+
+func (t *authTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if !sameOrigin(r) {
+		return t.base().RoundTrip(r)
+	}
+	r = r.Clone(r.Context())
+	var entry *tokenEntry
+	switch t.config.Type {
+	case AUTH_BASIC:
+		r.SetBasicAuth(t.config.Username, t.config.Password)
+	case AUTH_BEARER:
+		r.Header.Set("Authorization", "Bearer "+t.config.Token)
+	case AUTH_OAUTH2:
+		entry = tokens.entry(t.config.cacheKey(), t.now())
+		token, err := t.token(r.Context(), entry)
+		if err != nil {
+			return nil, err
+		}
+		r.Header.Set("Authorization", "Bearer "+token)
+	case AUTH_HMAC:
+		if err := t.sign(r); err != nil {
+			return nil, err
+		}
+	}
+
+	response, err := t.base().RoundTrip(r)
+	if err == nil && entry != nil && response.StatusCode == http.StatusUnauthorized {
+		// the token was revoked or rotated, fetch a new one next time
+		entry.mutex.Lock()
+		entry.token = ""
+		entry.mutex.Unlock()
+	}
+	return response, err
+}
+
+func (c *authConfig) cacheKey() string {
+	sum := sha256.Sum256([]byte(strings.Join([]string{c.TokenURL, c.ClientID, c.ClientSecret, strings.Join(c.Scopes, " "), fmt.Sprintf("%v", c.Params)}, "\x00")))
+	return hex.EncodeToString(sum[:])
+}
+
+// scope identifies the credentials of the config without revealing them.
+func (c *authConfig) scope() string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%#v", *c)))
+	return hex.EncodeToString(sum[:])
+}
+
+// token returns the cached access token, fetching a new one when it expires within RefreshBefore.
+func (t *authTransport) token(ctx context.Context, entry *tokenEntry) (string, error) {
+	entry.mutex.Lock()
+	defer entry.mutex.Unlock()
+	if entry.token != "" && (entry.expiry.IsZero() || t.now().Add(t.config.RefreshBefore).Before(entry.expiry)) {
+		return entry.token, nil
+	}
+
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if len(t.config.Scopes) > 0 {
+		form.Set("scope", strings.Join(t.config.Scopes, " "))
+	}
+	for key, value := range t.config.Params {
+		form.Set(key, value)
+	}
+	if t.config.ClientAuth == "body" {
+		form.Set("client_id", t.config.ClientID)
+		form.Set("client_secret", t.config.ClientSecret)
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, t.config.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	request.Header.Set("Content-Type", CONTENT_TYPE_FORM)
+	request.Header.Set("Accept", CONTENT_TYPE_JSON)
+	if t.config.ClientAuth == "header" {
+		request.SetBasicAuth(url.QueryEscape(t.config.ClientID), url.QueryEscape(t.config.ClientSecret))
+	}
+
+	response, err := t.client.Do(request)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return "", &helpers.UpstreamError{
+			StatusCode: response.StatusCode,
+			Err:        fmt.Errorf("token endpoint answered %d", response.StatusCode),
+		}
+	}
+
+	var tokenResponse struct {
+		AccessToken string  `json:"access_token"`
+		ExpiresIn   float64 `json:"expires_in"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&tokenResponse); err != nil {
+		return "", fmt.Errorf("invalid token response: %w", err)
+	}
+	if tokenResponse.AccessToken == "" {
+		return "", fmt.Errorf("invalid token response: missing access_token")
+	}
+	entry.token = tokenResponse.AccessToken
+	entry.expiry = time.Time{}
+	if tokenResponse.ExpiresIn > 0 {
+		entry.expiry = t.now().Add(time.Duration(tokenResponse.ExpiresIn * float64(time.Second)))
+	}
+	return entry.token, nil
+}
+
+// sign adds an HMAC signature over the canonical form of the request.
+func (t *authTransport) sign(r *http.Request) error {
+	var body []byte
+	if r.Body != nil {
+		var err error
+		if body, err = io.ReadAll(r.Body); err != nil {
+			return err
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	timestamp := strconv.FormatInt(t.now().Unix(), 10)
+	bodyHash := sha256.Sum256(body)
+
+	canonical := strings.NewReplacer(
+		"{method}", r.Method,
+		"{path}", r.URL.EscapedPath(),
+		"{query}", r.URL.RawQuery,
+		"{host}", r.URL.Host,
+		"{timestamp}", timestamp,
+		"{body}", string(body),
+		"{bodySha256}", hex.EncodeToString(bodyHash[:]),
+	).Replace(t.config.Canonical)
+
+	newHash, _ := newHash(t.config.Algorithm)
+	mac := hmac.New(newHash, []byte(t.config.Secret))
+	mac.Write([]byte(canonical))
+	signature := hex.EncodeToString(mac.Sum(nil))
+	if t.config.Encoding == "base64" {
+		signature = base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	}
+
+	if strings.Contains(t.config.Canonical, "{timestamp}") {
+		r.Header.Set(t.config.TimestampHeader, timestamp)
+	}
+	r.Header.Set(t.config.Header, signature)
+	return nil
+}
@@ -0,0 +1,256 @@
+package http
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// tokenServer issues numbered access tokens valid for expiresIn seconds.
+func tokenServer(t *testing.T, expiresIn int) (*httptest.Server, *int32) {
+	var issued int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		clientID, clientSecret, _ := r.BasicAuth()
+		if r.FormValue("grant_type") != "client_credentials" || clientID != "integron" || clientSecret != "s3cret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		n := atomic.AddInt32(&issued, 1)
+		w.Header().Set(HEADER_CONTENT_TYPE, CONTENT_TYPE_JSON)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "token-" + string(rune('0'+n)),
+			"token_type":   "Bearer",
+			"expires_in":   expiresIn,
+			"scope":        r.FormValue("scope"),
+		})
+	}))
+	t.Cleanup(server.Close)
+	return server, &issued
+}
+
+// echoServer answers with the request headers it received.
+func echoServer(t *testing.T) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set(HEADER_CONTENT_TYPE, CONTENT_TYPE_JSON)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"authorization": r.Header.Get("Authorization"),
+			"signature":     r.Header.Get(DEFAULT_SIGNATURE_HEADER),
+			"timestamp":     r.Header.Get(DEFAULT_TIMESTAMP_HEADER),
+			"body":          string(body),
+		})
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func authStep(url string, auth map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"method": "POST",
+		"url":    url + "/facts",
+		"body":   map[string]interface{}{"amount": float64(1)},
+		"auth":   auth,
+		"responses": map[string]interface{}{
+			"200": map[string]interface{}{
+				"output": map[string]interface{}{
+					"authorization": "$.body.authorization",
+					"signature":     "$.body.signature",
+					"timestamp":     "$.body.timestamp",
+					"body":          "$.body.body",
+				},
+				"next": "next",
+			},
+		},
+	}
+}
+
+func runAuth(t *testing.T, stepMap map[string]interface{}) map[string]interface{} {
+	output, _, err := Run(context.Background(), &http.Client{}, stepMap, validOutputMap)
+	if err != nil {
+		t.Fatalf(EXPECTED_NIL_GOT, err)
+	}
+	return output.(map[string]interface{})
+}
+
+func TestRunOAuth2CachesToken(t *testing.T) {
+	tokenEndpoint, issued := tokenServer(t, 3600)
+	upstream := echoServer(t)
+	stepMap := authStep(upstream.URL, map[string]interface{}{
+		"type":         AUTH_OAUTH2,
+		"tokenUrl":     tokenEndpoint.URL,
+		"clientId":     "integron",
+		"clientSecret": "s3cret",
+		"scopes":       []interface{}{"facts:read"},
+	})
+
+	first := runAuth(t, stepMap)
+	second := runAuth(t, stepMap)
+
+	if first["authorization"] != "Bearer token-1" || second["authorization"] != "Bearer token-1" {
+		t.Errorf(EXPECTED_BUT_GOT, "Bearer token-1 twice", []interface{}{first["authorization"], second["authorization"]})
+	}
+	if *issued != 1 {
+		t.Errorf(EXPECTED_BUT_GOT, 1, *issued)
+	}
+}
+
+func TestRunOAuth2RefreshesBeforeExpiry(t *testing.T) {
+	// tokens expire within the refresh margin, so every request fetches a new one
+	tokenEndpoint, issued := tokenServer(t, 10)
+	upstream := echoServer(t)
+	stepMap := authStep(upstream.URL, map[string]interface{}{
+		"type":          AUTH_OAUTH2,
+		"tokenUrl":      tokenEndpoint.URL,
+		"clientId":      "integron",
+		"clientSecret":  "s3cret",
+		"refreshBefore": "1m",
+	})
+
+	runAuth(t, stepMap)
+	output := runAuth(t, stepMap)
+
+	if output["authorization"] != "Bearer token-2" || *issued != 2 {
+		t.Errorf(EXPECTED_BUT_GOT, "Bearer token-2", output["authorization"])
+	}
+}
+
+func TestRunOAuth2TokenFailure(t *testing.T) {
+	tokenEndpoint, _ := tokenServer(t, 3600)
+	stepMap := authStep(echoServer(t).URL, map[string]interface{}{
+		"type":         AUTH_OAUTH2,
+		"tokenUrl":     tokenEndpoint.URL,
+		"clientId":     "integron",
+		"clientSecret": "wrong",
+	})
+
+	_, next, err := Run(context.Background(), &http.Client{}, stepMap, validOutputMap)
+
+	if err == nil {
+		t.Fatal(EXPECTED_ERROR_GOT_NIL)
+	}
+	if next != "error" {
+		t.Errorf(EXPECTED_BUT_GOT, "error", next)
+	}
+}
+
+func TestRunBasicAndBearerAuth(t *testing.T) {
+	upstream := echoServer(t)
+
+	basic := runAuth(t, authStep(upstream.URL, map[string]interface{}{"type": AUTH_BASIC, "username": "integron", "password": "$.output.message"}))
+	bearer := runAuth(t, authStep(upstream.URL, map[string]interface{}{"type": AUTH_BEARER, "token": "static-token"}))
+
+	if basic["authorization"] != "Basic aW50ZWdyb246d29ybGQ=" {
+		t.Errorf(EXPECTED_BUT_GOT, "Basic aW50ZWdyb246d29ybGQ=", basic["authorization"])
+	}
+	if bearer["authorization"] != "Bearer static-token" {
+		t.Errorf(EXPECTED_BUT_GOT, "Bearer static-token", bearer["authorization"])
+	}
+}
+
+func TestRunAuthNotSentToRedirectedHost(t *testing.T) {
+	other := echoServer(t)
+	var sameHost string
+	var upstream *httptest.Server
+	upstream = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/facts" {
+			http.Redirect(w, r, upstream.URL+"/moved", http.StatusFound)
+			return
+		}
+		sameHost = r.Header.Get("Authorization")
+		http.Redirect(w, r, other.URL+"/facts", http.StatusFound)
+	}))
+	defer upstream.Close()
+
+	output := runAuth(t, authStep(upstream.URL, map[string]interface{}{"type": AUTH_BEARER, "token": "static-token"}))
+
+	if sameHost != "Bearer static-token" {
+		t.Errorf(EXPECTED_BUT_GOT, "Bearer static-token", sameHost)
+	}
+	if output["authorization"] != "" {
+		t.Errorf(EXPECTED_BUT_GOT, "no credentials for another host", output["authorization"])
+	}
+}
+
+func TestRunHmacSigning(t *testing.T) {
+	upstream := echoServer(t)
+
+	output := runAuth(t, authStep(upstream.URL, map[string]interface{}{"type": AUTH_HMAC, "secret": "k"}))
+
+	mac := hmac.New(sha256.New, []byte("k"))
+	mac.Write([]byte("POST\n/facts\n" + output["timestamp"].(string) + "\n" + output["body"].(string)))
+	expected := hex.EncodeToString(mac.Sum(nil))
+	if output["signature"] != expected {
+		t.Errorf(EXPECTED_BUT_GOT, expected, output["signature"])
+	}
+}
+
+func TestHmacCanonicalForm(t *testing.T) {
+	config, err := parseAuth(map[string]interface{}{
+		"type":      AUTH_HMAC,
+		"secret":    "k",
+		"encoding":  "base64",
+		"header":    "X-Sig",
+		"canonical": "{method} {path}?{query} {bodySha256}",
+	}, validOutputMap)
+	if err != nil {
+		t.Fatalf(EXPECTED_NIL_GOT, err)
+	}
+	transport := &authTransport{config: config, now: func() time.Time { return time.Unix(0, 0) }}
+	request, _ := http.NewRequest(http.MethodGet, EXAMPLE_URL+"/facts?limit=1", nil)
+
+	if err := transport.sign(request); err != nil {
+		t.Fatalf(EXPECTED_NIL_GOT, err)
+	}
+	// HMAC-SHA256("k", "GET /facts?limit=1 " + SHA-256 of the empty body)
+	expected := "1c62LQZFCqysqWWMLWBLDDPKsA+QAC6kM0OH9JI7b34="
+	if signature := request.Header.Get("X-Sig"); signature != expected {
+		t.Errorf(EXPECTED_BUT_GOT, expected, signature)
+	}
+	if request.Header.Get(DEFAULT_TIMESTAMP_HEADER) != "" {
+		t.Error("Expected no timestamp header when the canonical form has no timestamp")
+	}
+}
+
+func TestTokenCacheEvicts(t *testing.T) {
+	now := time.Now()
+	cache := &tokenCache{max: 2, entries: make(map[string]*tokenEntry)}
+	cache.entry("expired", now).token = "token-1"
+	cache.entries["expired"].expiry = now.Add(-time.Second)
+	cache.entry("valid", now).token = "token-2"
+
+	cache.entry("new", now)
+
+	if _, ok := cache.entries["expired"]; ok || cache.entries["valid"] == nil || len(cache.entries) != 2 {
+		t.Errorf(EXPECTED_BUT_GOT, "the expired token evicted", cache.entries)
+	}
+
+	cache.entry("newer", now)
+
+	if len(cache.entries) != 2 || cache.entries["newer"] == nil {
+		t.Errorf(EXPECTED_BUT_GOT, "2 entries", cache.entries)
+	}
+}
+
+func TestParseAuthInvalid(t *testing.T) {
+	for _, definition := range []interface{}{
+		"basic",
+		map[string]interface{}{"type": "digest"},
+		map[string]interface{}{"type": AUTH_BASIC},
+		map[string]interface{}{"type": AUTH_BEARER},
+		map[string]interface{}{"type": AUTH_OAUTH2, "tokenUrl": EXAMPLE_URL},
+		map[string]interface{}{"type": AUTH_OAUTH2, "tokenUrl": EXAMPLE_URL, "clientId": "a", "clientAuth": "jwt"},
+		map[string]interface{}{"type": AUTH_HMAC, "secret": "k", "algorithm": "md5"},
+	} {
+		if _, err := parseAuth(definition, validOutputMap); err == nil {
+			t.Errorf("Expected error for %v, got nil", definition)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package http
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/url"
+	"strings"
+
+	"github.com/integronlabs/integron/helpers"
+)
+
+const CONTENT_TYPE_JSON = "application/json"
+const CONTENT_TYPE_FORM = "application/x-www-form-urlencoded"
+const CONTENT_TYPE_MULTIPART = "multipart/form-data"
+const CONTENT_TYPE_TEXT = "text/plain"
+
+// encodeRequestBody serializes the transformed body for the given content type and
+// returns it with the Content-Type header to send.
+func encodeRequestBody(contentType string, body interface{}) (string, string, error) {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case mediaType == CONTENT_TYPE_FORM:
+		values, ok := body.(map[string]interface{})
+		if !ok {
+			return "", "", fmt.Errorf("form body must be an object")
+		}
+		form := url.Values{}
+		for key, value := range values {
+			if items, ok := value.([]interface{}); ok {
+				for _, item := range items {
+					form.Add(key, fmt.Sprintf("%v", item))
+				}
+				continue
+			}
+			form.Set(key, fmt.Sprintf("%v", value))
+		}
+		return form.Encode(), contentType, nil
+	case mediaType == CONTENT_TYPE_MULTIPART:
+		return encodeMultipart(body)
+	case strings.HasPrefix(mediaType, "text/") || strings.HasSuffix(mediaType, "xml"):
+		if body == nil {
+			return "", contentType, nil
+		}
+		return fmt.Sprintf("%v", body), contentType, nil
+	}
+	requestBodyJson, err := json.Marshal(body)
+	if err != nil {
+		return "", "", err
+	}
+	return string(requestBodyJson), contentType, nil
+}
+
+// encodeMultipart writes each field as a form field, or as a file part when it is an
+// object with `filename` and `content`.
+func encodeMultipart(body interface{}) (string, string, error) {
+	values, ok := body.(map[string]interface{})
+	if !ok {
+		return "", "", fmt.Errorf("multipart body must be an object")
+	}
+	var buffer bytes.Buffer
+	writer := multipart.NewWriter(&buffer)
+	for key, value := range values {
+		file, ok := value.(map[string]interface{})
+		if !ok {
+			if err := writer.WriteField(key, fmt.Sprintf("%v", value)); err != nil {
+				return "", "", err
+			}
+			continue
+		}
+		filename, _ := file["filename"].(string)
+		part, err := writer.CreateFormFile(key, filename)
+		if err != nil {
+			return "", "", err
+		}
+		if _, err := io.WriteString(part, fmt.Sprintf("%v", file["content"])); err != nil {
+			return "", "", err
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return "", "", err
+	}
+	return buffer.String(), writer.FormDataContentType(), nil
+}
+
+// decodeResponseBody reads an upstream body according to its Content-Type: JSON as is,
+// XML as a JSON-like tree, text as a string, empty bodies as null and anything else as base64.
+func decodeResponseBody(contentType string, body io.Reader) (interface{}, error) {
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil, nil
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case mediaType == "" || mediaType == CONTENT_TYPE_JSON || strings.HasSuffix(mediaType, "+json"):
+		var responseData interface{}
+		if err := json.NewDecoder(bytes.NewReader(data)).Decode(&responseData); err != nil {
+			return nil, err
+		}
+		return responseData, nil
+	case mediaType == "application/xml" || mediaType == "text/xml" || strings.HasSuffix(mediaType, "+xml"):
+		return helpers.DecodeXML(xml.NewDecoder(bytes.NewReader(data)))
+	case strings.HasPrefix(mediaType, "text/") && mediaType != "text/plain":
+		return string(data), nil
+	}
+
+	// upstreams often send JSON as text/plain or with an unknown content type
+	if trimmed := bytes.TrimSpace(data); trimmed[0] == '{' || trimmed[0] == '[' {
+		var responseData interface{}
+		if err := json.Unmarshal(trimmed, &responseData); err == nil {
+			return responseData, nil
+		}
+	}
+	if mediaType == "text/plain" {
+		return string(data), nil
+	}
+	return base64.StdEncoding.EncodeToString(data), nil
+}
@@ -0,0 +1,199 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type RecordingRoundTripper struct {
+	Request      *http.Request
+	RequestBody  string
+	MockResponse *http.Response
+}
+
+func (m *RecordingRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	m.Request = req
+	if req.Body != nil {
+		body, _ := io.ReadAll(req.Body)
+		m.RequestBody = string(body)
+	}
+	return m.MockResponse, nil
+}
+
+func TestDecodeResponseBody(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		expected    interface{}
+	}{
+		{"json", "application/json; charset=utf-8", `{"a": 1}`, map[string]interface{}{"a": float64(1)}},
+		{"problem json", "application/problem+json", `{"a": 1}`, map[string]interface{}{"a": float64(1)}},
+		{"missing content type", "", `[1]`, []interface{}{float64(1)}},
+		{"text", "text/plain", "hello", "hello"},
+		{"json as text", "text/plain; charset=utf-8", `{"a": 1}`, map[string]interface{}{"a": float64(1)}},
+		{"json with unknown content type", "application/octet-stream", `[1]`, []interface{}{float64(1)}},
+		{"text like json", "text/plain", "{not json", "{not json"},
+		{"html", "text/html", `["not", "sniffed"]`, `["not", "sniffed"]`},
+		{"empty", "application/json", "", nil},
+		{"binary", "application/octet-stream", "\x00\x01", "AAE="},
+		{"xml", "application/xml", `<fact id="1"><text>Woof</text><tag>a</tag><tag>b</tag></fact>`, map[string]interface{}{
+			"fact": map[string]interface{}{
+				"@id":  "1",
+				"text": "Woof",
+				"tag":  []interface{}{"a", "b"},
+			},
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			output, err := decodeResponseBody(test.contentType, strings.NewReader(test.body))
+			if err != nil {
+				t.Fatalf(EXPECTED_NIL_GOT, err)
+			}
+			if !reflect.DeepEqual(output, test.expected) {
+				t.Errorf(EXPECTED_BUT_GOT, test.expected, output)
+			}
+		})
+	}
+}
+
+func TestDecodeResponseBodyInvalidXml(t *testing.T) {
+	if _, err := decodeResponseBody("text/xml", strings.NewReader("<fact>")); err == nil {
+		t.Error(EXPECTED_ERROR_GOT_NIL)
+	}
+}
+
+func TestEncodeRequestBodyForm(t *testing.T) {
+	body, contentType, err := encodeRequestBody(CONTENT_TYPE_FORM, map[string]interface{}{
+		"grant_type": "client_credentials",
+		"scope":      []interface{}{"read", "write"},
+	})
+	if err != nil {
+		t.Fatalf(EXPECTED_NIL_GOT, err)
+	}
+	if contentType != CONTENT_TYPE_FORM {
+		t.Errorf(EXPECTED_BUT_GOT, CONTENT_TYPE_FORM, contentType)
+	}
+	expected := "grant_type=client_credentials&scope=read&scope=write"
+	if body != expected {
+		t.Errorf(EXPECTED_BUT_GOT, expected, body)
+	}
+}
+
+func TestEncodeRequestBodyFormInvalid(t *testing.T) {
+	if _, _, err := encodeRequestBody(CONTENT_TYPE_FORM, "not an object"); err == nil {
+		t.Error(EXPECTED_ERROR_GOT_NIL)
+	}
+}
+
+func TestEncodeRequestBodyMultipart(t *testing.T) {
+	body, contentType, err := encodeRequestBody(CONTENT_TYPE_MULTIPART, map[string]interface{}{
+		"name": "Rex",
+		"photo": map[string]interface{}{
+			"filename": "rex.txt",
+			"content":  "woof",
+		},
+	})
+	if err != nil {
+		t.Fatalf(EXPECTED_NIL_GOT, err)
+	}
+	_, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		t.Fatalf(EXPECTED_NIL_GOT, err)
+	}
+	form, err := multipart.NewReader(strings.NewReader(body), params["boundary"]).ReadForm(1024)
+	if err != nil {
+		t.Fatalf(EXPECTED_NIL_GOT, err)
+	}
+	if form.Value["name"][0] != "Rex" {
+		t.Errorf(EXPECTED_BUT_GOT, "Rex", form.Value["name"])
+	}
+	if form.File["photo"][0].Filename != "rex.txt" {
+		t.Errorf(EXPECTED_BUT_GOT, "rex.txt", form.File["photo"][0].Filename)
+	}
+}
+
+func TestRunTextRequestAndXmlResponse(t *testing.T) {
+	header := make(http.Header)
+	header.Set(HEADER_CONTENT_TYPE, "application/xml")
+	transport := &RecordingRoundTripper{MockResponse: &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       io.NopCloser(bytes.NewBufferString(`<reply><message>hello</message></reply>`)),
+		Header:     header,
+	}}
+	stepMap := map[string]interface{}{
+		"method":      "POST",
+		"url":         EXAMPLE_URL,
+		"contentType": CONTENT_TYPE_TEXT,
+		"body":        "hello $.output.message",
+		"responses": map[string]interface{}{
+			"200": map[string]interface{}{
+				"output": map[string]interface{}{
+					"message": "$.body.reply.message",
+				},
+				"next": "next",
+			},
+		},
+	}
+
+	output, next, err := Run(context.Background(), &http.Client{Transport: transport}, stepMap, validOutputMap)
+
+	if err != nil {
+		t.Fatalf(EXPECTED_NIL_GOT, err)
+	}
+	if transport.RequestBody != "hello world" {
+		t.Errorf(EXPECTED_BUT_GOT, "hello world", transport.RequestBody)
+	}
+	if contentType := transport.Request.Header.Get(HEADER_CONTENT_TYPE); contentType != CONTENT_TYPE_TEXT {
+		t.Errorf(EXPECTED_BUT_GOT, CONTENT_TYPE_TEXT, contentType)
+	}
+	expected := map[string]interface{}{"message": "hello"}
+	if !reflect.DeepEqual(output, expected) {
+		t.Errorf(EXPECTED_BUT_GOT, expected, output)
+	}
+	if next != "next" {
+		t.Errorf(EXPECTED_BUT_GOT, "next", next)
+	}
+}
+
+func TestRunNoContentResponse(t *testing.T) {
+	transport := &RecordingRoundTripper{MockResponse: &http.Response{
+		StatusCode: http.StatusNoContent,
+		Body:       io.NopCloser(bytes.NewBufferString("")),
+		Header:     make(http.Header),
+	}}
+	stepMap := map[string]interface{}{
+		"method": "DELETE",
+		"url":    EXAMPLE_URL,
+		"responses": map[string]interface{}{
+			"204": map[string]interface{}{
+				"output": map[string]interface{}{
+					"deleted": "$.status",
+					"body":    "$.body",
+				},
+				"next": "next",
+			},
+		},
+	}
+
+	output, _, err := Run(context.Background(), &http.Client{Transport: transport}, stepMap, validOutputMap)
+
+	if err != nil {
+		t.Fatalf(EXPECTED_NIL_GOT, err)
+	}
+	if transport.RequestBody != "" {
+		t.Errorf(EXPECTED_BUT_GOT, "", transport.RequestBody)
+	}
+	expected := map[string]interface{}{"deleted": http.StatusNoContent, "body": nil}
+	if !reflect.DeepEqual(output, expected) {
+		t.Errorf(EXPECTED_BUT_GOT, expected, output)
+	}
+}
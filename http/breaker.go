@@ -0,0 +1,286 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/integronlabs/integron/helpers"
+	"github.com/sirupsen/logrus"
+)
+
+const BREAKER_CLOSED = "closed"
+const BREAKER_OPEN = "open"
+const BREAKER_HALF_OPEN = "half-open"
+
+const BREAKER_KIND_UPSTREAM = "upstream"
+const BREAKER_KIND_HOST = "host"
+
+// ErrCircuitOpen is returned without calling the upstream while its breaker is open.
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// breakers holds every breaker so their state can be inspected.
+var breakers = &breakerRegistry{entries: make(map[string]*Breaker)}
+
+// BreakerPolicy is the parsed `circuitBreaker` block of an upstream or step.
+type BreakerPolicy struct {
+	// FailureThreshold consecutive failures open the breaker.
+	FailureThreshold int
+	// CoolDown is how long the breaker stays open before letting probes through.
+	CoolDown time.Duration
+	// HalfOpenProbes concurrent probes are let through, and must all succeed to close the breaker.
+	HalfOpenProbes int
+}
+
+// ParseBreakerPolicy reads a `circuitBreaker` block, filling in defaults for missing fields.
+func ParseBreakerPolicy(value interface{}) (*BreakerPolicy, error) {
+	breakerMap, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("invalid circuitBreaker format")
+	}
+	policy := &BreakerPolicy{FailureThreshold: 5, CoolDown: 30 * time.Second, HalfOpenProbes: 1}
+	for key, target := range map[string]*int{"failureThreshold": &policy.FailureThreshold, "halfOpenProbes": &policy.HalfOpenProbes} {
+		if value, ok := breakerMap[key]; ok {
+			n, ok := value.(float64)
+			if !ok || n < 1 || n != float64(int(n)) {
+				return nil, fmt.Errorf("invalid circuitBreaker %s %v", key, value)
+			}
+			*target = int(n)
+		}
+	}
+	if value, ok := breakerMap["coolDown"]; ok {
+		duration, err := helpers.ParseDuration(value)
+		if err != nil || duration <= 0 {
+			return nil, fmt.Errorf("invalid circuitBreaker coolDown %v", value)
+		}
+		policy.CoolDown = duration
+	}
+	return policy, nil
+}
+
+// Breaker stops calls to a failing upstream until it has had time to recover.
+type Breaker struct {
+	Kind   string
+	Name   string
+	policy BreakerPolicy
+	now    func() time.Time
+
+	mutex     sync.Mutex
+	state     string
+	failures  int
+	openedAt  time.Time
+	probes    int
+	successes int
+	// generation changes with every transition, so outcomes of calls let through in an earlier
+	// state are not counted against the current one
+	generation uint64
+}
+
+// BreakerState is a snapshot of a breaker for the admin endpoint.
+type BreakerState struct {
+	Kind             string     `json:"kind"`
+	Name             string     `json:"name"`
+	State            string     `json:"state"`
+	Failures         int        `json:"failures"`
+	FailureThreshold int        `json:"failureThreshold"`
+	OpenedAt         *time.Time `json:"openedAt,omitempty"`
+	RetryAt          *time.Time `json:"retryAt,omitempty"`
+}
+
+func newBreaker(kind string, name string, policy BreakerPolicy) *Breaker {
+	return &Breaker{Kind: kind, Name: name, policy: policy, now: time.Now, state: BREAKER_CLOSED}
+}
+
+// allow reports whether a call may go through, taking a probe slot when the breaker is half-open,
+// and returns the generation to record the outcome of the call with.
+func (b *Breaker) allow(ctx context.Context) (uint64, bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.state == BREAKER_OPEN {
+		if b.now().Before(b.openedAt.Add(b.policy.CoolDown)) {
+			return b.generation, false
+		}
+		b.transition(ctx, BREAKER_HALF_OPEN)
+		b.probes, b.successes = 0, 0
+	}
+	if b.state == BREAKER_HALF_OPEN {
+		if b.probes+b.successes >= b.policy.HalfOpenProbes {
+			return b.generation, false
+		}
+		b.probes++
+	}
+	return b.generation, true
+}
+
+// record counts the outcome of a call allowed in generation. Calls that neither failed nor
+// succeeded, like those canceled by the client, only give back their probe slot. Outcomes of
+// calls allowed before the last transition are ignored.
+func (b *Breaker) record(ctx context.Context, generation uint64, failed bool, counted bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if generation != b.generation {
+		return
+	}
+	if b.state == BREAKER_HALF_OPEN {
+		b.probes--
+		switch {
+		case !counted:
+		case failed:
+			b.open(ctx)
+		default:
+			b.successes++
+			if b.successes >= b.policy.HalfOpenProbes {
+				b.failures = 0
+				b.transition(ctx, BREAKER_CLOSED)
+			}
+		}
+		return
+	}
+	if !counted || b.state != BREAKER_CLOSED {
+		return
+	}
+	if !failed {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.failures >= b.policy.FailureThreshold {
+		b.open(ctx)
+	}
+}
+
+func (b *Breaker) open(ctx context.Context) {
+	b.openedAt = b.now()
+	b.transition(ctx, BREAKER_OPEN)
+}
+
+// transition changes the state, logging it with the logger of the request whose call caused it.
+func (b *Breaker) transition(ctx context.Context, state string) {
+	entry := helpers.Log(ctx).WithFields(logrus.Fields{
+		"breaker":  b.Name,
+		"kind":     b.Kind,
+		"from":     b.state,
+		"to":       state,
+		"failures": b.failures,
+	})
+	b.state = state
+	b.generation++
+	if state == BREAKER_OPEN {
+		entry.Warnf("Circuit breaker %s opened, retrying in %s", b.Name, b.policy.CoolDown)
+		return
+	}
+	entry.Infof("Circuit breaker %s is %s", b.Name, state)
+}
+
+// State returns a snapshot of the breaker.
+func (b *Breaker) State() BreakerState {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	state := BreakerState{
+		Kind:             b.Kind,
+		Name:             b.Name,
+		State:            b.state,
+		Failures:         b.failures,
+		FailureThreshold: b.policy.FailureThreshold,
+	}
+	if b.state != BREAKER_CLOSED {
+		openedAt, retryAt := b.openedAt, b.openedAt.Add(b.policy.CoolDown)
+		state.OpenedAt, state.RetryAt = &openedAt, &retryAt
+	}
+	return state
+}
+
+type breakerRegistry struct {
+	mutex   sync.Mutex
+	entries map[string]*Breaker
+}
+
+// put registers a breaker, replacing one of the same kind and name.
+func (r *breakerRegistry) put(breaker *Breaker) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.entries[breaker.Kind+":"+breaker.Name] = breaker
+}
+
+// get returns the breaker of the given kind, name and policy, creating it when missing. Steps
+// calling the same host with different policies each get a breaker of their own.
+func (r *breakerRegistry) get(kind string, name string, policy BreakerPolicy) *Breaker {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	key := fmt.Sprintf("%s:%s:%d/%s/%d", kind, name, policy.FailureThreshold, policy.CoolDown, policy.HalfOpenProbes)
+	breaker, ok := r.entries[key]
+	if !ok {
+		breaker = newBreaker(kind, name, policy)
+		r.entries[key] = breaker
+	}
+	return breaker
+}
+
+// BreakerStates lists the state of every breaker ordered by kind and name.
+func BreakerStates() []BreakerState {
+	breakers.mutex.Lock()
+	list := make([]*Breaker, 0, len(breakers.entries))
+	for _, breaker := range breakers.entries {
+		list = append(list, breaker)
+	}
+	breakers.mutex.Unlock()
+
+	states := make([]BreakerState, 0, len(list))
+	for _, breaker := range list {
+		states = append(states, breaker.State())
+	}
+	sort.Slice(states, func(i, j int) bool {
+		if states[i].Kind != states[j].Kind {
+			return states[i].Kind < states[j].Kind
+		}
+		if states[i].Name != states[j].Name {
+			return states[i].Name < states[j].Name
+		}
+		return states[i].FailureThreshold < states[j].FailureThreshold
+	})
+	return states
+}
+
+// BreakersHandler serves the state of every breaker as JSON.
+func BreakersHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", CONTENT_TYPE_JSON)
+	json.NewEncoder(w).Encode(map[string]interface{}{"breakers": BreakerStates()})
+}
+
+// breakerTransport lets calls through a breaker and records their outcome.
+type breakerTransport struct {
+	breaker *Breaker
+	client  *http.Client
+}
+
+// withBreaker returns a copy of the client whose calls go through the breaker.
+func withBreaker(client *http.Client, breaker *Breaker) *http.Client {
+	breakerClient := *client
+	breakerClient.Transport = &breakerTransport{breaker: breaker, client: client}
+	return &breakerClient
+}
+
+func (t *breakerTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	generation, ok := t.breaker.allow(r.Context())
+	if !ok {
+		return nil, fmt.Errorf("%s %s: %w", t.breaker.Kind, t.breaker.Name, ErrCircuitOpen)
+	}
+	base := t.client.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	response, err := base.RoundTrip(r)
+	switch {
+	case err != nil:
+		// the caller going away says nothing about the upstream
+		t.breaker.record(r.Context(), generation, true, !errors.Is(err, context.Canceled))
+	default:
+		t.breaker.record(r.Context(), generation, response.StatusCode >= 500, true)
+	}
+	return response, err
+}
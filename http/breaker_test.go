@@ -0,0 +1,266 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/integronlabs/integron/helpers"
+	"github.com/sirupsen/logrus"
+)
+
+// failingServer answers 503 and counts the calls it receives.
+func failingServer(t *testing.T) (*httptest.Server, *int32) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.Header().Set(HEADER_CONTENT_TYPE, CONTENT_TYPE_JSON)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(`{"message":"woof"}`))
+	}))
+	t.Cleanup(server.Close)
+	return server, &calls
+}
+
+func breakerStep(url string) map[string]interface{} {
+	return map[string]interface{}{
+		"method":         "GET",
+		"url":            url,
+		"circuitBreaker": map[string]interface{}{"failureThreshold": float64(2), "coolDown": "1m"},
+		"responses": map[string]interface{}{
+			"200": map[string]interface{}{"output": map[string]interface{}{"message": MESSAGE_JSON_PATH}, "next": "next"},
+			"503": map[string]interface{}{"output": map[string]interface{}{"message": MESSAGE_JSON_PATH}, "next": "unavailable"},
+		},
+	}
+}
+
+func TestBreakerTransitions(t *testing.T) {
+	now := time.Now()
+	breaker := newBreaker(BREAKER_KIND_HOST, "dogapi.dog", BreakerPolicy{FailureThreshold: 2, CoolDown: time.Minute, HalfOpenProbes: 2})
+	breaker.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		generation, ok := breaker.allow(context.Background())
+		if !ok {
+			t.Fatalf(EXPECTED_BUT_GOT, true, false)
+		}
+		breaker.record(context.Background(), generation, true, true)
+	}
+	if _, ok := breaker.allow(context.Background()); ok {
+		t.Fatalf(EXPECTED_BUT_GOT, BREAKER_OPEN, breaker.State().State)
+	}
+
+	now = now.Add(time.Minute)
+	first, firstOK := breaker.allow(context.Background())
+	second, secondOK := breaker.allow(context.Background())
+	if !firstOK || !secondOK {
+		t.Fatal("Expected two probes after the cool-down")
+	}
+	if _, ok := breaker.allow(context.Background()); ok {
+		t.Error("Expected a third probe to be rejected")
+	}
+	breaker.record(context.Background(), first, false, true)
+	if state := breaker.State().State; state != BREAKER_HALF_OPEN {
+		t.Errorf(EXPECTED_BUT_GOT, BREAKER_HALF_OPEN, state)
+	}
+	breaker.record(context.Background(), second, false, true)
+	if state := breaker.State().State; state != BREAKER_CLOSED {
+		t.Errorf(EXPECTED_BUT_GOT, BREAKER_CLOSED, state)
+	}
+}
+
+func TestBreakerReopensOnFailedProbe(t *testing.T) {
+	now := time.Now()
+	breaker := newBreaker(BREAKER_KIND_HOST, "dogapi.dog", BreakerPolicy{FailureThreshold: 1, CoolDown: time.Minute, HalfOpenProbes: 1})
+	breaker.now = func() time.Time { return now }
+	generation, _ := breaker.allow(context.Background())
+	breaker.record(context.Background(), generation, true, true)
+
+	now = now.Add(time.Minute)
+	generation, _ = breaker.allow(context.Background())
+	breaker.record(context.Background(), generation, false, false)
+	generation, ok := breaker.allow(context.Background())
+	if !ok {
+		t.Fatal("Expected an uncounted probe to give back its slot")
+	}
+	breaker.record(context.Background(), generation, true, true)
+
+	state := breaker.State()
+	if state.State != BREAKER_OPEN || !state.RetryAt.Equal(now.Add(time.Minute)) {
+		t.Errorf(EXPECTED_BUT_GOT, BREAKER_OPEN+" until "+now.Add(time.Minute).String(), state)
+	}
+}
+
+func TestBreakerIgnoresStaleOutcomes(t *testing.T) {
+	now := time.Now()
+	breaker := newBreaker(BREAKER_KIND_HOST, "dogapi.dog", BreakerPolicy{FailureThreshold: 1, CoolDown: time.Minute, HalfOpenProbes: 1})
+	breaker.now = func() time.Time { return now }
+	stale, _ := breaker.allow(context.Background())
+	failing, _ := breaker.allow(context.Background())
+	breaker.record(context.Background(), failing, true, true)
+
+	now = now.Add(time.Minute)
+	probe, _ := breaker.allow(context.Background())
+	breaker.record(context.Background(), stale, false, true)
+
+	if state := breaker.State().State; state != BREAKER_HALF_OPEN {
+		t.Errorf(EXPECTED_BUT_GOT, BREAKER_HALF_OPEN, state)
+	}
+	if _, ok := breaker.allow(context.Background()); ok {
+		t.Error("Expected the probe slot to stay taken")
+	}
+	breaker.record(context.Background(), probe, false, true)
+	if state := breaker.State().State; state != BREAKER_CLOSED {
+		t.Errorf(EXPECTED_BUT_GOT, BREAKER_CLOSED, state)
+	}
+}
+
+func TestBreakerLogsWithRequestLogger(t *testing.T) {
+	var output bytes.Buffer
+	logger := logrus.New()
+	logger.SetOutput(&output)
+	ctx := helpers.WithLogger(context.Background(), logger)
+	breaker := newBreaker(BREAKER_KIND_HOST, "dogapi.dog", BreakerPolicy{FailureThreshold: 1, CoolDown: time.Minute, HalfOpenProbes: 1})
+
+	generation, _ := breaker.allow(ctx)
+	breaker.record(ctx, generation, true, true)
+
+	if !strings.Contains(output.String(), "Circuit breaker dogapi.dog opened") {
+		t.Errorf(EXPECTED_BUT_GOT, "the transition logged by the request logger", output.String())
+	}
+}
+
+func TestHostBreakerPerPolicy(t *testing.T) {
+	strict := BreakerPolicy{FailureThreshold: 1, CoolDown: time.Minute, HalfOpenProbes: 1}
+	lenient := BreakerPolicy{FailureThreshold: 10, CoolDown: time.Second, HalfOpenProbes: 1}
+
+	breaker := breakers.get(BREAKER_KIND_HOST, "policies.example", strict)
+
+	if breakers.get(BREAKER_KIND_HOST, "policies.example", strict) != breaker {
+		t.Error("Expected the same breaker for the same policy")
+	}
+	if other := breakers.get(BREAKER_KIND_HOST, "policies.example", lenient); other == breaker || other.policy != lenient {
+		t.Errorf(EXPECTED_BUT_GOT, lenient, other.policy)
+	}
+}
+
+func TestRunCircuitBreakerFallback(t *testing.T) {
+	server, calls := failingServer(t)
+	stepMap := breakerStep(server.URL)
+	stepMap["fallback"] = "cached"
+
+	for i := 0; i < 2; i++ {
+		if _, next, _ := Run(context.Background(), DefaultClient, stepMap, validOutputMap); next != "unavailable" {
+			t.Fatalf(EXPECTED_BUT_GOT, "unavailable", next)
+		}
+	}
+	output, next, err := Run(context.Background(), DefaultClient, stepMap, validOutputMap)
+
+	if err != nil {
+		t.Fatalf(EXPECTED_NIL_GOT, err)
+	}
+	if next != "cached" {
+		t.Errorf(EXPECTED_BUT_GOT, "cached", next)
+	}
+	expected := map[string]interface{}{"breaker": server.Listener.Addr().String(), "state": BREAKER_OPEN}
+	if !reflect.DeepEqual(output, expected) {
+		t.Errorf(EXPECTED_BUT_GOT, expected, output)
+	}
+	if *calls != 2 {
+		t.Errorf(EXPECTED_BUT_GOT, 2, *calls)
+	}
+}
+
+func TestRunCircuitBreakerOpenWithoutFallback(t *testing.T) {
+	server, _ := failingServer(t)
+	stepMap := breakerStep(server.URL)
+	for i := 0; i < 2; i++ {
+		Run(context.Background(), DefaultClient, stepMap, validOutputMap)
+	}
+
+	_, next, err := Run(context.Background(), DefaultClient, stepMap, validOutputMap)
+
+	if !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf(EXPECTED_BUT_GOT, ErrCircuitOpen, err)
+	}
+	if next != "error" {
+		t.Errorf(EXPECTED_BUT_GOT, "error", next)
+	}
+}
+
+func TestUpstreamsRunCircuitBreaker(t *testing.T) {
+	server, calls := failingServer(t)
+	upstreams, err := ParseUpstreams(map[string]interface{}{
+		"flaky": map[string]interface{}{
+			"baseUrl":        server.URL,
+			"circuitBreaker": map[string]interface{}{"failureThreshold": float64(1), "coolDown": "1m"},
+		},
+	})
+	if err != nil {
+		t.Fatalf(EXPECTED_NIL_GOT, err)
+	}
+	stepMap := breakerStep("")
+	delete(stepMap, "url")
+	delete(stepMap, "circuitBreaker")
+	stepMap["upstream"] = "flaky"
+
+	upstreams.Run(context.Background(), stepMap, validOutputMap)
+	_, _, err = upstreams.Run(context.Background(), stepMap, validOutputMap)
+
+	if !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf(EXPECTED_BUT_GOT, ErrCircuitOpen, err)
+	}
+	if *calls != 1 {
+		t.Errorf(EXPECTED_BUT_GOT, 1, *calls)
+	}
+}
+
+func TestBreakersHandler(t *testing.T) {
+	_, err := ParseUpstreams(map[string]interface{}{
+		"listed": map[string]interface{}{"baseUrl": EXAMPLE_URL, "circuitBreaker": map[string]interface{}{}},
+	})
+	if err != nil {
+		t.Fatalf(EXPECTED_NIL_GOT, err)
+	}
+	w := httptest.NewRecorder()
+
+	BreakersHandler(w, httptest.NewRequest(http.MethodGet, "/admin/breakers", nil))
+
+	var body struct {
+		Breakers []BreakerState `json:"breakers"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatalf(EXPECTED_NIL_GOT, err)
+	}
+	for _, state := range body.Breakers {
+		if state.Kind == BREAKER_KIND_UPSTREAM && state.Name == "listed" {
+			if state.State != BREAKER_CLOSED || state.FailureThreshold != 5 {
+				t.Errorf(EXPECTED_BUT_GOT, "closed with threshold 5", state)
+			}
+			return
+		}
+	}
+	t.Errorf(EXPECTED_BUT_GOT, "upstream listed", body.Breakers)
+}
+
+func TestParseBreakerPolicyInvalid(t *testing.T) {
+	for _, definition := range []interface{}{
+		"open",
+		map[string]interface{}{"failureThreshold": float64(0)},
+		map[string]interface{}{"halfOpenProbes": float64(1.5)},
+		map[string]interface{}{"coolDown": "later"},
+		map[string]interface{}{"coolDown": float64(0)},
+	} {
+		if _, err := ParseBreakerPolicy(definition); err == nil {
+			t.Errorf("Expected error for %v, got nil", definition)
+		}
+	}
+}
@@ -0,0 +1,349 @@
+package http
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/integronlabs/integron/helpers"
+)
+
+// cacheableStatuses are the statuses stored by default (RFC 9111 section 4.2.2).
+var cacheableStatuses = map[int]bool{200: true, 203: true, 204: true, 300: true, 301: true, 404: true, 405: true, 410: true, 414: true, 501: true}
+
+// credentialHeaders always key cached responses, so that responses to requests forwarding
+// credentials are never served to requests with other credentials.
+var credentialHeaders = []string{"Authorization", "Cookie"}
+
+// ResponseCache stores the upstream responses of http steps with a cache block.
+var ResponseCache Cache = NewLRUCache(DEFAULT_CACHE_MAX_ENTRIES, DEFAULT_CACHE_MAX_BYTES)
+
+// Cache stores upstream responses by key. Entries are never modified once set.
+type Cache interface {
+	Get(key string) (*CacheEntry, bool)
+	Set(key string, entry *CacheEntry)
+	Delete(key string)
+}
+
+// CacheEntry is a stored upstream response.
+type CacheEntry struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+	// Expires is when the entry stops being fresh and has to be revalidated or fetched again.
+	Expires time.Time
+	// Vary holds the values of the request headers named by the Vary header of the response.
+	Vary map[string]string
+}
+
+// varies returns the values of the request headers a response varies on, or false when it varies
+// on something other than request headers and cannot be reused.
+func varies(r *http.Request, header http.Header) (map[string]string, bool) {
+	vary := make(map[string]string)
+	for _, value := range header.Values("Vary") {
+		for _, name := range strings.Split(value, ",") {
+			name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+			if name == "*" {
+				return nil, false
+			}
+			if name != "" {
+				vary[name] = strings.Join(r.Header.Values(name), ", ")
+			}
+		}
+	}
+	return vary, true
+}
+
+// matches reports whether a request sends the header values the entry was stored for.
+func (e *CacheEntry) matches(r *http.Request) bool {
+	for name, value := range e.Vary {
+		if strings.Join(r.Header.Values(name), ", ") != value {
+			return false
+		}
+	}
+	return true
+}
+
+// fresh reports whether the entry can be served without contacting the upstream.
+func (e *CacheEntry) fresh(now time.Time) bool {
+	return now.Before(e.Expires)
+}
+
+// hasValidators reports whether a response can be revalidated with a conditional request.
+func hasValidators(header http.Header) bool {
+	return header.Get("ETag") != "" || header.Get("Last-Modified") != ""
+}
+
+func (e *CacheEntry) response(r *http.Request) *http.Response {
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", e.StatusCode, http.StatusText(e.StatusCode)),
+		StatusCode:    e.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        e.Header.Clone(),
+		Body:          io.NopCloser(bytes.NewReader(e.Body)),
+		ContentLength: int64(len(e.Body)),
+		Request:       r,
+	}
+}
+
+// cacheConfig is the parsed cache block of an http step.
+type cacheConfig struct {
+	// TTL is the freshness of responses without Cache-Control or Expires, and caps the freshness of the others.
+	TTL        time.Duration
+	KeyHeaders []string
+	Revalidate bool
+	Statuses   map[int]bool
+	// Scope keeps apart the responses fetched with different credentials.
+	Scope string
+	// Authorized is set for steps with an auth block, whose credentials are only added after the cache.
+	Authorized bool
+}
+
+func parseCache(value interface{}) (*cacheConfig, error) {
+	cacheMap, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("invalid cache format")
+	}
+	config := &cacheConfig{Revalidate: true, Statuses: cacheableStatuses}
+	if ttl, ok := cacheMap["ttl"]; ok {
+		duration, err := helpers.ParseDuration(ttl)
+		if err != nil || duration <= 0 {
+			return nil, fmt.Errorf("invalid cache ttl %v", ttl)
+		}
+		config.TTL = duration
+	}
+	if keyHeaders, ok := cacheMap["keyHeaders"]; ok {
+		list, ok := keyHeaders.([]interface{})
+		if !ok {
+			return nil, errors.New("invalid cache keyHeaders format")
+		}
+		for _, item := range list {
+			header, ok := item.(string)
+			if !ok || header == "" {
+				return nil, fmt.Errorf("invalid cache key header %v", item)
+			}
+			config.KeyHeaders = append(config.KeyHeaders, http.CanonicalHeaderKey(header))
+		}
+		sort.Strings(config.KeyHeaders)
+	}
+	if revalidate, ok := cacheMap["revalidate"]; ok {
+		if config.Revalidate, ok = revalidate.(bool); !ok {
+			return nil, fmt.Errorf("invalid cache revalidate %v", revalidate)
+		}
+	}
+	if statuses, ok := cacheMap["statuses"]; ok {
+		list, ok := statuses.([]interface{})
+		if !ok {
+			return nil, errors.New("invalid cache statuses format")
+		}
+		config.Statuses = make(map[int]bool)
+		for _, item := range list {
+			status, ok := item.(float64)
+			if !ok {
+				return nil, fmt.Errorf("invalid cache status %v", item)
+			}
+			config.Statuses[int(status)] = true
+		}
+	}
+	return config, nil
+}
+
+// key identifies a request by its method, URL, key headers, credentials and a hash of its body.
+func (c *cacheConfig) key(r *http.Request) (string, error) {
+	hash := sha256.New()
+	if r.GetBody != nil {
+		body, err := r.GetBody()
+		if err != nil {
+			return "", err
+		}
+		defer body.Close()
+		if _, err := io.Copy(hash, body); err != nil {
+			return "", err
+		}
+	}
+	parts := []string{c.Scope, r.Method, r.URL.String()}
+	for _, header := range c.KeyHeaders {
+		parts = append(parts, header+": "+strings.Join(r.Header.Values(header), ", "))
+	}
+	credentials := sha256.New()
+	for _, header := range credentialHeaders {
+		fmt.Fprintf(credentials, "%s: %s\n", header, strings.Join(r.Header.Values(header), ", "))
+	}
+	parts = append(parts, hex.EncodeToString(credentials.Sum(nil)), hex.EncodeToString(hash.Sum(nil)))
+	return strings.Join(parts, "\n"), nil
+}
+
+// expires returns until when a response is fresh, and whether it may be stored at all.
+func (c *cacheConfig) expires(header http.Header, now time.Time) (time.Time, bool) {
+	directives := cacheControl(header.Get("Cache-Control"))
+	if _, ok := directives["no-store"]; ok {
+		return time.Time{}, false
+	}
+	// integron serves many clients, so it behaves like a shared cache
+	if _, ok := directives["private"]; ok {
+		return time.Time{}, false
+	}
+	expires, explicit := upstreamExpires(directives, header, now)
+	if c.TTL > 0 && (!explicit || expires.After(now.Add(c.TTL))) {
+		return now.Add(c.TTL), true
+	}
+	return expires, true
+}
+
+// upstreamExpires returns until when the upstream says a response is fresh, and whether it said so.
+func upstreamExpires(directives map[string]string, header http.Header, now time.Time) (time.Time, bool) {
+	if _, ok := directives["no-cache"]; ok {
+		return now, true
+	}
+	for _, directive := range []string{"s-maxage", "max-age"} {
+		if value, ok := directives[directive]; ok {
+			seconds, err := strconv.Atoi(value)
+			if err != nil {
+				return now, true
+			}
+			age, _ := strconv.Atoi(header.Get("Age"))
+			return now.Add(time.Duration(seconds-age) * time.Second), true
+		}
+	}
+	if value := header.Get("Expires"); value != "" {
+		expires, err := http.ParseTime(value)
+		if err != nil {
+			return now, true
+		}
+		if date, err := http.ParseTime(header.Get("Date")); err == nil {
+			// measure against the upstream clock
+			return now.Add(expires.Sub(date)), true
+		}
+		return expires, true
+	}
+	return now, false
+}
+
+// storableWithAuthorization reports whether a shared cache may store the response to a request
+// with an Authorization header (RFC 9111 section 3.5).
+func storableWithAuthorization(header http.Header) bool {
+	directives := cacheControl(header.Get("Cache-Control"))
+	for _, directive := range []string{"public", "s-maxage", "must-revalidate"} {
+		if _, ok := directives[directive]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// cacheControl parses the directives of a Cache-Control header.
+func cacheControl(value string) map[string]string {
+	directives := make(map[string]string)
+	for _, part := range strings.Split(value, ",") {
+		name, argument, _ := strings.Cut(strings.TrimSpace(part), "=")
+		if name != "" {
+			directives[strings.ToLower(name)] = strings.Trim(argument, `"`)
+		}
+	}
+	return directives
+}
+
+// cacheTransport answers requests from a cache, revalidating stale entries with the upstream.
+type cacheTransport struct {
+	cache  Cache
+	config *cacheConfig
+	client *http.Client
+	now    func() time.Time
+}
+
+// withCache returns a copy of the client whose responses are cached.
+func withCache(client *http.Client, cache Cache, config *cacheConfig) *http.Client {
+	cacheClient := *client
+	cacheClient.Transport = &cacheTransport{cache: cache, config: config, client: client, now: time.Now}
+	return &cacheClient
+}
+
+func (t *cacheTransport) base() http.RoundTripper {
+	if t.client.Transport != nil {
+		return t.client.Transport
+	}
+	return http.DefaultTransport
+}
+
+func (t *cacheTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	key, err := t.config.key(r)
+	if err != nil {
+		return nil, err
+	}
+	log := helpers.Log(r.Context()).WithField("url", r.URL.Redacted())
+
+	entry, cached := t.cache.Get(key)
+	cached = cached && entry.matches(r)
+	if cached && entry.fresh(t.now()) {
+		log.Debug("Serving upstream response from cache")
+		return entry.response(r), nil
+	}
+
+	request := r
+	if cached && t.config.Revalidate && hasValidators(entry.Header) {
+		request = r.Clone(r.Context())
+		if etag := entry.Header.Get("ETag"); etag != "" {
+			request.Header.Set("If-None-Match", etag)
+		}
+		if lastModified := entry.Header.Get("Last-Modified"); lastModified != "" {
+			request.Header.Set("If-Modified-Since", lastModified)
+		}
+	}
+	response, err := t.base().RoundTrip(request)
+	if err != nil {
+		return nil, err
+	}
+
+	if request != r && response.StatusCode == http.StatusNotModified {
+		response.Body.Close()
+		header := entry.Header.Clone()
+		for name, values := range response.Header {
+			if name != "Content-Length" {
+				header[name] = values
+			}
+		}
+		revalidated := &CacheEntry{StatusCode: entry.StatusCode, Header: header, Body: entry.Body, Vary: entry.Vary}
+		if expires, ok := t.config.expires(header, t.now()); ok {
+			revalidated.Expires = expires
+			t.cache.Set(key, revalidated)
+		} else {
+			t.cache.Delete(key)
+		}
+		log.Debug("Revalidated cached upstream response")
+		return revalidated.response(r), nil
+	}
+
+	if !t.config.Statuses[response.StatusCode] {
+		return response, nil
+	}
+	expires, ok := t.config.expires(response.Header, t.now())
+	if stale := !expires.After(t.now()); !ok || (stale && !(t.config.Revalidate && hasValidators(response.Header))) {
+		// nothing to gain from an entry that is neither fresh nor revalidatable
+		t.cache.Delete(key)
+		return response, nil
+	}
+	vary, ok := varies(r, response.Header)
+	authorized := t.config.Authorized || r.Header.Get("Authorization") != ""
+	if !ok || (authorized && !storableWithAuthorization(response.Header)) {
+		t.cache.Delete(key)
+		return response, nil
+	}
+	body, err := io.ReadAll(response.Body)
+	response.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	t.cache.Set(key, &CacheEntry{StatusCode: response.StatusCode, Header: response.Header.Clone(), Body: body, Expires: expires, Vary: vary})
+	response.Body = io.NopCloser(bytes.NewReader(body))
+	return response, nil
+}
@@ -0,0 +1,305 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// cachingServer answers with the given Cache-Control and an ETag, honoring If-None-Match, and
+// counts the full and conditional responses it sends.
+func cachingServer(t *testing.T, cacheControl string) (*httptest.Server, *int32, *int32) {
+	var full, notModified int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		if cacheControl != "" {
+			w.Header().Set("Cache-Control", cacheControl)
+		}
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			atomic.AddInt32(&notModified, 1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		atomic.AddInt32(&full, 1)
+		w.Header().Set(HEADER_CONTENT_TYPE, CONTENT_TYPE_JSON)
+		w.Write([]byte(`{"message":"` + r.URL.Query().Get("name") + `"}`))
+	}))
+	t.Cleanup(server.Close)
+	return server, &full, &notModified
+}
+
+func cacheStep(url string, cache map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"method": "GET",
+		"url":    url,
+		"cache":  cache,
+		"responses": map[string]interface{}{
+			"200": map[string]interface{}{"output": map[string]interface{}{"message": MESSAGE_JSON_PATH}, "next": "next"},
+		},
+	}
+}
+
+func runCached(t *testing.T, stepMap map[string]interface{}) interface{} {
+	output, _, err := Run(context.Background(), DefaultClient, stepMap, validOutputMap)
+	if err != nil {
+		t.Fatalf(EXPECTED_NIL_GOT, err)
+	}
+	return output
+}
+
+func TestRunCacheTTL(t *testing.T) {
+	ResponseCache = NewLRUCache(10, 0)
+	server, full, _ := cachingServer(t, "")
+	stepMap := cacheStep(server.URL+"?name=$.output.message", map[string]interface{}{"ttl": "1m"})
+
+	first := runCached(t, stepMap)
+	second := runCached(t, stepMap)
+	runCached(t, cacheStep(server.URL+"?name=other", map[string]interface{}{"ttl": "1m"}))
+
+	expected := map[string]interface{}{"message": "world"}
+	if !reflect.DeepEqual(first, expected) || !reflect.DeepEqual(second, expected) {
+		t.Errorf(EXPECTED_BUT_GOT, expected, []interface{}{first, second})
+	}
+	if *full != 2 {
+		t.Errorf(EXPECTED_BUT_GOT, 2, *full)
+	}
+}
+
+func TestRunCacheRevalidates(t *testing.T) {
+	ResponseCache = NewLRUCache(10, 0)
+	server, full, notModified := cachingServer(t, "no-cache")
+	stepMap := cacheStep(server.URL+"?name=fido", map[string]interface{}{})
+
+	runCached(t, stepMap)
+	output := runCached(t, stepMap)
+
+	expected := map[string]interface{}{"message": "fido"}
+	if !reflect.DeepEqual(output, expected) {
+		t.Errorf(EXPECTED_BUT_GOT, expected, output)
+	}
+	if *full != 1 || *notModified != 1 {
+		t.Errorf(EXPECTED_BUT_GOT, "1 full and 1 conditional response", []int32{*full, *notModified})
+	}
+}
+
+func TestRunCacheHonorsCacheControl(t *testing.T) {
+	tests := []struct {
+		cacheControl string
+		cache        map[string]interface{}
+		full         int32
+	}{
+		{"max-age=60", map[string]interface{}{}, 1},
+		{"no-store", map[string]interface{}{}, 2},
+		{"private, max-age=60", map[string]interface{}{}, 2},
+		{"no-store", map[string]interface{}{"ttl": "1m"}, 2},
+		{"private, max-age=60", map[string]interface{}{"ttl": "1m"}, 2},
+	}
+	for _, test := range tests {
+		ResponseCache = NewLRUCache(10, 0)
+		server, full, _ := cachingServer(t, test.cacheControl)
+		stepMap := cacheStep(server.URL, test.cache)
+
+		runCached(t, stepMap)
+		runCached(t, stepMap)
+
+		if *full != test.full {
+			t.Errorf("Expected %d full responses for %q with %v, got %d", test.full, test.cacheControl, test.cache, *full)
+		}
+	}
+}
+
+// headerServer answers with the given Cache-Control and Vary and the value of a request header as
+// message, and counts the responses it sends.
+func headerServer(t *testing.T, cacheControl string, vary string, header string) (*httptest.Server, *int32) {
+	var full int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&full, 1)
+		w.Header().Set("Cache-Control", cacheControl)
+		if vary != "" {
+			w.Header().Set("Vary", vary)
+		}
+		w.Header().Set(HEADER_CONTENT_TYPE, CONTENT_TYPE_JSON)
+		w.Write([]byte(`{"message":"` + r.Header.Get(header) + `"}`))
+	}))
+	t.Cleanup(server.Close)
+	return server, &full
+}
+
+func runCachedWithHeader(t *testing.T, url string, header string, value string) interface{} {
+	stepMap := cacheStep(url, map[string]interface{}{})
+	stepMap["headers"] = map[string]interface{}{header: value}
+	return runCached(t, stepMap)
+}
+
+func TestRunCacheBearerTokens(t *testing.T) {
+	tests := []struct {
+		cacheControl string
+		full         int32
+	}{
+		{"public, max-age=60", 2},
+		{"max-age=60", 3},
+	}
+	for _, test := range tests {
+		ResponseCache = NewLRUCache(10, 0)
+		server, full := headerServer(t, test.cacheControl, "", "Authorization")
+
+		first := runCachedWithHeader(t, server.URL, "Authorization", "Bearer a")
+		second := runCachedWithHeader(t, server.URL, "Authorization", "Bearer b")
+		again := runCachedWithHeader(t, server.URL, "Authorization", "Bearer a")
+
+		expected := []interface{}{
+			map[string]interface{}{"message": "Bearer a"},
+			map[string]interface{}{"message": "Bearer b"},
+			map[string]interface{}{"message": "Bearer a"},
+		}
+		if output := []interface{}{first, second, again}; !reflect.DeepEqual(output, expected) {
+			t.Errorf(EXPECTED_BUT_GOT, expected, output)
+		}
+		if *full != test.full {
+			t.Errorf("Expected %d full responses for %q, got %d", test.full, test.cacheControl, *full)
+		}
+	}
+}
+
+func TestRunCacheAuthSteps(t *testing.T) {
+	tests := []struct {
+		cacheControl string
+		full         int32
+	}{
+		{"public, max-age=60", 1},
+		{"max-age=60", 2},
+	}
+	for _, test := range tests {
+		ResponseCache = NewLRUCache(10, 0)
+		server, full := headerServer(t, test.cacheControl, "", "Authorization")
+		stepMap := cacheStep(server.URL, map[string]interface{}{})
+		stepMap["auth"] = map[string]interface{}{"type": AUTH_BEARER, "token": "secret"}
+
+		for i := 0; i < 2; i++ {
+			expected := map[string]interface{}{"message": "Bearer secret"}
+			if output := runCached(t, stepMap); !reflect.DeepEqual(output, expected) {
+				t.Errorf(EXPECTED_BUT_GOT, expected, output)
+			}
+		}
+		if *full != test.full {
+			t.Errorf("Expected %d full responses for %q, got %d", test.full, test.cacheControl, *full)
+		}
+	}
+}
+
+func TestRunCacheHonorsVary(t *testing.T) {
+	tests := []struct {
+		vary string
+		full int32
+	}{
+		{"Accept-Language", 3},
+		{"*", 4},
+	}
+	for _, test := range tests {
+		ResponseCache = NewLRUCache(10, 0)
+		server, full := headerServer(t, "max-age=60", test.vary, "Accept-Language")
+
+		var outputs []interface{}
+		for _, language := range []string{"fi", "en", "en", "fi"} {
+			outputs = append(outputs, runCachedWithHeader(t, server.URL, "Accept-Language", language))
+		}
+
+		if message := outputs[1].(map[string]interface{})["message"]; message != "en" {
+			t.Errorf(EXPECTED_BUT_GOT, "en", message)
+		}
+		if *full != test.full {
+			t.Errorf("Expected %d full responses for Vary %q, got %d", test.full, test.vary, *full)
+		}
+	}
+}
+
+func TestCacheKey(t *testing.T) {
+	config, err := parseCache(map[string]interface{}{"keyHeaders": []interface{}{"accept-language"}})
+	if err != nil {
+		t.Fatalf(EXPECTED_NIL_GOT, err)
+	}
+	key := func(method string, body string, language string, scope string) string {
+		r, _ := http.NewRequest(method, EXAMPLE_URL, nil)
+		if body != "" {
+			r, _ = http.NewRequest(method, EXAMPLE_URL, strings.NewReader(body))
+		}
+		r.Header.Set("Accept-Language", language)
+		r.Header.Set("X-Ignored", body)
+		config.Scope = scope
+		k, err := config.key(r)
+		if err != nil {
+			t.Fatalf(EXPECTED_NIL_GOT, err)
+		}
+		return k
+	}
+
+	base := key("POST", "a", "fi", "")
+	if base != key("POST", "a", "fi", "") {
+		t.Error("Expected equal requests to share a key")
+	}
+	for _, other := range []string{key("GET", "a", "fi", ""), key("POST", "b", "fi", ""), key("POST", "a", "en", ""), key("POST", "a", "fi", "token")} {
+		if other == base {
+			t.Errorf("Expected %q to differ from %q", other, base)
+		}
+	}
+	for _, header := range credentialHeaders {
+		r, _ := http.NewRequest("POST", EXAMPLE_URL, strings.NewReader("a"))
+		r.Header.Set("Accept-Language", "fi")
+		r.Header.Set("X-Ignored", "a")
+		r.Header.Set(header, "secret")
+		config.Scope = ""
+		if other, _ := config.key(r); other == base || strings.Contains(other, "secret") {
+			t.Errorf("Expected %q to differ from %q without revealing %s", other, base, header)
+		}
+	}
+}
+
+func TestCacheExpires(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		header  http.Header
+		ttl     time.Duration
+		expires time.Time
+		store   bool
+	}{
+		{http.Header{"Cache-Control": {"max-age=60"}, "Age": {"10"}}, 0, now.Add(50 * time.Second), true},
+		{http.Header{"Cache-Control": {"s-maxage=30, max-age=60"}}, 0, now.Add(30 * time.Second), true},
+		{http.Header{"Date": {"Mon, 01 Jan 2024 10:00:00 GMT"}, "Expires": {"Mon, 01 Jan 2024 10:02:00 GMT"}}, 0, now.Add(2 * time.Minute), true},
+		{http.Header{"Cache-Control": {"no-cache"}}, 0, now, true},
+		{http.Header{}, 0, now, true},
+		{http.Header{"Cache-Control": {"no-store"}}, 0, time.Time{}, false},
+		{http.Header{}, time.Minute, now.Add(time.Minute), true},
+		{http.Header{"Cache-Control": {"max-age=3600"}}, time.Minute, now.Add(time.Minute), true},
+		{http.Header{"Cache-Control": {"max-age=30"}}, time.Minute, now.Add(30 * time.Second), true},
+		{http.Header{"Cache-Control": {"no-cache"}}, time.Minute, now, true},
+		{http.Header{"Cache-Control": {"no-store"}}, time.Minute, time.Time{}, false},
+		{http.Header{"Cache-Control": {"private, max-age=60"}}, time.Minute, time.Time{}, false},
+	}
+	for _, test := range tests {
+		config := &cacheConfig{TTL: test.ttl}
+		expires, store := config.expires(test.header, now)
+		if !expires.Equal(test.expires) || store != test.store {
+			t.Errorf(EXPECTED_BUT_GOT, []interface{}{test.expires, test.store}, []interface{}{expires, store})
+		}
+	}
+}
+
+func TestParseCacheInvalid(t *testing.T) {
+	for _, definition := range []interface{}{
+		"forever",
+		map[string]interface{}{"ttl": "soon"},
+		map[string]interface{}{"keyHeaders": "Accept"},
+		map[string]interface{}{"keyHeaders": []interface{}{float64(1)}},
+		map[string]interface{}{"revalidate": "yes"},
+		map[string]interface{}{"statuses": []interface{}{"200"}},
+	} {
+		if _, err := parseCache(definition); err == nil {
+			t.Errorf("Expected error for %v, got nil", definition)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package http
+
+import (
+	"container/list"
+	"sync"
+)
+
+const DEFAULT_CACHE_MAX_ENTRIES = 1000
+const DEFAULT_CACHE_MAX_BYTES = 64 << 20
+
+// LRUCache is an in-memory Cache bounded by entry count and body size, evicting the least
+// recently used entries first.
+type LRUCache struct {
+	maxEntries int
+	maxBytes   int64
+
+	mutex sync.Mutex
+	bytes int64
+	order *list.List
+	items map[string]*list.Element
+}
+
+type lruItem struct {
+	key   string
+	entry *CacheEntry
+}
+
+// NewLRUCache returns an empty cache. A limit of zero or less leaves that dimension unbounded.
+func NewLRUCache(maxEntries int, maxBytes int64) *LRUCache {
+	return &LRUCache{
+		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+		order:      list.New(),
+		items:      make(map[string]*list.Element),
+	}
+}
+
+func (c *LRUCache) Get(key string) (*CacheEntry, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	element, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(element)
+	return element.Value.(*lruItem).entry, true
+}
+
+// Set stores an entry, unless its body alone exceeds the size limit.
+func (c *LRUCache) Set(key string, entry *CacheEntry) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if element, ok := c.items[key]; ok {
+		c.remove(element)
+	}
+	size := int64(len(entry.Body))
+	if c.maxBytes > 0 && size > c.maxBytes {
+		return
+	}
+	c.items[key] = c.order.PushFront(&lruItem{key: key, entry: entry})
+	c.bytes += size
+	for (c.maxEntries > 0 && c.order.Len() > c.maxEntries) || (c.maxBytes > 0 && c.bytes > c.maxBytes) {
+		c.remove(c.order.Back())
+	}
+}
+
+func (c *LRUCache) Delete(key string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if element, ok := c.items[key]; ok {
+		c.remove(element)
+	}
+}
+
+// Len returns the number of cached entries.
+func (c *LRUCache) Len() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.order.Len()
+}
+
+func (c *LRUCache) remove(element *list.Element) {
+	item := c.order.Remove(element).(*lruItem)
+	delete(c.items, item.key)
+	c.bytes -= int64(len(item.entry.Body))
+}
@@ -0,0 +1,39 @@
+package http
+
+import "testing"
+
+func TestLRUCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	cache := NewLRUCache(2, 0)
+	cache.Set("a", &CacheEntry{Body: []byte("a")})
+	cache.Set("b", &CacheEntry{Body: []byte("b")})
+	cache.Get("a")
+	cache.Set("c", &CacheEntry{Body: []byte("c")})
+
+	if _, ok := cache.Get("b"); ok {
+		t.Error("Expected b to be evicted")
+	}
+	for _, key := range []string{"a", "c"} {
+		if _, ok := cache.Get(key); !ok {
+			t.Errorf(EXPECTED_BUT_GOT, key, "nothing")
+		}
+	}
+}
+
+func TestLRUCacheSizeLimit(t *testing.T) {
+	cache := NewLRUCache(0, 10)
+	cache.Set("a", &CacheEntry{Body: []byte("123456")})
+	cache.Set("b", &CacheEntry{Body: []byte("1234")})
+	cache.Set("too-large", &CacheEntry{Body: []byte("12345678901")})
+
+	if cache.Len() != 2 {
+		t.Errorf(EXPECTED_BUT_GOT, 2, cache.Len())
+	}
+	cache.Set("b", &CacheEntry{Body: []byte("12345")})
+	if _, ok := cache.Get("a"); ok {
+		t.Error("Expected a to be evicted")
+	}
+	cache.Delete("b")
+	if cache.Len() != 0 || cache.bytes != 0 {
+		t.Errorf(EXPECTED_BUT_GOT, "an empty cache", cache.items)
+	}
+}
@@ -2,14 +2,20 @@ package http
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
 	"strings"
+	"time"
 
 	"github.com/integronlabs/integron/helpers"
 )
 
+// RequestIDHeader carries the id of the request being served to upstreams, unless a step sets
+// requestIdHeader. Empty disables forwarding.
+var RequestIDHeader = helpers.REQUEST_ID_HEADER
+
 func getActions(responsesMap map[string]interface{}, statusCodeStr string) (map[string]interface{}, string, error) {
 	statusMap, ok := responsesMap[statusCodeStr].(map[string]interface{})
 	if !ok {
@@ -29,6 +35,46 @@ func getActions(responsesMap map[string]interface{}, statusCodeStr string) (map[
 	return outputMap, next, nil
 }
 
+// Targets lists the next steps of every configured response.
+func Targets(stepMap map[string]interface{}) []string {
+	responsesMap, _ := stepMap["responses"].(map[string]interface{})
+	targets := make([]string, 0, len(responsesMap)+1)
+	for _, status := range responsesMap {
+		if statusMap, ok := status.(map[string]interface{}); ok {
+			if next, ok := statusMap["next"].(string); ok {
+				targets = append(targets, next)
+			}
+		}
+	}
+	if fallback, ok := stepMap["fallback"].(string); ok {
+		targets = append(targets, fallback)
+	}
+	return targets
+}
+
+// hasActions reports whether the responses map handles a status, explicitly or by default.
+func hasActions(responsesMap map[string]interface{}, statusCodeStr string) bool {
+	if _, ok := responsesMap[statusCodeStr]; ok {
+		return true
+	}
+	_, ok := responsesMap["default"]
+	return ok
+}
+
+// withDefaultHeader returns the headers with name set to value, unless the step sets it explicitly.
+func withDefaultHeader(headers map[string]interface{}, name string, value string) map[string]interface{} {
+	for key := range headers {
+		if strings.EqualFold(key, name) {
+			return headers
+		}
+	}
+	withHeader := map[string]interface{}{name: value}
+	for key, value := range headers {
+		withHeader[key] = value
+	}
+	return withHeader
+}
+
 func httpRequest(ctx context.Context, client *http.Client, method string, url string, requestBodyString string, headers map[string]interface{}, stepOutputs map[string]interface{}) (*http.Response, error) {
 	url = helpers.Replace(url, stepOutputs)
 
@@ -41,6 +87,12 @@ func httpRequest(ctx context.Context, client *http.Client, method string, url st
 		value := helpers.Replace(value.(string), stepOutputs)
 		httpRequest.Header.Set(key, value)
 	}
+	helpers.RecordInput(ctx, "request", map[string]interface{}{
+		"method":  method,
+		"url":     url,
+		"headers": httpRequest.Header,
+		"body":    requestBodyString,
+	})
 	response, err := client.Do(httpRequest)
 
 	if err != nil {
@@ -50,29 +102,116 @@ func httpRequest(ctx context.Context, client *http.Client, method string, url st
 	return response, nil
 }
 
+// hostBreaker returns the breaker shared by the steps calling the host of the step url, or nil
+// when the step has no circuitBreaker.
+func hostBreaker(stepMap map[string]interface{}, stepOutputs map[string]interface{}) (*Breaker, error) {
+	breakerDefinition, ok := stepMap["circuitBreaker"]
+	if !ok {
+		return nil, nil
+	}
+	policy, err := ParseBreakerPolicy(breakerDefinition)
+	if err != nil {
+		return nil, err
+	}
+	rawURL, _ := stepMap["url"].(string)
+	parsed, err := url.Parse(helpers.Replace(rawURL, stepOutputs))
+	if err != nil {
+		return nil, err
+	}
+	return breakers.get(BREAKER_KIND_HOST, parsed.Host, *policy), nil
+}
+
+// Run calls the url of the step, through the circuit breaker of its host when it has one.
 func Run(ctx context.Context, client *http.Client, stepMap map[string]interface{}, stepOutputs map[string]interface{}) (interface{}, string, error) {
+	breaker, err := hostBreaker(stepMap, stepOutputs)
+	if err != nil {
+		return err.Error(), "error", err
+	}
+	return run(ctx, client, breaker, stepMap, stepOutputs)
+}
+
+func run(ctx context.Context, client *http.Client, breaker *Breaker, stepMap map[string]interface{}, stepOutputs map[string]interface{}) (interface{}, string, error) {
 	// get values
 	method, _ := stepMap["method"].(string)
 	url, _ := stepMap["url"].(string)
-	requestBodyMap, _ := stepMap["body"].(map[string]interface{})
 	headers, _ := stepMap["headers"].(map[string]interface{})
 	responsesMap, _ := stepMap["responses"].(map[string]interface{})
+	contentType, ok := stepMap["contentType"].(string)
+	if !ok {
+		contentType = CONTENT_TYPE_JSON
+	}
 
-	requestBody := helpers.TransformBody(stepOutputs, requestBodyMap)
+	requestBodyString := ""
+	if requestBodyDefinition, ok := stepMap["body"]; ok {
+		requestBody := helpers.TransformBody(stepOutputs, requestBodyDefinition)
+		encoded, encodedContentType, err := encodeRequestBody(contentType, requestBody)
+		if err != nil {
+			return err.Error(), "error", err
+		}
+		requestBodyString = encoded
+		headers = withDefaultHeader(headers, "Content-Type", encodedContentType)
+	}
 
-	requestBodyJson, _ := json.Marshal(requestBody)
-	requestBodyString := string(requestBodyJson)
+	requestIDHeader := RequestIDHeader
+	if value, ok := stepMap["requestIdHeader"]; ok {
+		requestIDHeader, _ = value.(string)
+	}
+	if requestID := helpers.RequestIDFromContext(ctx); requestID != "" && requestIDHeader != "" {
+		headers = withDefaultHeader(headers, requestIDHeader, requestID)
+	}
+
+	// responses fetched with different credentials are cached apart
+	var cacheScope string
+	if authDefinition, ok := stepMap["auth"]; ok {
+		config, err := parseAuth(authDefinition, stepOutputs)
+		if err != nil {
+			return err.Error(), "error", err
+		}
+		client = withAuth(client, config)
+		cacheScope = config.scope()
+	}
+	if breaker != nil {
+		client = withBreaker(client, breaker)
+	}
+	if cacheDefinition, ok := stepMap["cache"]; ok {
+		config, err := parseCache(cacheDefinition)
+		if err != nil {
+			return err.Error(), "error", err
+		}
+		config.Scope = cacheScope
+		_, config.Authorized = stepMap["auth"]
+		client = withCache(client, ResponseCache, config)
+	}
 
 	response, err := httpRequest(ctx, client, method, url, requestBodyString, headers, stepOutputs)
 
 	if err != nil {
+		if fallback, ok := stepMap["fallback"].(string); ok && errors.Is(err, ErrCircuitOpen) {
+			helpers.Log(ctx).WithField("breaker", breaker.Name).Infof("Circuit breaker %s is open, continuing with %s", breaker.Name, fallback)
+			return map[string]interface{}{"breaker": breaker.Name, "state": BREAKER_OPEN}, fallback, nil
+		}
 		return err.Error(), "error", err
 	}
 
 	defer response.Body.Close()
 
-	var responseData interface{}
-	if err := json.NewDecoder(response.Body).Decode(&responseData); err != nil {
+	statusCodeStr := fmt.Sprintf("%d", response.StatusCode)
+
+	if !hasActions(responsesMap, statusCodeStr) || helpers.RetryableStatus(ctx, response.StatusCode) {
+		cause := fmt.Errorf("could not find actions for status %s", statusCodeStr)
+		if hasActions(responsesMap, statusCodeStr) {
+			cause = fmt.Errorf("retrying status %s", statusCodeStr)
+		}
+		err := &helpers.UpstreamError{
+			StatusCode: response.StatusCode,
+			RetryAfter: helpers.ParseRetryAfter(response.Header.Get("Retry-After"), time.Now()),
+			Err:        cause,
+		}
+		return err.Error(), "error", err
+	}
+
+	responseData, err := decodeResponseBody(response.Header.Get("Content-Type"), response.Body)
+	if err != nil {
 		return err.Error(), "error", err
 	}
 
@@ -82,8 +221,6 @@ func Run(ctx context.Context, client *http.Client, stepMap map[string]interface{
 		"body":    responseData,
 	}
 
-	statusCodeStr := fmt.Sprintf("%d", response.StatusCode)
-
 	outputMap, next, err := getActions(responsesMap, statusCodeStr)
 
 	if err != nil {
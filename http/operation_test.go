@@ -6,6 +6,8 @@ import (
 	"io"
 	"net/http"
 	"testing"
+
+	"github.com/integronlabs/integron/helpers"
 )
 
 const EXPECTED_NIL_GOT = "Expected nil, got %v"
@@ -394,3 +396,31 @@ func TestRunInvalidJsonResponse(t *testing.T) {
 		t.Errorf(EXPECTED_BUT_GOT, "error", next)
 	}
 }
+
+func TestRunForwardsRequestID(t *testing.T) {
+	tests := []struct {
+		stepMap  map[string]interface{}
+		header   string
+		expected string
+	}{
+		{map[string]interface{}{}, "X-Request-ID", "req-1"},
+		{map[string]interface{}{"requestIdHeader": "X-Correlation-ID"}, "X-Correlation-ID", "req-1"},
+		{map[string]interface{}{"requestIdHeader": ""}, "X-Request-ID", ""},
+		{map[string]interface{}{"headers": map[string]interface{}{"x-request-id": "$.output.message"}}, "X-Request-ID", "world"},
+	}
+	ctx := helpers.WithRequestID(context.Background(), "req-1")
+	for _, test := range tests {
+		transport := &RecordingRoundTripper{MockResponse: &http.Response{StatusCode: http.StatusNoContent, Body: http.NoBody}}
+		test.stepMap["method"] = "GET"
+		test.stepMap["url"] = EXAMPLE_URL
+		test.stepMap["responses"] = map[string]interface{}{"204": map[string]interface{}{"output": map[string]interface{}{}, "next": "next"}}
+
+		if _, _, err := Run(ctx, &http.Client{Transport: transport}, test.stepMap, validOutputMap); err != nil {
+			t.Fatalf(EXPECTED_NIL_GOT, err)
+		}
+
+		if value := transport.Request.Header.Get(test.header); value != test.expected {
+			t.Errorf(EXPECTED_BUT_GOT, test.expected, value)
+		}
+	}
+}
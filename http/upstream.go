@@ -0,0 +1,308 @@
+package http
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/integronlabs/integron/helpers"
+)
+
+const UPSTREAMS_EXTENSION = "x-integron-upstreams"
+
+// DefaultClient serves http steps that do not name an upstream.
+var DefaultClient = &http.Client{}
+
+// Upstream is a named upstream with its long-lived client.
+type Upstream struct {
+	Name    string
+	BaseURL string
+	Headers map[string]interface{}
+	Client  *http.Client
+	Breaker *Breaker
+}
+
+// Upstreams are the named upstreams of x-integron-upstreams.
+type Upstreams map[string]*Upstream
+
+// ParseUpstreams reads the x-integron-upstreams section of a spec. Secret references in it are
+// resolved once, when the spec is loaded.
+func ParseUpstreams(value interface{}) (Upstreams, error) {
+	upstreams := make(Upstreams)
+	if value == nil {
+		return upstreams, nil
+	}
+	definitions, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid %s %v", UPSTREAMS_EXTENSION, value)
+	}
+	resolved, err := helpers.ResolveSecretsIn(context.Background(), definitions)
+	if err != nil {
+		return nil, err
+	}
+	for name, definition := range resolved.(map[string]interface{}) {
+		upstream, err := parseUpstream(name, definition)
+		if err != nil {
+			return nil, fmt.Errorf("upstream %s: %w", name, err)
+		}
+		upstreams[name] = upstream
+	}
+	return upstreams, nil
+}
+
+func parseUpstream(name string, value interface{}) (*Upstream, error) {
+	definition, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid definition %v", value)
+	}
+	baseURL, _ := definition["baseUrl"].(string)
+	if parsed, err := url.Parse(baseURL); err != nil || parsed.Scheme == "" || parsed.Host == "" {
+		return nil, fmt.Errorf("invalid baseUrl %q", baseURL)
+	}
+	upstream := &Upstream{Name: name, BaseURL: baseURL, Headers: map[string]interface{}{}}
+	if headers, ok := definition["headers"].(map[string]interface{}); ok {
+		for key, value := range headers {
+			upstream.Headers[key] = fmt.Sprintf("%v", value)
+		}
+	}
+
+	duration := func(key string) (time.Duration, error) {
+		value, ok := definition[key]
+		if !ok {
+			return 0, nil
+		}
+		d, err := helpers.ParseDuration(value)
+		if err != nil || d < 0 {
+			return 0, fmt.Errorf("invalid %s %v", key, value)
+		}
+		return d, nil
+	}
+	count := func(key string) (int, error) {
+		value, ok := definition[key]
+		if !ok {
+			return 0, nil
+		}
+		n, ok := value.(float64)
+		if !ok || n < 0 || n != float64(int(n)) {
+			return 0, fmt.Errorf("invalid %s %v", key, value)
+		}
+		return int(n), nil
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	client := &http.Client{Transport: transport}
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	var err error
+	if client.Timeout, err = duration("timeout"); err != nil {
+		return nil, err
+	}
+	connectTimeout, err := duration("connectTimeout")
+	if err != nil {
+		return nil, err
+	}
+	if connectTimeout > 0 {
+		dialer.Timeout = connectTimeout
+	}
+	transport.DialContext = dialer.DialContext
+	for key, target := range map[string]*time.Duration{
+		"tlsHandshakeTimeout":   &transport.TLSHandshakeTimeout,
+		"responseHeaderTimeout": &transport.ResponseHeaderTimeout,
+		"idleConnTimeout":       &transport.IdleConnTimeout,
+	} {
+		if _, ok := definition[key]; ok {
+			if *target, err = duration(key); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for key, target := range map[string]*int{
+		"maxIdleConns":        &transport.MaxIdleConns,
+		"maxIdleConnsPerHost": &transport.MaxIdleConnsPerHost,
+		"maxConnsPerHost":     &transport.MaxConnsPerHost,
+	} {
+		if _, ok := definition[key]; ok {
+			if *target, err = count(key); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if proxy, ok := definition["proxy"].(string); ok {
+		if proxy == "none" {
+			transport.Proxy = nil
+		} else {
+			proxyURL, err := url.Parse(proxy)
+			if err != nil || proxyURL.Host == "" {
+				return nil, fmt.Errorf("invalid proxy %q", proxy)
+			}
+			transport.Proxy = http.ProxyURL(proxyURL)
+		}
+	}
+
+	if transport.TLSClientConfig, err = tlsConfig(definition); err != nil {
+		return nil, err
+	}
+	if breakerDefinition, ok := definition["circuitBreaker"]; ok {
+		policy, err := ParseBreakerPolicy(breakerDefinition)
+		if err != nil {
+			return nil, err
+		}
+		upstream.Breaker = newBreaker(BREAKER_KIND_UPSTREAM, name, *policy)
+		breakers.put(upstream.Breaker)
+	}
+	upstream.Client = client
+	return upstream, nil
+}
+
+// tlsConfig trusts caFile in addition to the system roots and presents certFile/keyFile for mTLS.
+func tlsConfig(definition map[string]interface{}) (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if caFile, ok := definition["caFile"].(string); ok {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		roots, err := x509.SystemCertPool()
+		if err != nil {
+			roots = x509.NewCertPool()
+		}
+		if !roots.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in caFile %s", caFile)
+		}
+		config.RootCAs = roots
+	}
+	certFile, hasCert := definition["certFile"].(string)
+	keyFile, hasKey := definition["keyFile"].(string)
+	if hasCert != hasKey {
+		return nil, fmt.Errorf("certFile and keyFile must be set together")
+	}
+	if hasCert {
+		certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{certificate}
+	}
+	return config, nil
+}
+
+// Validate checks that a step either calls a url or a path of a known upstream, and that its
+// circuit breaker, cache, auth and fallback are valid.
+func (u Upstreams) Validate(stepMap map[string]interface{}) []error {
+	var errs []error
+	breakerDefinition, hasBreaker := stepMap["circuitBreaker"]
+	if hasBreaker {
+		if _, err := ParseBreakerPolicy(breakerDefinition); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if cacheDefinition, ok := stepMap["cache"]; ok {
+		if _, err := parseCache(cacheDefinition); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if authDefinition, ok := stepMap["auth"]; ok {
+		if _, err := parseAuth(authDefinition, nil); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if requestIDHeader, ok := stepMap["requestIdHeader"]; ok {
+		if _, ok := requestIDHeader.(string); !ok {
+			errs = append(errs, fmt.Errorf("invalid requestIdHeader %v", requestIDHeader))
+		}
+	}
+	if fallback, ok := stepMap["fallback"]; ok {
+		if name, ok := fallback.(string); !ok || name == "" {
+			errs = append(errs, fmt.Errorf("invalid fallback %v", fallback))
+		}
+	}
+
+	upstreamValue, hasUpstream := stepMap["upstream"]
+	_, hasURL := stepMap["url"]
+	if !hasUpstream {
+		if !hasURL {
+			errs = append(errs, fmt.Errorf("missing required field \"url\" or \"upstream\""))
+		}
+		return errs
+	}
+	name, ok := upstreamValue.(string)
+	if !ok {
+		return append(errs, fmt.Errorf("invalid upstream %v", upstreamValue))
+	}
+	if hasBreaker {
+		errs = append(errs, fmt.Errorf("circuitBreaker of upstream %s belongs in %s", name, UPSTREAMS_EXTENSION))
+	}
+	if _, exists := u[name]; !exists {
+		errs = append(errs, fmt.Errorf("upstream %q is not defined in %s", name, UPSTREAMS_EXTENSION))
+	}
+	if hasURL {
+		errs = append(errs, fmt.Errorf("url and upstream are mutually exclusive, use path with upstream"))
+	}
+	return errs
+}
+
+// Run calls the step's upstream with its client, base URL and default headers, or the url of the
+// step with the default client.
+func (u Upstreams) Run(ctx context.Context, stepMap map[string]interface{}, stepOutputs map[string]interface{}) (interface{}, string, error) {
+	name, ok := stepMap["upstream"].(string)
+	if !ok {
+		return Run(ctx, DefaultClient, stepMap, stepOutputs)
+	}
+	upstream, ok := u[name]
+	if !ok {
+		err := fmt.Errorf("upstream %q is not defined", name)
+		return err.Error(), "error", err
+	}
+
+	path, _ := stepMap["path"].(string)
+	headers := make(map[string]interface{}, len(upstream.Headers))
+	for key, value := range upstream.Headers {
+		headers[key] = value
+	}
+	if stepHeaders, ok := stepMap["headers"].(map[string]interface{}); ok {
+		for key, value := range stepHeaders {
+			for existing := range headers {
+				if strings.EqualFold(existing, key) {
+					delete(headers, existing)
+				}
+			}
+			headers[key] = value
+		}
+	}
+
+	resolved := make(map[string]interface{}, len(stepMap)+2)
+	for key, value := range stepMap {
+		resolved[key] = value
+	}
+	resolved["url"] = joinURL(upstream.BaseURL, path)
+	resolved["headers"] = headers
+	return run(ctx, upstream.Client, upstream.Breaker, resolved, stepOutputs)
+}
+
+// joinURL appends a step path to a base URL, merging their queries. The path is joined as text,
+// not through url.URL, since it may hold expressions that are only resolved when the step runs.
+func joinURL(baseURL string, path string) string {
+	base, baseQuery, _ := strings.Cut(baseURL, "?")
+	path, query, _ := strings.Cut(path, "?")
+	joined := strings.TrimRight(base, "/")
+	if path != "" {
+		joined += "/" + strings.TrimLeft(path, "/")
+	}
+	var queries []string
+	for _, q := range []string{baseQuery, query} {
+		if q != "" {
+			queries = append(queries, q)
+		}
+	}
+	if len(queries) > 0 {
+		joined += "?" + strings.Join(queries, "&")
+	}
+	return joined
+}
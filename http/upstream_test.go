@@ -0,0 +1,197 @@
+package http
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// writeClientCertificate writes a self-signed client certificate and its key.
+func writeClientCertificate(t *testing.T) (string, string) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf(EXPECTED_NIL_GOT, err)
+	}
+	keyDer, _ := x509.MarshalECPrivateKey(key)
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "client.pem"), filepath.Join(dir, "client-key.pem")
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0o600)
+	return certFile, keyFile
+}
+
+// tlsUpstream requires a client certificate and answers with the request it received.
+func tlsUpstream(t *testing.T) (*httptest.Server, string) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(HEADER_CONTENT_TYPE, CONTENT_TYPE_JSON)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"path":   r.URL.RequestURI(),
+			"agent":  r.Header.Get("User-Agent"),
+			"tenant": r.Header.Get("X-Tenant"),
+			"certs":  len(r.TLS.PeerCertificates),
+		})
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	server.StartTLS()
+	t.Cleanup(server.Close)
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0o600)
+	return server, caFile
+}
+
+func TestUpstreamsRun(t *testing.T) {
+	server, caFile := tlsUpstream(t)
+	certFile, keyFile := writeClientCertificate(t)
+	upstreams, err := ParseUpstreams(map[string]interface{}{
+		"dogapi": map[string]interface{}{
+			"baseUrl":             server.URL + "/api/v2/",
+			"timeout":             "5s",
+			"maxIdleConnsPerHost": float64(4),
+			"caFile":              caFile,
+			"certFile":            certFile,
+			"keyFile":             keyFile,
+			"headers": map[string]interface{}{
+				"User-Agent": "integron",
+				"X-Tenant":   "default",
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf(EXPECTED_NIL_GOT, err)
+	}
+	stepMap := map[string]interface{}{
+		"method":   "GET",
+		"upstream": "dogapi",
+		"path":     "/facts?limit=$.output.message",
+		"headers":  map[string]interface{}{"x-tenant": "acme"},
+		"responses": map[string]interface{}{
+			"200": map[string]interface{}{
+				"output": map[string]interface{}{"request": "$.body"},
+				"next":   "next",
+			},
+		},
+	}
+
+	output, _, err := upstreams.Run(context.Background(), stepMap, validOutputMap)
+
+	if err != nil {
+		t.Fatalf(EXPECTED_NIL_GOT, err)
+	}
+	expected := map[string]interface{}{"request": map[string]interface{}{
+		"path":   "/api/v2/facts?limit=world",
+		"agent":  "integron",
+		"tenant": "acme",
+		"certs":  float64(1),
+	}}
+	if !reflect.DeepEqual(output, expected) {
+		t.Errorf(EXPECTED_BUT_GOT, expected, output)
+	}
+	if upstreams["dogapi"].Client.Timeout != 5*time.Second {
+		t.Errorf(EXPECTED_BUT_GOT, 5*time.Second, upstreams["dogapi"].Client.Timeout)
+	}
+}
+
+func TestJoinURL(t *testing.T) {
+	tests := []struct {
+		baseURL  string
+		path     string
+		expected string
+	}{
+		{"https://dogapi.dog/api/v2/", "/facts", "https://dogapi.dog/api/v2/facts"},
+		{"https://dogapi.dog/api/v2", "", "https://dogapi.dog/api/v2"},
+		{"https://dogapi.dog/api/v2?key=abc", "facts/$.request.path.id", "https://dogapi.dog/api/v2/facts/$.request.path.id?key=abc"},
+		{"https://dogapi.dog/api/v2/?key=abc", "/facts?limit=5", "https://dogapi.dog/api/v2/facts?key=abc&limit=5"},
+		{"https://dogapi.dog?key=abc", "", "https://dogapi.dog?key=abc"},
+	}
+	for _, test := range tests {
+		if joined := joinURL(test.baseURL, test.path); joined != test.expected {
+			t.Errorf(EXPECTED_BUT_GOT, test.expected, joined)
+		}
+	}
+}
+
+func TestUpstreamsRunUntrustedCertificate(t *testing.T) {
+	server, _ := tlsUpstream(t)
+	upstreams, err := ParseUpstreams(map[string]interface{}{
+		"dogapi": map[string]interface{}{"baseUrl": server.URL},
+	})
+	if err != nil {
+		t.Fatalf(EXPECTED_NIL_GOT, err)
+	}
+
+	_, next, err := upstreams.Run(context.Background(), map[string]interface{}{"method": "GET", "upstream": "dogapi"}, validOutputMap)
+
+	if err == nil {
+		t.Fatal(EXPECTED_ERROR_GOT_NIL)
+	}
+	if next != "error" {
+		t.Errorf(EXPECTED_BUT_GOT, "error", next)
+	}
+}
+
+func TestParseUpstreamsInvalid(t *testing.T) {
+	for _, definition := range []interface{}{
+		"dogapi",
+		map[string]interface{}{"dogapi": "https://dogapi.dog"},
+		map[string]interface{}{"dogapi": map[string]interface{}{"baseUrl": "dogapi.dog"}},
+		map[string]interface{}{"dogapi": map[string]interface{}{"baseUrl": "https://dogapi.dog", "timeout": "soon"}},
+		map[string]interface{}{"dogapi": map[string]interface{}{"baseUrl": "https://dogapi.dog", "maxIdleConns": float64(1.5)}},
+		map[string]interface{}{"dogapi": map[string]interface{}{"baseUrl": "https://dogapi.dog", "proxy": "::"}},
+		map[string]interface{}{"dogapi": map[string]interface{}{"baseUrl": "https://dogapi.dog", "caFile": "/does/not/exist"}},
+		map[string]interface{}{"dogapi": map[string]interface{}{"baseUrl": "https://dogapi.dog", "certFile": "client.pem"}},
+	} {
+		if _, err := ParseUpstreams(definition); err == nil {
+			t.Errorf("Expected error for %v, got nil", definition)
+		}
+	}
+}
+
+func TestUpstreamsValidate(t *testing.T) {
+	upstreams := Upstreams{"dogapi": &Upstream{Name: "dogapi"}}
+	tests := []struct {
+		stepMap  map[string]interface{}
+		problems int
+	}{
+		{map[string]interface{}{"url": EXAMPLE_URL}, 0},
+		{map[string]interface{}{"upstream": "dogapi", "path": "/facts"}, 0},
+		{map[string]interface{}{}, 1},
+		{map[string]interface{}{"upstream": "catapi"}, 1},
+		{map[string]interface{}{"upstream": "dogapi", "url": EXAMPLE_URL}, 1},
+		{map[string]interface{}{"upstream": float64(1)}, 1},
+		{map[string]interface{}{"url": EXAMPLE_URL, "circuitBreaker": map[string]interface{}{}, "fallback": "cached"}, 0},
+		{map[string]interface{}{"url": EXAMPLE_URL, "circuitBreaker": map[string]interface{}{"coolDown": "later"}}, 1},
+		{map[string]interface{}{"upstream": "dogapi", "circuitBreaker": map[string]interface{}{}}, 1},
+		{map[string]interface{}{"url": EXAMPLE_URL, "fallback": float64(1)}, 1},
+		{map[string]interface{}{"url": EXAMPLE_URL, "auth": map[string]interface{}{"type": AUTH_BEARER, "token": "${env:TOKEN}"}}, 0},
+		{map[string]interface{}{"url": EXAMPLE_URL, "auth": map[string]interface{}{"type": AUTH_BEARER, "token": "$.login.token"}}, 0},
+		{map[string]interface{}{"url": EXAMPLE_URL, "auth": map[string]interface{}{"type": "digest"}}, 1},
+		{map[string]interface{}{"url": EXAMPLE_URL, "auth": map[string]interface{}{"type": AUTH_OAUTH2, "clientId": "a"}}, 1},
+		{map[string]interface{}{"url": EXAMPLE_URL, "auth": map[string]interface{}{"type": AUTH_HMAC, "secret": "k", "algorithm": "md5"}}, 1},
+	}
+	for _, test := range tests {
+		if problems := upstreams.Validate(test.stepMap); len(problems) != test.problems {
+			t.Errorf(EXPECTED_BUT_GOT, test.problems, problems)
+		}
+	}
+}
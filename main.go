@@ -2,76 +2,139 @@ package main
 
 import (
 	"context"
-	"errors"
-	"flag"
+	"fmt"
 	"net/http"
+	"os"
+	"strconv"
+	"strings"
 
 	"github.com/getkin/kin-openapi/openapi3"
-	"github.com/getkin/kin-openapi/routers/gorillamux"
 	"github.com/integronlabs/integron/array"
-	"github.com/integronlabs/integron/helpers"
+	"github.com/integronlabs/integron/errorstep"
 	httpOperation "github.com/integronlabs/integron/http"
 	"github.com/integronlabs/integron/object"
+	"github.com/integronlabs/integron/parallel"
 	"github.com/integronlabs/integron/removenull"
 	"github.com/integronlabs/integron/server"
-
-	"github.com/swaggest/swgui/v5emb"
-
-	"github.com/sirupsen/logrus"
+	"github.com/integronlabs/integron/switchstep"
 
 	_ "embed"
 )
 
-func main() {
-	helpers.SetupLogging()
+const usage = `Usage: integron <command> [flags]
+
+Commands:
+  serve     Serve the flows of an OpenAPI spec (default)
+  validate  Validate an OpenAPI spec and its flows
+  routes    Print every operation with its step chain
+
+Run "integron <command> -h" for the flags of a command.
+`
+
+// registerSteps registers every step type, with http steps calling the upstreams of the loaded spec.
+func registerSteps(upstreams httpOperation.Upstreams) {
+	server.RegisterStep("http", func(ctx context.Context, stepMap map[string]interface{}, stepOutputs map[string]interface{}) (interface{}, string, error) {
+		return upstreams.Run(ctx, stepMap, stepOutputs)
+	})
+	server.RegisterStepSchema("http", server.StepSchema{
+		Required: []string{"method", "responses"},
+		Targets:  httpOperation.Targets,
+		Validate: func(stepMap map[string]interface{}) []error {
+			return upstreams.Validate(stepMap)
+		},
+	})
+	server.RegisterStep("transformarray", array.Run)
+	server.RegisterStepSchema("transformarray", server.StepSchema{
+		Required: []string{"input", "output", "next"},
+	})
+	server.RegisterStep("transformobject", object.Run)
+	server.RegisterStepSchema("transformobject", server.StepSchema{
+		Required: []string{"output", "next"},
+	})
+	server.RegisterStep("removenull", removenull.Run)
+	server.RegisterStepSchema("removenull", server.StepSchema{
+		Required: []string{"input", "next"},
+	})
+	server.RegisterStep("switch", switchstep.Run)
+	server.RegisterStepSchema("switch", server.StepSchema{
+		Required: []string{"cases", "default"},
+		Targets:  switchstep.Targets,
+		Compile:  switchstep.Compile,
+	})
+	server.RegisterStep("parallel", parallel.Run)
+	server.RegisterStepSchema("parallel", server.StepSchema{
+		Required: []string{"branches", "next"},
+		Branches: parallel.Branches,
+		Validate: parallel.Validate,
+	})
+	server.RegisterStep("error", errorstep.Run)
+}
 
-	openapiSpecPath := flag.String("spec", "docs/openapi.yaml", "Path to the OpenAPI spec")
-	flag.Parse()
+// instrumentClients wraps the transport of every client http steps call upstreams with.
+func instrumentClients(upstreams httpOperation.Upstreams, wrap func(http.RoundTripper) http.RoundTripper) {
+	httpOperation.DefaultClient.Transport = wrap(httpOperation.DefaultClient.Transport)
+	for _, upstream := range upstreams {
+		upstream.Client.Transport = wrap(upstream.Client.Transport)
+	}
+}
 
-	ctx := context.Background()
+// envOrDefault returns the value of an environment variable or fallback when it is unset.
+func envOrDefault(key string, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok {
+		return value
+	}
+	return fallback
+}
+
+// envIntOrDefault returns the integer value of an environment variable or fallback when it is unset or invalid.
+func envIntOrDefault(key string, fallback int) int {
+	if value, err := strconv.Atoi(os.Getenv(key)); err == nil {
+		return value
+	}
+	return fallback
+}
+
+// loadSpec loads and validates an OpenAPI document and sets up its upstreams.
+func loadSpec(ctx context.Context, path string) (*openapi3.T, httpOperation.Upstreams, error) {
 	loader := &openapi3.Loader{Context: ctx, IsExternalRefsAllowed: true}
-	doc, err := loader.LoadFromFile(*openapiSpecPath)
+	doc, err := loader.LoadFromFile(path)
 	if err != nil {
-		panic(err)
+		return nil, nil, fmt.Errorf("could not load %s: %w", path, err)
 	}
 
 	// Validate document
 	err = doc.Validate(ctx)
 	if err != nil {
-		panic(err)
+		return nil, nil, fmt.Errorf("invalid OpenAPI document %s: %w", path, err)
 	}
 
-	r, err := gorillamux.NewRouter(doc)
+	upstreams, err := httpOperation.ParseUpstreams(doc.Extensions[httpOperation.UPSTREAMS_EXTENSION])
 	if err != nil {
-		panic(err)
+		return nil, nil, fmt.Errorf("invalid %s in %s: %w", httpOperation.UPSTREAMS_EXTENSION, path, err)
 	}
+	return doc, upstreams, nil
+}
 
-	s := server.Server{
-		Router:       r,
-		LogFormatter: &logrus.JSONFormatter{},
+func main() {
+	command := "serve"
+	args := os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
 	}
 
-	server.RegisterStep("http", func(ctx context.Context, stepMap map[string]interface{}, stepOutputs map[string]interface{}) (interface{}, string, error) {
-		client := http.Client{}
-		return httpOperation.Run(ctx, &client, stepMap, stepOutputs)
-	})
-	server.RegisterStep("transformarray", array.Run)
-	server.RegisterStep("transformobject", object.Run)
-	server.RegisterStep("removenull", removenull.Run)
-	server.RegisterStep("error", func(ctx context.Context, stepMap map[string]interface{}, stepOutputs map[string]interface{}) (interface{}, string, error) {
-		return nil, "end", errors.New("error step triggered")
-	})
-
-	http.Handle("/", http.HandlerFunc(s.Handler))
-
-	fs := http.FileServer(http.Dir("docs/"))
-	http.Handle("/docs/", http.StripPrefix("/docs/", fs))
-
-	http.Handle("/ui/", v5emb.New(
-		"Integron",
-		"/docs/openapi.yaml",
-		"/ui/",
-	))
-
-	logrus.Fatal(http.ListenAndServe(":8080", nil))
+	var code int
+	switch command {
+	case "serve":
+		code = serve(args)
+	case "validate":
+		code = validate(args)
+	case "routes":
+		code = routes(args)
+	case "help":
+		fmt.Fprint(os.Stdout, usage)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", command, usage)
+		code = 2
+	}
+	os.Exit(code)
 }
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	httpOperation "github.com/integronlabs/integron/http"
+	"github.com/integronlabs/integron/server"
+)
+
+const EXPECTED_NIL_GOT = "Expected nil, got %v"
+const EXPECTED_BUT_GOT = "Expected %v, got %v"
+
+const invalidFlowSpec = `
+openapi: 3.0.3
+info:
+  title: test
+  version: 1.0.0
+paths:
+  /facts:
+    get:
+      responses:
+        '200':
+          description: ok
+      x-integron-steps:
+        - name: respond
+          type: transformobject
+          output: {}
+          next: missing
+`
+
+const invalidSwitchSpec = `
+openapi: 3.0.3
+info:
+  title: test
+  version: 1.0.0
+paths:
+  /facts:
+    get:
+      responses:
+        '200':
+          description: ok
+      x-integron-steps:
+        - name: route
+          type: switch
+          cases:
+            - when: $.request.query.amount >
+              next: respond
+          default: respond
+        - name: respond
+          type: transformobject
+          output: {}
+          next: ''
+`
+
+func writeSpec(t *testing.T, name string, spec string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(spec), 0o644); err != nil {
+		t.Fatalf(EXPECTED_NIL_GOT, err)
+	}
+	return path
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		spec string
+		code int
+	}{
+		{"docs/openapi.yaml", 0},
+		{filepath.Join(t.TempDir(), "missing.yaml"), 1},
+		{writeSpec(t, "invalid.yaml", invalidFlowSpec), 1},
+		{writeSpec(t, "switch.yaml", invalidSwitchSpec), 1},
+	}
+	for _, test := range tests {
+		if code := validate([]string{"-spec", test.spec}); code != test.code {
+			t.Errorf(EXPECTED_BUT_GOT, test.code, code)
+		}
+	}
+}
+
+func TestPrintRoutes(t *testing.T) {
+	doc, upstreams, err := loadSpec(context.Background(), "docs/openapi.yaml")
+	if err != nil {
+		t.Fatalf(EXPECTED_NIL_GOT, err)
+	}
+	registerSteps(upstreams)
+	flows, err := server.Compile(doc)
+	if err != nil {
+		t.Fatalf(EXPECTED_NIL_GOT, err)
+	}
+	var output bytes.Buffer
+
+	printRoutes(&output, flows)
+
+	lines := strings.Split(output.String(), "\n")
+	if !strings.HasPrefix(lines[0], "GET") || !strings.HasSuffix(strings.TrimSpace(lines[0]), "/facts") {
+		t.Errorf(EXPECTED_BUT_GOT, "GET /facts", lines[0])
+	}
+	if !strings.Contains(output.String(), "(http)") {
+		t.Errorf(EXPECTED_BUT_GOT, "an http step", output.String())
+	}
+}
+
+func TestRoutesInvalidSpec(t *testing.T) {
+	if code := routes([]string{"-spec", writeSpec(t, "invalid.yaml", invalidFlowSpec)}); code != 1 {
+		t.Errorf(EXPECTED_BUT_GOT, 1, code)
+	}
+}
+
+func TestMountDocs(t *testing.T) {
+	specPath := writeSpec(t, "api.yml", invalidFlowSpec)
+	mux := http.NewServeMux()
+	mountDocs(mux, specPath, t.TempDir())
+	get := func(path string) string {
+		recorder := httptest.NewRecorder()
+		mux.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+		if recorder.Code != http.StatusOK {
+			t.Fatalf(EXPECTED_BUT_GOT, http.StatusOK, recorder.Code)
+		}
+		body, _ := io.ReadAll(recorder.Body)
+		return string(body)
+	}
+
+	if spec := get("/ui/openapi.yml"); spec != invalidFlowSpec {
+		t.Errorf(EXPECTED_BUT_GOT, invalidFlowSpec, spec)
+	}
+	if page := get("/ui/"); !strings.Contains(page, "/ui/openapi.yml") {
+		t.Errorf(EXPECTED_BUT_GOT, "a UI loading /ui/openapi.yml", page)
+	}
+}
+
+func TestRegisterStepsUpstreams(t *testing.T) {
+	registerSteps(httpOperation.Upstreams{"dogapi": &httpOperation.Upstream{Name: "dogapi"}})
+	validate := server.GetStepSchema("http").Validate
+
+	if errs := validate(map[string]interface{}{"upstream": "dogapi"}); len(errs) != 0 {
+		t.Errorf(EXPECTED_NIL_GOT, errs)
+	}
+	if errs := validate(map[string]interface{}{"upstream": "catapi"}); len(errs) != 1 {
+		t.Errorf(EXPECTED_BUT_GOT, "catapi to be unknown", errs)
+	}
+}
+
+func TestServeUntilDoneFinishesRequests(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf(EXPECTED_NIL_GOT, err)
+	}
+	started := make(chan struct{})
+	httpServer := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(50 * time.Millisecond)
+		w.Write([]byte("done"))
+	})}
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() {
+		served <- serveUntilDone(ctx, httpServer, listener)
+	}()
+
+	responses := make(chan string, 1)
+	go func() {
+		response, err := http.Get("http://" + listener.Addr().String())
+		if err != nil {
+			responses <- err.Error()
+			return
+		}
+		defer response.Body.Close()
+		body, _ := io.ReadAll(response.Body)
+		responses <- string(body)
+	}()
+	<-started
+	cancel()
+
+	if body := <-responses; body != "done" {
+		t.Errorf(EXPECTED_BUT_GOT, "done", body)
+	}
+	if err := <-served; err != nil {
+		t.Errorf(EXPECTED_NIL_GOT, err)
+	}
+}
@@ -0,0 +1,175 @@
+package metrics
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/integronlabs/integron/server"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const NAMESPACE = "integron"
+
+// UNKNOWN labels requests that matched no operation and steps missing from their flow.
+const UNKNOWN = "unknown"
+
+const OUTCOME_SUCCESS = "success"
+const OUTCOME_ERROR = "error"
+
+// Metrics instruments inbound requests, steps and upstream calls.
+type Metrics struct {
+	Registry *prometheus.Registry
+
+	requests         *prometheus.CounterVec
+	requestDuration  *prometheus.HistogramVec
+	requestsInFlight prometheus.Gauge
+
+	steps         *prometheus.CounterVec
+	stepDuration  *prometheus.HistogramVec
+	stepsInFlight *prometheus.GaugeVec
+
+	upstreamRequests         *prometheus.CounterVec
+	upstreamRequestDuration  *prometheus.HistogramVec
+	upstreamRequestsInFlight *prometheus.GaugeVec
+}
+
+// New registers the metrics together with the Go runtime and process collectors in a new registry.
+func New() *Metrics {
+	m := &Metrics{
+		Registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: NAMESPACE, Name: "http_requests_total",
+			Help: "Inbound requests by operation and status.",
+		}, []string{"operation", "status"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: NAMESPACE, Name: "http_request_duration_seconds",
+			Help: "Latency of inbound requests by operation and status.", Buckets: prometheus.DefBuckets,
+		}, []string{"operation", "status"}),
+		requestsInFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: NAMESPACE, Name: "http_requests_in_flight",
+			Help: "Inbound requests being served.",
+		}),
+		steps: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: NAMESPACE, Name: "steps_total",
+			Help: "Step executions by operation, step, type, outcome and next step.",
+		}, []string{"operation", "step", "type", "outcome", "next"}),
+		stepDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: NAMESPACE, Name: "step_duration_seconds",
+			Help: "Latency of step executions by operation, step, type and outcome.", Buckets: prometheus.DefBuckets,
+		}, []string{"operation", "step", "type", "outcome"}),
+		stepsInFlight: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: NAMESPACE, Name: "steps_in_flight",
+			Help: "Steps being executed by type.",
+		}, []string{"type"}),
+		upstreamRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: NAMESPACE, Name: "upstream_requests_total",
+			Help: "Outbound http step calls by upstream host and status class.",
+		}, []string{"host", "status_class"}),
+		upstreamRequestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: NAMESPACE, Name: "upstream_request_duration_seconds",
+			Help: "Latency of outbound http step calls by upstream host and status class.", Buckets: prometheus.DefBuckets,
+		}, []string{"host", "status_class"}),
+		upstreamRequestsInFlight: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: NAMESPACE, Name: "upstream_requests_in_flight",
+			Help: "Outbound http step calls waiting for a response by upstream host.",
+		}, []string{"host"}),
+	}
+	m.Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.requests, m.requestDuration, m.requestsInFlight,
+		m.steps, m.stepDuration, m.stepsInFlight,
+		m.upstreamRequests, m.upstreamRequestDuration, m.upstreamRequestsInFlight,
+	)
+	return m
+}
+
+// Exposition serves the metrics in the Prometheus text format.
+func (m *Metrics) Exposition() http.Handler {
+	return promhttp.HandlerFor(m.Registry, promhttp.HandlerOpts{Registry: m.Registry})
+}
+
+// Handler instruments the inbound requests served by next, usually Server.Handler.
+func (m *Metrics) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m.requestsInFlight.Inc()
+		defer m.requestsInFlight.Dec()
+
+		ctx, info := server.WithRequestInfo(r.Context())
+		recorder := &server.StatusRecorder{ResponseWriter: w}
+		start := time.Now()
+		next.ServeHTTP(recorder, r.WithContext(ctx))
+
+		operation := info.Operation
+		if operation == "" {
+			operation = UNKNOWN
+		}
+		status := recorder.Status()
+		labels := prometheus.Labels{"operation": operation, "status": strconv.Itoa(status)}
+		m.requests.With(labels).Inc()
+		m.requestDuration.With(labels).Observe(time.Since(start).Seconds())
+	})
+}
+
+// Step is a server.StepMiddleware instrumenting every step execution.
+func (m *Metrics) Step(next server.StepProcessor) server.StepProcessor {
+	return func(r *http.Request, currentStepKey string, flow *server.Flow, stepOutputs map[string]interface{}) (interface{}, string) {
+		stepType := UNKNOWN
+		if step, ok := flow.Steps[currentStepKey]; ok {
+			stepType = step.Type
+		}
+		inFlight := m.stepsInFlight.WithLabelValues(stepType)
+		inFlight.Inc()
+		defer inFlight.Dec()
+
+		start := time.Now()
+		output, nextStep := next(r, currentStepKey, flow, stepOutputs)
+
+		// ProcessStep returns the error as the output of a failed step
+		outcome := OUTCOME_SUCCESS
+		if _, failed := output.(error); failed {
+			outcome = OUTCOME_ERROR
+		}
+		operation := flow.Operation()
+		m.steps.WithLabelValues(operation, currentStepKey, stepType, outcome, nextStep).Inc()
+		m.stepDuration.WithLabelValues(operation, currentStepKey, stepType, outcome).Observe(time.Since(start).Seconds())
+		return output, nextStep
+	}
+}
+
+// statusClass groups a status like 503 into 5xx; calls without a response are "timeout" or "error".
+func statusClass(response *http.Response, err error) string {
+	if err != nil {
+		var timeout interface{ Timeout() bool }
+		if errors.As(err, &timeout) && timeout.Timeout() {
+			return "timeout"
+		}
+		return OUTCOME_ERROR
+	}
+	return fmt.Sprintf("%dxx", response.StatusCode/100)
+}
+
+// Transport instruments the outbound calls made through next.
+func (m *Metrics) Transport(next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return server.RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		host := r.URL.Host
+		inFlight := m.upstreamRequestsInFlight.WithLabelValues(host)
+		inFlight.Inc()
+		defer inFlight.Dec()
+
+		start := time.Now()
+		response, err := next.RoundTrip(r)
+		class := statusClass(response, err)
+		m.upstreamRequests.WithLabelValues(host, class).Inc()
+		m.upstreamRequestDuration.WithLabelValues(host, class).Observe(time.Since(start).Seconds())
+		return response, err
+	})
+}
@@ -0,0 +1,177 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/integronlabs/integron/server"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+const EXPECTED_NIL_GOT = "Expected nil, got %v"
+const EXPECTED_BUT_GOT = "Expected %v, got %v"
+
+func init() {
+	server.RegisterStep("metrics-ok", func(ctx context.Context, stepMap map[string]interface{}, stepOutputs map[string]interface{}) (interface{}, string, error) {
+		next, _ := stepMap["next"].(string)
+		return map[string]interface{}{"body": map[string]interface{}{}}, next, nil
+	})
+	server.RegisterStep("metrics-fail", func(ctx context.Context, stepMap map[string]interface{}, stepOutputs map[string]interface{}) (interface{}, string, error) {
+		err := errors.New("failed")
+		return err.Error(), "error", err
+	})
+}
+
+const metricsSpec = `
+openapi: 3.0.3
+info:
+  title: test
+  version: 1.0.0
+paths:
+  /facts:
+    get:
+      operationId: getFacts
+      responses:
+        '200':
+          description: ok
+          content:
+            application/json:
+              schema:
+                type: object
+      x-integron-steps:
+        - name: first
+          type: metrics-ok
+          next: second
+        - name: second
+          type: metrics-ok
+          next: ""
+  /broken:
+    get:
+      responses:
+        '500':
+          description: failed
+      x-integron-steps:
+        - name: fail
+          type: metrics-fail
+          next: ""
+`
+
+func newInstrumentedServer(t *testing.T) (*Metrics, http.Handler) {
+	doc, err := openapi3.NewLoader().LoadFromData([]byte(metricsSpec))
+	if err != nil {
+		t.Fatalf(EXPECTED_NIL_GOT, err)
+	}
+	s, err := server.New(doc)
+	if err != nil {
+		t.Fatalf(EXPECTED_NIL_GOT, err)
+	}
+	m := New()
+	s.StepMiddleware = []server.StepMiddleware{m.Step}
+	return m, m.Handler(http.HandlerFunc(s.Handler))
+}
+
+func serve(handler http.Handler, path string) {
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+}
+
+func TestHandlerMetrics(t *testing.T) {
+	m, handler := newInstrumentedServer(t)
+
+	serve(handler, "/facts")
+	serve(handler, "/facts")
+	serve(handler, "/broken")
+	serve(handler, "/missing")
+
+	for _, test := range []struct {
+		labels   []string
+		expected float64
+	}{
+		{[]string{"getFacts", "200"}, 2},
+		{[]string{"GET /broken", "500"}, 1},
+		{[]string{UNKNOWN, "404"}, 1},
+	} {
+		if count := testutil.ToFloat64(m.requests.WithLabelValues(test.labels...)); count != test.expected {
+			t.Errorf(EXPECTED_BUT_GOT, test.expected, count)
+		}
+	}
+	if count := testutil.CollectAndCount(m.requestDuration); count != 3 {
+		t.Errorf(EXPECTED_BUT_GOT, 3, count)
+	}
+	if inFlight := testutil.ToFloat64(m.requestsInFlight); inFlight != 0 {
+		t.Errorf(EXPECTED_BUT_GOT, 0, inFlight)
+	}
+}
+
+func TestStepMetrics(t *testing.T) {
+	m, handler := newInstrumentedServer(t)
+
+	serve(handler, "/facts")
+	serve(handler, "/broken")
+
+	for _, test := range []struct {
+		labels   []string
+		expected float64
+	}{
+		{[]string{"getFacts", "first", "metrics-ok", OUTCOME_SUCCESS, "second"}, 1},
+		{[]string{"getFacts", "second", "metrics-ok", OUTCOME_SUCCESS, ""}, 1},
+		{[]string{"GET /broken", "fail", "metrics-fail", OUTCOME_ERROR, "error"}, 1},
+	} {
+		if count := testutil.ToFloat64(m.steps.WithLabelValues(test.labels...)); count != test.expected {
+			t.Errorf(EXPECTED_BUT_GOT, test.expected, count)
+		}
+	}
+}
+
+func TestTransportMetrics(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/down" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer upstream.Close()
+	m := New()
+	client := &http.Client{Transport: m.Transport(nil)}
+	host := strings.TrimPrefix(upstream.URL, "http://")
+
+	for _, path := range []string{"/up", "/up", "/down"} {
+		response, err := client.Get(upstream.URL + path)
+		if err != nil {
+			t.Fatalf(EXPECTED_NIL_GOT, err)
+		}
+		response.Body.Close()
+	}
+	upstream.Close()
+	client.Get(upstream.URL)
+
+	for _, test := range []struct {
+		class    string
+		expected float64
+	}{
+		{"2xx", 2},
+		{"5xx", 1},
+		{OUTCOME_ERROR, 1},
+	} {
+		if count := testutil.ToFloat64(m.upstreamRequests.WithLabelValues(host, test.class)); count != test.expected {
+			t.Errorf(EXPECTED_BUT_GOT, test.expected, count)
+		}
+	}
+}
+
+func TestExposition(t *testing.T) {
+	m, handler := newInstrumentedServer(t)
+	serve(handler, "/facts")
+	recorder := httptest.NewRecorder()
+
+	m.Exposition().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	for _, name := range []string{"integron_http_requests_total", "integron_steps_total", "integron_http_requests_in_flight", "go_goroutines"} {
+		if !strings.Contains(recorder.Body.String(), name) {
+			t.Errorf(EXPECTED_BUT_GOT, name, "no such metric")
+		}
+	}
+}
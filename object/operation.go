@@ -5,7 +5,6 @@ import (
 	"fmt"
 
 	"github.com/integronlabs/integron/helpers"
-	"github.com/sirupsen/logrus"
 )
 
 func Run(ctx context.Context, stepMap map[string]interface{}, stepOutputs map[string]interface{}) (interface{}, string, error) {
@@ -22,10 +21,11 @@ func Run(ctx context.Context, stepMap map[string]interface{}, stepOutputs map[st
 		return err.Error(), "error", err
 	}
 
-	logrus.WithContext(ctx).Debugf("output: %v", output)
-	logrus.WithContext(ctx).Debugf("next: %v", next)
+	helpers.Log(ctx).Debugf("output: %v", output)
+	helpers.Log(ctx).Debugf("next: %v", next)
 
 	body := helpers.TransformBody(stepOutputs, output)
+	helpers.RecordInput(ctx, "output", body)
 
 	return body, next, nil
 }
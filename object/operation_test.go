@@ -2,7 +2,10 @@ package object
 
 import (
 	"context"
+	"reflect"
 	"testing"
+
+	"github.com/integronlabs/integron/helpers"
 )
 
 const EXPECTED_NIL_GOT = "Expected nil, got %v"
@@ -89,3 +92,17 @@ func TestRunInvalidOutputFormat(t *testing.T) {
 		t.Errorf(EXPECTED_BUT_GOT, "error", next)
 	}
 }
+
+func TestRunRecordsOutput(t *testing.T) {
+	recorded := make(map[string]interface{})
+	ctx := helpers.WithInputRecorder(context.Background(), func(name string, value interface{}) {
+		recorded[name] = value
+	})
+
+	Run(ctx, map[string]interface{}{"next": "next", "output": map[string]interface{}{"message": VALID_OUTPUT}}, validOutputMap)
+
+	expected := map[string]interface{}{"message": "world"}
+	if !reflect.DeepEqual(recorded["output"], expected) {
+		t.Errorf(EXPECTED_BUT_GOT, expected, recorded["output"])
+	}
+}
@@ -0,0 +1,166 @@
+package parallel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/integronlabs/integron/helpers"
+	"github.com/integronlabs/integron/server"
+)
+
+const FAIL_FAST = "failFast"
+const COLLECT_ERRORS = "collectErrors"
+
+// ERRORS_KEY lists the failed branches in the output of a step collecting errors.
+const ERRORS_KEY = "errors"
+
+type branchResult struct {
+	name   string
+	output interface{}
+	err    error
+}
+
+func runBranch(ctx context.Context, name string, branch *server.Flow, stepOutputs map[string]interface{}) (interface{}, error) {
+	currentStepKey := branch.Start
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if _, ok := branch.Steps[currentStepKey]; !ok {
+			return nil, fmt.Errorf("%s: %s", helpers.INVALID_STEP_DEFINITION, currentStepKey)
+		}
+
+		helpers.Log(ctx).Debugf("Processing branch %s step: %s", name, currentStepKey)
+
+		output, next := server.ProcessBranchStep(ctx, branch.Steps, currentStepKey, stepOutputs)
+		if err, failed := output.(error); failed {
+			return nil, fmt.Errorf("step %s failed: %w", currentStepKey, err)
+		}
+		server.SetStepOutput(ctx, stepOutputs, currentStepKey, output)
+
+		switch next {
+		case "":
+			return output, nil
+		case "error", "end":
+			return nil, fmt.Errorf("step %s ended the branch with %s", currentStepKey, next)
+		}
+		currentStepKey = next
+	}
+}
+
+// Branches returns the step sequence of every branch.
+func Branches(stepMap map[string]interface{}) map[string][]interface{} {
+	branchesMap, _ := stepMap["branches"].(map[string]interface{})
+	branches := make(map[string][]interface{}, len(branchesMap))
+	for branchName, branch := range branchesMap {
+		stepsArray, _ := branch.([]interface{})
+		branches[branchName] = stepsArray
+	}
+	return branches
+}
+
+// Validate reports the problems of a parallel step definition; its branches are compiled as flows
+// of their own.
+func Validate(stepMap map[string]interface{}) []error {
+	var errs []error
+	if _, ok := stepMap["next"].(string); !ok {
+		errs = append(errs, fmt.Errorf("invalid next format"))
+	}
+	if failurePolicy, ok := stepMap["failurePolicy"]; ok && failurePolicy != FAIL_FAST && failurePolicy != COLLECT_ERRORS {
+		errs = append(errs, fmt.Errorf("invalid failurePolicy: %v", failurePolicy))
+	}
+	branches, ok := stepMap["branches"].(map[string]interface{})
+	if !ok || len(branches) == 0 {
+		return append(errs, fmt.Errorf("invalid branches format"))
+	}
+	branchNames := make([]string, 0, len(branches))
+	for branchName := range branches {
+		branchNames = append(branchNames, branchName)
+	}
+	sort.Strings(branchNames)
+	for _, branchName := range branchNames {
+		if branchName == ERRORS_KEY {
+			errs = append(errs, fmt.Errorf("branch name %s is reserved", ERRORS_KEY))
+		}
+		if _, ok := branches[branchName].([]interface{}); !ok {
+			errs = append(errs, fmt.Errorf("invalid steps format for branch %s", branchName))
+		}
+	}
+	return errs
+}
+
+func Run(ctx context.Context, stepMap map[string]interface{}, stepOutputs map[string]interface{}) (interface{}, string, error) {
+	// get values
+
+	next, ok := stepMap["next"].(string)
+	if !ok {
+		err := fmt.Errorf("invalid next format")
+		return err.Error(), "error", err
+	}
+	step := server.CurrentStep(ctx)
+	if step == nil || len(step.Branches) == 0 {
+		err := fmt.Errorf("parallel step has no compiled branches")
+		return err.Error(), "error", err
+	}
+	branches := step.Branches
+	failurePolicy, ok := stepMap["failurePolicy"].(string)
+	if !ok {
+		failurePolicy = FAIL_FAST
+	}
+	if failurePolicy != FAIL_FAST && failurePolicy != COLLECT_ERRORS {
+		err := fmt.Errorf("invalid failurePolicy: %s", failurePolicy)
+		return err.Error(), "error", err
+	}
+
+	helpers.Log(ctx).Debugf("branches: %d", len(branches))
+	helpers.Log(ctx).Debugf("failurePolicy: %v", failurePolicy)
+	helpers.Log(ctx).Debugf("next: %v", next)
+
+	// branches write the outputs of their steps to the step outputs of the flow as they go
+	branchCtx, cancel := context.WithCancel(server.WithSharedOutputs(ctx))
+	defer cancel()
+
+	results := make(chan branchResult, len(branches))
+	var wg sync.WaitGroup
+	for name, branch := range branches {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			output, err := runBranch(branchCtx, name, branch, stepOutputs)
+			if err != nil && failurePolicy == FAIL_FAST {
+				cancel()
+			}
+			results <- branchResult{name: name, output: output, err: err}
+		}()
+	}
+	wg.Wait()
+	close(results)
+
+	outputs := make(map[string]interface{}, len(branches))
+	branchErrors := make(map[string]interface{})
+	var failure error
+	for result := range results {
+		if result.err != nil {
+			branchErrors[result.name] = result.err.Error()
+			// branches cancelled by a fail-fast sibling are not the cause
+			if failure == nil || errors.Is(failure, context.Canceled) {
+				failure = fmt.Errorf("branch %s: %w", result.name, result.err)
+			}
+			continue
+		}
+		outputs[result.name] = result.output
+	}
+
+	if failure != nil {
+		helpers.Log(ctx).Debugf("branch errors: %v", branchErrors)
+		if failurePolicy == FAIL_FAST {
+			return failure.Error(), "error", failure
+		}
+		outputs[ERRORS_KEY] = branchErrors
+	}
+
+	return outputs, next, nil
+}
@@ -0,0 +1,340 @@
+package parallel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/integronlabs/integron/server"
+)
+
+const EXPECTED_NIL_GOT = "Expected nil, got %v"
+const EXPECTED_ERROR_GOT_NIL = "Expected error, got nil"
+const EXPECTED_BUT_GOT = "Expected %v, got %v"
+
+var started int32
+
+func init() {
+	server.RegisterStep("parallel", Run)
+	server.RegisterStepSchema("parallel", server.StepSchema{
+		Required: []string{"branches", "next"},
+		Branches: Branches,
+		Validate: Validate,
+	})
+	server.RegisterStep("test-count", func(ctx context.Context, stepMap map[string]interface{}, stepOutputs map[string]interface{}) (interface{}, string, error) {
+		atomic.AddInt32(&started, 1)
+		return nil, "", nil
+	})
+	server.RegisterStep("test-read", func(ctx context.Context, stepMap map[string]interface{}, stepOutputs map[string]interface{}) (interface{}, string, error) {
+		next, _ := stepMap["next"].(string)
+		return len(stepOutputs), next, nil
+	})
+	server.RegisterStep("test-echo", func(ctx context.Context, stepMap map[string]interface{}, stepOutputs map[string]interface{}) (interface{}, string, error) {
+		next, _ := stepMap["next"].(string)
+		return stepMap["value"], next, nil
+	})
+	server.RegisterStep("test-fail", func(ctx context.Context, stepMap map[string]interface{}, stepOutputs map[string]interface{}) (interface{}, string, error) {
+		err := errors.New("upstream failed")
+		return err.Error(), "error", err
+	})
+	server.RegisterStep("test-wait", func(ctx context.Context, stepMap map[string]interface{}, stepOutputs map[string]interface{}) (interface{}, string, error) {
+		select {
+		case <-ctx.Done():
+			return ctx.Err().Error(), "error", ctx.Err()
+		case <-time.After(time.Second):
+			return "waited", "", nil
+		}
+	})
+}
+
+// branchContext lets branch steps run through ProcessStep, as in a request served without step middleware.
+func branchContext() context.Context {
+	return server.WithBranchProcessor(httptest.NewRequest(http.MethodGet, "/", nil), &server.Flow{}, new(server.Server).ProcessStep).Context()
+}
+
+// compile compiles a parallel step named fanOut, followed by a step named respond, like a flow.
+func compile(stepMap map[string]interface{}) (*server.Flow, []server.Problem) {
+	stepMap["name"] = "fanOut"
+	stepMap["type"] = "parallel"
+	return server.CompileFlow(http.MethodGet, "/", map[string]interface{}{
+		server.STEPS_EXTENSION: []interface{}{stepMap, step("respond", "test-echo", nil, "")},
+	})
+}
+
+func runParallel(t *testing.T, stepMap map[string]interface{}, stepOutputs map[string]interface{}) (interface{}, string, error) {
+	flow, problems := compile(stepMap)
+	if len(problems) > 0 {
+		t.Fatalf(EXPECTED_NIL_GOT, problems)
+	}
+	return server.RunStep(branchContext(), flow.Steps["fanOut"], stepOutputs)
+}
+
+func step(name string, stepType string, value interface{}, next string) map[string]interface{} {
+	return map[string]interface{}{
+		"name":  name,
+		"type":  stepType,
+		"value": value,
+		"next":  next,
+	}
+}
+
+func TestRun(t *testing.T) {
+	stepMap := map[string]interface{}{
+		"branches": map[string]interface{}{
+			"cats": []interface{}{
+				step("meow", "test-echo", "meow", "purr"),
+				step("purr", "test-echo", "purr", ""),
+			},
+			"dogs": []interface{}{
+				step("woof", "test-echo", "woof", ""),
+			},
+		},
+		"next": "respond",
+	}
+
+	output, next, err := runParallel(t, stepMap, map[string]interface{}{})
+
+	if err != nil {
+		t.Errorf(EXPECTED_NIL_GOT, err)
+	}
+	if next != "respond" {
+		t.Errorf(EXPECTED_BUT_GOT, "respond", next)
+	}
+	outputMap := output.(map[string]interface{})
+	if outputMap["cats"] != "purr" {
+		t.Errorf(EXPECTED_BUT_GOT, "purr", outputMap["cats"])
+	}
+	if outputMap["dogs"] != "woof" {
+		t.Errorf(EXPECTED_BUT_GOT, "woof", outputMap["dogs"])
+	}
+}
+
+func TestRunFailFast(t *testing.T) {
+	stepMap := map[string]interface{}{
+		"branches": map[string]interface{}{
+			"slow": []interface{}{
+				step("wait", "test-wait", nil, ""),
+			},
+			"broken": []interface{}{
+				step("fail", "test-fail", nil, ""),
+			},
+		},
+		"next": "respond",
+	}
+
+	start := time.Now()
+	output, next, err := runParallel(t, stepMap, map[string]interface{}{})
+
+	if err == nil {
+		t.Error(EXPECTED_ERROR_GOT_NIL)
+	}
+	if next != "error" {
+		t.Errorf(EXPECTED_BUT_GOT, "error", next)
+	}
+	expectedOutput := "branch broken: step fail failed: upstream failed"
+	if output != expectedOutput {
+		t.Errorf(EXPECTED_BUT_GOT, expectedOutput, output)
+	}
+	if time.Since(start) >= time.Second {
+		t.Error("Expected slow branch to be cancelled")
+	}
+}
+
+func TestRunCollectErrors(t *testing.T) {
+	stepMap := map[string]interface{}{
+		"failurePolicy": COLLECT_ERRORS,
+		"branches": map[string]interface{}{
+			"ok": []interface{}{
+				step("first", "test-echo", "fine", ""),
+			},
+			"broken": []interface{}{
+				step("fail", "test-fail", nil, ""),
+			},
+		},
+		"next": "respond",
+	}
+
+	output, next, err := runParallel(t, stepMap, map[string]interface{}{})
+
+	if err != nil {
+		t.Errorf(EXPECTED_NIL_GOT, err)
+	}
+	if next != "respond" {
+		t.Errorf(EXPECTED_BUT_GOT, "respond", next)
+	}
+	outputMap := output.(map[string]interface{})
+	if outputMap["ok"] != "fine" {
+		t.Errorf(EXPECTED_BUT_GOT, "fine", outputMap["ok"])
+	}
+	if _, ok := outputMap["errors"].(map[string]interface{})["broken"]; !ok {
+		t.Errorf(EXPECTED_BUT_GOT, "broken error", outputMap["errors"])
+	}
+}
+
+func TestRunWritesStepOutputs(t *testing.T) {
+	stepOutputs := map[string]interface{}{"request": "input"}
+	stepMap := map[string]interface{}{
+		"failurePolicy": COLLECT_ERRORS,
+		"branches": map[string]interface{}{
+			"cats": []interface{}{
+				step("meow", "test-echo", "meow", "purr"),
+				step("purr", "test-echo", "purr", ""),
+			},
+			"broken": []interface{}{
+				step("fail", "test-fail", nil, ""),
+			},
+		},
+		"next": "respond",
+	}
+
+	_, _, err := runParallel(t, stepMap, stepOutputs)
+
+	if err != nil {
+		t.Fatalf(EXPECTED_NIL_GOT, err)
+	}
+	if stepOutputs["meow"] != "meow" || stepOutputs["purr"] != "purr" {
+		t.Errorf(EXPECTED_BUT_GOT, "the outputs of every branch step", stepOutputs)
+	}
+	metadata := stepOutputs[server.METADATA_KEY].(map[string]interface{})
+	for _, name := range []string{"meow", "purr", "fail", "fanOut"} {
+		if _, ok := metadata[name]; !ok {
+			t.Errorf(EXPECTED_BUT_GOT, "metadata of "+name, metadata)
+		}
+	}
+	if failed, _ := stepOutputs[server.ERROR_KEY].(map[string]interface{}); failed["step"] != "fail" {
+		t.Errorf(EXPECTED_BUT_GOT, "the failure of fail", stepOutputs[server.ERROR_KEY])
+	}
+}
+
+func TestRunSharesStepOutputsConcurrently(t *testing.T) {
+	branches := map[string]interface{}{}
+	for i := 0; i < 10; i++ {
+		name := fmt.Sprintf("branch%d", i)
+		branches[name] = []interface{}{
+			step(name+"first", "test-read", nil, name+"second"),
+			step(name+"second", "test-read", nil, name+"third"),
+			step(name+"third", "test-read", nil, ""),
+		}
+	}
+	stepOutputs := map[string]interface{}{"request": "input"}
+
+	_, _, err := runParallel(t, map[string]interface{}{"branches": branches, "next": "respond"}, stepOutputs)
+
+	if err != nil {
+		t.Fatalf(EXPECTED_NIL_GOT, err)
+	}
+	// the request, the metadata and three outputs per branch
+	if len(stepOutputs) != 32 {
+		t.Errorf(EXPECTED_BUT_GOT, 32, len(stepOutputs))
+	}
+}
+
+func TestValidate(t *testing.T) {
+	branch := []interface{}{step("first", "test-echo", "meow", "")}
+	tests := []struct {
+		stepMap  map[string]interface{}
+		expected string
+	}{
+		{map[string]interface{}{"branches": "invalid", "next": ""}, "invalid branches format"},
+		{map[string]interface{}{"branches": map[string]interface{}{}, "next": ""}, "invalid branches format"},
+		{map[string]interface{}{"branches": map[string]interface{}{"cats": "invalid"}, "next": ""}, "invalid steps format for branch cats"},
+		{map[string]interface{}{"branches": map[string]interface{}{"errors": branch}, "next": ""}, "branch name errors is reserved"},
+		{map[string]interface{}{"branches": map[string]interface{}{"cats": branch}, "next": "", "failurePolicy": "ignore"}, "invalid failurePolicy: ignore"},
+		{map[string]interface{}{"branches": map[string]interface{}{"cats": branch}, "next": 1}, "invalid next format"},
+	}
+	for _, test := range tests {
+		errs := Validate(test.stepMap)
+
+		if len(errs) != 1 || errs[0].Error() != test.expected {
+			t.Errorf(EXPECTED_BUT_GOT, test.expected, errs)
+		}
+	}
+	if errs := Validate(map[string]interface{}{"branches": map[string]interface{}{"cats": branch}, "next": ""}); len(errs) > 0 {
+		t.Errorf(EXPECTED_NIL_GOT, errs)
+	}
+}
+
+func TestCompileInvalidBranch(t *testing.T) {
+	stepMap := map[string]interface{}{
+		"branches": map[string]interface{}{
+			"cats": []interface{}{
+				step("first", "unknown", nil, ""),
+			},
+		},
+		"next": "respond",
+	}
+
+	_, problems := compile(stepMap)
+
+	if len(problems) != 1 || problems[0].String() != `GET / step fanOut/cats/first: unregistered step type "unknown"` {
+		t.Errorf(EXPECTED_BUT_GOT, "an unregistered step type", problems)
+	}
+}
+
+func TestRunReusesCompiledBranches(t *testing.T) {
+	atomic.StoreInt32(&started, 0)
+	stepMap := map[string]interface{}{
+		"branches": map[string]interface{}{
+			"cats": []interface{}{step("first", "test-count", nil, "")},
+		},
+		"next": "respond",
+	}
+	flow, problems := compile(stepMap)
+	if len(problems) > 0 {
+		t.Fatalf(EXPECTED_NIL_GOT, problems)
+	}
+	// branches are compiled once, so changing the definition afterwards has no effect
+	stepMap["branches"] = map[string]interface{}{}
+
+	_, _, err := server.RunStep(branchContext(), flow.Steps["fanOut"], map[string]interface{}{})
+
+	if err != nil {
+		t.Errorf(EXPECTED_NIL_GOT, err)
+	}
+	if count := atomic.LoadInt32(&started); count != 1 {
+		t.Errorf(EXPECTED_BUT_GOT, 1, count)
+	}
+}
+
+func TestCompileDuplicateBranchStepNames(t *testing.T) {
+	stepMap := map[string]interface{}{
+		"branches": map[string]interface{}{
+			"cats": []interface{}{step("fetch", "test-echo", "meow", "")},
+			"dogs": []interface{}{step("fetch", "test-echo", "woof", "")},
+		},
+		"next": "respond",
+	}
+
+	_, problems := compile(stepMap)
+
+	if len(problems) != 1 || problems[0].String() != "GET / step fanOut/dogs/fetch: duplicate step name" {
+		t.Errorf(EXPECTED_BUT_GOT, "a duplicate step name", problems)
+	}
+}
+
+func TestRunWithoutBranchProcessor(t *testing.T) {
+	stepMap := map[string]interface{}{
+		"branches": map[string]interface{}{
+			"cats": []interface{}{
+				step("first", "test-echo", "meow", ""),
+			},
+		},
+		"next": "respond",
+	}
+
+	flow, _ := compile(stepMap)
+
+	_, next, err := server.RunStep(context.Background(), flow.Steps["fanOut"], map[string]interface{}{})
+
+	if err == nil {
+		t.Error(EXPECTED_ERROR_GOT_NIL)
+	}
+	if next != "error" {
+		t.Errorf(EXPECTED_BUT_GOT, "error", next)
+	}
+}
@@ -7,7 +7,6 @@ import (
 
 	"github.com/PaesslerAG/jsonpath"
 	"github.com/integronlabs/integron/helpers"
-	"github.com/sirupsen/logrus"
 )
 
 func Run(ctx context.Context, stepMap map[string]interface{}, stepOutputs map[string]interface{}) (interface{}, string, error) {
@@ -24,17 +23,18 @@ func Run(ctx context.Context, stepMap map[string]interface{}, stepOutputs map[st
 		return err.Error(), "error", err
 	}
 
-	logrus.WithContext(ctx).Debugf("inputString: %v", inputString)
-	logrus.WithContext(ctx).Debugf("next: %v", next)
+	helpers.Log(ctx).Debugf("inputString: %v", inputString)
+	helpers.Log(ctx).Debugf("next: %v", next)
 
 	// replace placeholders in input
 	inputMap, err := jsonpath.Get(inputString, stepOutputs)
 	if err != nil {
-		logrus.WithContext(ctx).Errorf("could not read value from input: %v", err)
+		helpers.Log(ctx).Errorf("could not read value from input: %v", err)
 		return err.Error(), "error", err
 	}
 
-	logrus.WithContext(ctx).Debugf("inputMap: %v", inputMap)
+	helpers.Log(ctx).Debugf("inputMap: %v", inputMap)
+	helpers.RecordInput(ctx, "input", inputMap)
 
 	body := helpers.RemoveNull(inputMap)
 
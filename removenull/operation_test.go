@@ -2,7 +2,10 @@ package removenull
 
 import (
 	"context"
+	"reflect"
 	"testing"
+
+	"github.com/integronlabs/integron/helpers"
 )
 
 const EXPECTED_NIL_GOT = "Expected nil, got %v"
@@ -102,3 +105,16 @@ func TestRunInvalidInputPath(t *testing.T) {
 		t.Errorf(EXPECTED_BUT_GOT, expectedError, err)
 	}
 }
+
+func TestRunRecordsInput(t *testing.T) {
+	recorded := make(map[string]interface{})
+	ctx := helpers.WithInputRecorder(context.Background(), func(name string, value interface{}) {
+		recorded[name] = value
+	})
+
+	Run(ctx, map[string]interface{}{"next": "next", "input": VALID_INPUT}, validOutputMap)
+
+	if !reflect.DeepEqual(recorded["input"], validOutputMap["output"]) {
+		t.Errorf(EXPECTED_BUT_GOT, validOutputMap["output"], recorded["input"])
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/integronlabs/integron/server"
+)
+
+func routes(args []string) int {
+	flags := flag.NewFlagSet("routes", flag.ExitOnError)
+	openapiSpecPath := flags.String("spec", envOrDefault("INTEGRON_SPEC", "docs/openapi.yaml"), "Path to the OpenAPI spec (INTEGRON_SPEC)")
+	flags.Parse(args)
+
+	doc, upstreams, err := loadSpec(context.Background(), *openapiSpecPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	registerSteps(upstreams)
+
+	flows, err := server.Compile(doc)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	printRoutes(os.Stdout, flows)
+	return 0
+}
+
+// printRoutes writes every flow sorted by path and method, followed by its steps and their targets.
+func printRoutes(out io.Writer, flows map[*openapi3.Operation]*server.Flow) {
+	sorted := make([]*server.Flow, 0, len(flows))
+	for _, flow := range flows {
+		sorted = append(sorted, flow)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Path != sorted[j].Path {
+			return sorted[i].Path < sorted[j].Path
+		}
+		return sorted[i].Method < sorted[j].Method
+	})
+
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	for _, flow := range sorted {
+		fmt.Fprintf(w, "%s\t%s\n", flow.Method, flow.Path)
+		for _, name := range flow.Order {
+			step := flow.Steps[name]
+			fmt.Fprintf(w, "\t  %s (%s)\t-> %s\n", step.Name, step.Type, formatTargets(step.Targets))
+		}
+	}
+	w.Flush()
+}
+
+func formatTargets(targets []string) string {
+	if len(targets) == 0 {
+		return "(none)"
+	}
+	formatted := make([]string, len(targets))
+	for i, target := range targets {
+		if target == "" {
+			target = "(respond)"
+		}
+		formatted[i] = target
+	}
+	sort.Strings(formatted)
+	return strings.Join(formatted, ", ")
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/integronlabs/integron/auth"
+	"github.com/integronlabs/integron/helpers"
+	httpOperation "github.com/integronlabs/integron/http"
+	"github.com/integronlabs/integron/metrics"
+	"github.com/integronlabs/integron/server"
+	"github.com/integronlabs/integron/tracing"
+	"github.com/sirupsen/logrus"
+	"github.com/swaggest/swgui/v5emb"
+)
+
+// SHUTDOWN_TIMEOUT bounds how long serve waits for requests in flight, and for traces to be
+// flushed, once it is asked to stop.
+const SHUTDOWN_TIMEOUT = 30 * time.Second
+
+func serve(args []string) int {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := flags.String("addr", envOrDefault("INTEGRON_ADDR", ":8080"), "Listen address (INTEGRON_ADDR)")
+	openapiSpecPath := flags.String("spec", envOrDefault("INTEGRON_SPEC", "docs/openapi.yaml"), "Path to the OpenAPI spec (INTEGRON_SPEC)")
+	docsPath := flags.String("docs", envOrDefault("INTEGRON_DOCS", "docs/"), "Directory served under /docs/ (INTEGRON_DOCS)")
+	logLevel := flags.String("log-level", envOrDefault("LOG_LEVEL", "info"), "Log level: debug, info, warn, error (LOG_LEVEL)")
+	logFormat := flags.String("log-format", envOrDefault("LOG_FORMAT", "json"), "Log format: json or text (LOG_FORMAT)")
+	requestFormat := flags.String("request-format", envOrDefault("INTEGRON_REQUEST_FORMAT", helpers.REQUEST_FORMAT_LEGACY), "Shape of $.request: legacy or structured (INTEGRON_REQUEST_FORMAT)")
+	problemDetails := flags.Bool("problem-details", envOrDefault("INTEGRON_PROBLEM_DETAILS", "false") == "true", "Answer errors with RFC 7807 application/problem+json (INTEGRON_PROBLEM_DETAILS)")
+	apiKeysPath := flags.String("api-keys", os.Getenv("INTEGRON_API_KEYS"), "JSON API key store for apiKey security schemes (INTEGRON_API_KEYS)")
+	jwtKeysPath := flags.String("jwt-keys", os.Getenv("INTEGRON_JWT_KEYS"), "JWKS or PEM file verifying bearer tokens (INTEGRON_JWT_KEYS)")
+	jwtIssuer := flags.String("jwt-issuer", os.Getenv("INTEGRON_JWT_ISSUER"), "Required iss of bearer tokens (INTEGRON_JWT_ISSUER)")
+	jwtAudience := flags.String("jwt-audience", os.Getenv("INTEGRON_JWT_AUDIENCE"), "Required aud of bearer tokens (INTEGRON_JWT_AUDIENCE)")
+	htpasswdPath := flags.String("htpasswd", os.Getenv("INTEGRON_HTPASSWD"), "htpasswd file for basic security schemes (INTEGRON_HTPASSWD)")
+	cacheMaxEntries := flags.Int("cache-max-entries", envIntOrDefault("INTEGRON_CACHE_MAX_ENTRIES", httpOperation.DEFAULT_CACHE_MAX_ENTRIES), "Upstream responses kept by http step caches (INTEGRON_CACHE_MAX_ENTRIES)")
+	cacheMaxBytes := flags.Int("cache-max-bytes", envIntOrDefault("INTEGRON_CACHE_MAX_BYTES", httpOperation.DEFAULT_CACHE_MAX_BYTES), "Total body size kept by http step caches (INTEGRON_CACHE_MAX_BYTES)")
+	metricsPath := flags.String("metrics-path", envOrDefault("INTEGRON_METRICS_PATH", "/metrics"), "Path serving Prometheus metrics, empty to disable (INTEGRON_METRICS_PATH)")
+	traceExporter := flags.String("trace-exporter", envOrDefault("INTEGRON_TRACE_EXPORTER", tracing.EXPORTER_NONE), "Trace exporter: none, stdout, file or otlp (INTEGRON_TRACE_EXPORTER)")
+	traceFile := flags.String("trace-file", envOrDefault("INTEGRON_TRACE_FILE", "traces.json"), "File written by the file trace exporter (INTEGRON_TRACE_FILE)")
+	requestIDHeader := flags.String("request-id-header", envOrDefault("INTEGRON_REQUEST_ID_HEADER", helpers.REQUEST_ID_HEADER), "Header forwarding the request id on http step calls, empty to disable (INTEGRON_REQUEST_ID_HEADER)")
+	adminToken := flags.String("admin-token", os.Getenv("INTEGRON_ADMIN_TOKEN"), "Bearer token enabling the /admin/ endpoints (INTEGRON_ADMIN_TOKEN)")
+	debug := flags.Bool("debug", envOrDefault("INTEGRON_DEBUG", "false") == "true", "Record the steps of requests sent with the admin token in X-Integron-Debug (INTEGRON_DEBUG)")
+	flags.Parse(args)
+
+	if err := helpers.SetupLogging(*logLevel, *logFormat); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	// requests log through their own logger, the standard one is left to code outside of them
+	logger, err := helpers.NewLogger(*logLevel, *logFormat)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	if *debug && *adminToken == "" {
+		fmt.Fprintln(os.Stderr, "-debug requires -admin-token")
+		return 2
+	}
+
+	if *requestFormat != helpers.REQUEST_FORMAT_STRUCTURED && *requestFormat != helpers.REQUEST_FORMAT_LEGACY {
+		fmt.Fprintf(os.Stderr, "unknown request format %q\n", *requestFormat)
+		return 2
+	}
+
+	httpOperation.RequestIDHeader = *requestIDHeader
+	httpOperation.ResponseCache = httpOperation.NewLRUCache(*cacheMaxEntries, int64(*cacheMaxBytes))
+
+	ctx := context.Background()
+	doc, upstreams, err := loadSpec(ctx, *openapiSpecPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	registerSteps(upstreams)
+
+	// Compile and validate every flow before serving
+	s, err := server.New(doc)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	cors, err := server.ParseCORSPolicy(doc.Extensions[server.CORS_EXTENSION])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	authenticator := &auth.Authenticator{}
+	if *apiKeysPath != "" {
+		if authenticator.APIKeys, err = auth.LoadAPIKeys(*apiKeysPath); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+	if *jwtKeysPath != "" {
+		keys, err := auth.LoadJWTKeys(*jwtKeysPath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		authenticator.JWT = &auth.JWTVerifier{Keys: keys, Issuer: *jwtIssuer, Audience: *jwtAudience}
+	}
+	if *htpasswdPath != "" {
+		if authenticator.Users, err = auth.LoadHtpasswd(*htpasswdPath); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+
+	s.Logger = logger
+	s.ProblemDetails = *problemDetails
+	s.RequestFormat = *requestFormat
+	s.CORS = cors
+	s.Auth = authenticator
+	if *debug {
+		s.Executions = server.NewExecutionStore(server.DEFAULT_MAX_EXECUTIONS)
+		s.DebugToken = *adminToken
+	}
+
+	var handler http.Handler = http.HandlerFunc(s.Handler)
+	mux := http.NewServeMux()
+	if *metricsPath != "" {
+		m := metrics.New()
+		s.StepMiddleware = append(s.StepMiddleware, m.Step)
+		instrumentClients(upstreams, m.Transport)
+		handler = m.Handler(handler)
+		mux.Handle("GET "+*metricsPath, m.Exposition())
+	}
+	if *traceExporter != tracing.EXPORTER_NONE {
+		shutdown, err := tracing.Setup(ctx, *traceExporter, *traceFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.Background(), SHUTDOWN_TIMEOUT)
+			defer cancel()
+			if err := shutdown(ctx); err != nil {
+				logrus.Errorf("Could not flush traces: %v", err)
+			}
+		}()
+		logger.AddHook(tracing.LogHook{})
+		s.StepMiddleware = append(s.StepMiddleware, tracing.Step)
+		instrumentClients(upstreams, tracing.Transport)
+		handler = tracing.Handler(handler)
+	}
+	mux.Handle("/", handler)
+
+	mountDocs(mux, *openapiSpecPath, *docsPath)
+
+	if *adminToken != "" {
+		mux.Handle("GET /admin/breakers", server.RequireAdminToken(*adminToken, http.HandlerFunc(httpOperation.BreakersHandler)))
+	}
+	if s.Executions != nil {
+		mux.Handle("GET "+server.EXECUTIONS_PATH+"{id}", server.RequireAdminToken(*adminToken, http.HandlerFunc(s.Executions.Handler)))
+	}
+
+	listener, err := net.Listen("tcp", *addr)
+	if err != nil {
+		logrus.Error(err)
+		return 1
+	}
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	logrus.Infof("Listening on %s", listener.Addr())
+	if err := serveUntilDone(ctx, &http.Server{Handler: mux}, listener); err != nil {
+		logrus.Error(err)
+		return 1
+	}
+	return 0
+}
+
+// serveUntilDone serves on the listener until ctx is done, then stops accepting connections and
+// waits up to SHUTDOWN_TIMEOUT for the requests in flight.
+func serveUntilDone(ctx context.Context, httpServer *http.Server, listener net.Listener) error {
+	errs := make(chan error, 1)
+	go func() {
+		errs <- httpServer.Serve(listener)
+	}()
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	logrus.Info("Shutting down")
+	ctx, cancel := context.WithTimeout(context.Background(), SHUTDOWN_TIMEOUT)
+	defer cancel()
+	return httpServer.Shutdown(ctx)
+}
+
+// mountDocs serves the docs directory under /docs/ and a Swagger UI of the spec under /ui/.
+func mountDocs(mux *http.ServeMux, specPath string, docsPath string) {
+	fs := http.FileServer(http.Dir(docsPath))
+	mux.Handle("/docs/", http.StripPrefix("/docs/", fs))
+
+	specURL := "/ui/openapi" + filepath.Ext(specPath)
+	mux.HandleFunc("GET "+specURL, func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFile(w, r, specPath)
+	})
+	mux.Handle("/ui/", v5emb.New(
+		"Integron",
+		specURL,
+		"/ui/",
+	))
+}
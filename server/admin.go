@@ -0,0 +1,20 @@
+package server
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// RequireAdminToken lets through requests that carry the admin token as a bearer token.
+func RequireAdminToken(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		presented, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(presented), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			Error(r, w, "Invalid admin token", http.StatusUnauthorized, "UNAUTHORIZED")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,35 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequireAdminToken(t *testing.T) {
+	handler := RequireAdminToken("s3cret", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	tests := []struct {
+		authorization string
+		status        int
+	}{
+		{"Bearer s3cret", http.StatusNoContent},
+		{"Bearer wrong", http.StatusUnauthorized},
+		{"Basic s3cret", http.StatusUnauthorized},
+		{"", http.StatusUnauthorized},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/admin/breakers", nil)
+		if test.authorization != "" {
+			r.Header.Set("Authorization", test.authorization)
+		}
+		w := httptest.NewRecorder()
+
+		handler.ServeHTTP(w, r)
+
+		if w.Code != test.status {
+			t.Errorf(EXPECTED_BUT_GOT, test.status, w.Code)
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/integronlabs/integron/helpers"
+)
+
+const CORS_EXTENSION = "x-integron-cors"
+
+// preflightMethods are the methods probed on the router to answer preflight requests.
+var preflightMethods = []string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete}
+
+// CORSPolicy decides which cross-origin requests browsers may make.
+type CORSPolicy struct {
+	// AllowedOrigins lists origins or patterns such as https://*.example.com; "*" allows any origin.
+	AllowedOrigins []string
+	// AllowedMethods defaults to the methods the router knows for the path.
+	AllowedMethods []string
+	// AllowedHeaders lists request headers; "*" allows whatever the preflight asks for.
+	AllowedHeaders   []string
+	ExposedHeaders   []string
+	AllowCredentials bool
+	MaxAge           time.Duration
+}
+
+// DefaultCORSPolicy allows any origin to send JSON, as Integron always has.
+func DefaultCORSPolicy() *CORSPolicy {
+	return &CORSPolicy{AllowedOrigins: []string{"*"}, AllowedHeaders: []string{"Content-Type"}}
+}
+
+// ParseCORSPolicy reads an x-integron-cors definition. A missing definition gives the default
+// policy and false disables CORS.
+func ParseCORSPolicy(value interface{}) (*CORSPolicy, error) {
+	if value == nil {
+		return DefaultCORSPolicy(), nil
+	}
+	if enabled, ok := value.(bool); ok {
+		if enabled {
+			return DefaultCORSPolicy(), nil
+		}
+		return &CORSPolicy{}, nil
+	}
+	definition, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid %s %v", CORS_EXTENSION, value)
+	}
+
+	policy := &CORSPolicy{}
+	var err error
+	if policy.AllowedOrigins, err = stringList(definition, "allowedOrigins"); err != nil {
+		return nil, err
+	}
+	if policy.AllowedMethods, err = stringList(definition, "allowedMethods"); err != nil {
+		return nil, err
+	}
+	for i, method := range policy.AllowedMethods {
+		policy.AllowedMethods[i] = strings.ToUpper(method)
+	}
+	if policy.AllowedHeaders, err = stringList(definition, "allowedHeaders"); err != nil {
+		return nil, err
+	}
+	if policy.ExposedHeaders, err = stringList(definition, "exposedHeaders"); err != nil {
+		return nil, err
+	}
+	if allowCredentials, ok := definition["allowCredentials"]; ok {
+		if policy.AllowCredentials, ok = allowCredentials.(bool); !ok {
+			return nil, fmt.Errorf("invalid allowCredentials %v", allowCredentials)
+		}
+	}
+	if maxAge, ok := definition["maxAge"]; ok {
+		if policy.MaxAge, err = helpers.ParseDuration(maxAge); err != nil || policy.MaxAge < 0 {
+			return nil, fmt.Errorf("invalid maxAge %v", maxAge)
+		}
+	}
+	for _, origin := range policy.AllowedOrigins {
+		if _, err := path.Match(origin, ""); err != nil {
+			return nil, fmt.Errorf("invalid origin pattern %q", origin)
+		}
+	}
+	// any site could otherwise make credentialed reads
+	if policy.AllowCredentials && policy.allowsAnyOrigin() {
+		return nil, errors.New(`allowCredentials requires explicit allowedOrigins instead of "*"`)
+	}
+	return policy, nil
+}
+
+func stringList(definition map[string]interface{}, key string) ([]string, error) {
+	value, ok := definition[key]
+	if !ok {
+		return nil, nil
+	}
+	items, ok := value.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid %s %v", key, value)
+	}
+	list := make([]string, len(items))
+	for i, item := range items {
+		if list[i], ok = item.(string); !ok {
+			return nil, fmt.Errorf("invalid %s %v", key, value)
+		}
+	}
+	return list, nil
+}
+
+func (p *CORSPolicy) allowsOrigin(origin string) bool {
+	for _, allowed := range p.AllowedOrigins {
+		if allowed == "*" || allowed == origin {
+			return true
+		}
+		if matched, _ := path.Match(allowed, origin); matched {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *CORSPolicy) allowsAnyOrigin() bool {
+	for _, allowed := range p.AllowedOrigins {
+		if allowed == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// Apply sets the CORS headers of a response to a request from an allowed origin and
+// reports whether the origin is allowed.
+func (p *CORSPolicy) Apply(h http.Header, r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || p == nil || !p.allowsOrigin(origin) {
+		return false
+	}
+	// credentials are never allowed for any origin
+	if p.allowsAnyOrigin() {
+		h.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		h.Set("Access-Control-Allow-Origin", origin)
+		h.Add("Vary", "Origin")
+		if p.AllowCredentials {
+			h.Set("Access-Control-Allow-Credentials", "true")
+		}
+	}
+	if len(p.ExposedHeaders) > 0 {
+		h.Set("Access-Control-Expose-Headers", strings.Join(p.ExposedHeaders, ", "))
+	}
+	return true
+}
+
+// cors returns the policy of an operation's flow, falling back to the server policy.
+func (s *Server) cors(flow *Flow) *CORSPolicy {
+	if flow != nil && flow.CORS != nil {
+		return flow.CORS
+	}
+	return s.CORS
+}
+
+// isPreflight reports whether a request is a CORS preflight request.
+func isPreflight(r *http.Request) bool {
+	return r.Method == http.MethodOptions && r.Header.Get("Origin") != "" && r.Header.Get("Access-Control-Request-Method") != ""
+}
+
+// Preflight answers a CORS preflight request with the methods the router knows for the path and
+// the policy of the operation the browser asks for.
+func (s *Server) Preflight(w http.ResponseWriter, r *http.Request) {
+	var knownMethods []string
+	var policy *CORSPolicy
+	requestedMethod := strings.ToUpper(r.Header.Get("Access-Control-Request-Method"))
+	for _, method := range preflightMethods {
+		probe := r.Clone(r.Context())
+		probe.Method = method
+		route, _, err := s.Router.FindRoute(probe)
+		if err != nil {
+			continue
+		}
+		knownMethods = append(knownMethods, method)
+		if method == requestedMethod {
+			policy = s.cors(s.Flows[route.Operation])
+		}
+	}
+	if len(knownMethods) == 0 {
+		s.Error(r, w, fmt.Errorf("Method not found"), http.StatusNotFound, "METHOD_NOT_FOUND")
+		return
+	}
+
+	h := w.Header()
+	h.Add("Vary", "Access-Control-Request-Method")
+	h.Add("Vary", "Access-Control-Request-Headers")
+	// a method without an operation gets no CORS headers, so the browser blocks the request
+	if policy != nil && policy.Apply(h, r) {
+		methods := policy.AllowedMethods
+		if len(methods) == 0 {
+			methods = knownMethods
+		}
+		h.Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+
+		headers := strings.Join(policy.AllowedHeaders, ", ")
+		for _, header := range policy.AllowedHeaders {
+			if header == "*" {
+				headers = r.Header.Get("Access-Control-Request-Headers")
+			}
+		}
+		if headers != "" {
+			h.Set("Access-Control-Allow-Headers", headers)
+		}
+		if policy.MaxAge > 0 {
+			h.Set("Access-Control-Max-Age", strconv.Itoa(int(policy.MaxAge.Seconds())))
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,193 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+const corsSpec = `
+openapi: 3.0.3
+info:
+  title: test
+  version: 1.0.0
+paths:
+  /dogs:
+    get:
+      responses:
+        '200':
+          description: ok
+      x-integron-steps:
+        - name: dogs
+          type: test-dogs
+    delete:
+      responses:
+        '200':
+          description: ok
+      x-integron-cors:
+        allowedOrigins: [https://admin.example.com]
+        allowCredentials: true
+      x-integron-steps:
+        - name: dogs
+          type: test-dogs
+`
+
+func newCORSServer(t *testing.T) *Server {
+	s := newTestServer(t, corsSpec)
+	s.CORS = &CORSPolicy{
+		AllowedOrigins: []string{"https://*.example.com"},
+		AllowedHeaders: []string{"Content-Type", "Authorization"},
+		ExposedHeaders: []string{"X-Request-ID"},
+		MaxAge:         10 * time.Minute,
+	}
+	return s
+}
+
+func preflight(s *Server, origin string, method string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(http.MethodOptions, "/dogs", nil)
+	request.Header.Set("Origin", origin)
+	request.Header.Set("Access-Control-Request-Method", method)
+	recorder := httptest.NewRecorder()
+	s.Handler(recorder, request)
+	return recorder
+}
+
+func TestParseCORSPolicy(t *testing.T) {
+	policy, err := ParseCORSPolicy(map[string]interface{}{
+		"allowedOrigins":   []interface{}{"https://*.example.com"},
+		"allowedMethods":   []interface{}{"get"},
+		"allowCredentials": true,
+		"maxAge":           float64(60),
+	})
+	if err != nil {
+		t.Fatalf(EXPECTED_NIL_GOT, err)
+	}
+	if policy.AllowedMethods[0] != http.MethodGet || !policy.AllowCredentials || policy.MaxAge != time.Minute {
+		t.Errorf(EXPECTED_BUT_GOT, "parsed policy", policy)
+	}
+
+	if policy, _ := ParseCORSPolicy(nil); !policy.allowsOrigin("https://any.example.org") {
+		t.Error("Expected the default policy to allow any origin")
+	}
+	if policy, _ := ParseCORSPolicy(false); policy.allowsOrigin("https://any.example.org") {
+		t.Error("Expected a disabled policy to allow no origin")
+	}
+
+	for _, value := range []interface{}{"yes", map[string]interface{}{"allowedOrigins": "*"}, map[string]interface{}{"maxAge": "soon"}, map[string]interface{}{"allowedOrigins": []interface{}{"["}}, map[string]interface{}{"allowedOrigins": []interface{}{"*"}, "allowCredentials": true}} {
+		if _, err := ParseCORSPolicy(value); err == nil {
+			t.Errorf("Expected error for %v, got nil", value)
+		}
+	}
+}
+
+func TestPreflight(t *testing.T) {
+	recorder := preflight(newCORSServer(t), "https://app.example.com", http.MethodGet)
+
+	if recorder.Code != http.StatusNoContent {
+		t.Fatalf(EXPECTED_BUT_GOT, http.StatusNoContent, recorder.Code)
+	}
+	expected := map[string]string{
+		"Access-Control-Allow-Origin":  "https://app.example.com",
+		"Access-Control-Allow-Methods": "GET, DELETE",
+		"Access-Control-Allow-Headers": "Content-Type, Authorization",
+		"Access-Control-Max-Age":       "600",
+	}
+	for header, value := range expected {
+		if got := recorder.Header().Get(header); got != value {
+			t.Errorf(EXPECTED_BUT_GOT, header+": "+value, got)
+		}
+	}
+}
+
+func TestPreflightOperationOverride(t *testing.T) {
+	s := newCORSServer(t)
+
+	recorder := preflight(s, "https://app.example.com", http.MethodDelete)
+	if origin := recorder.Header().Get("Access-Control-Allow-Origin"); origin != "" {
+		t.Errorf(EXPECTED_BUT_GOT, "no allowed origin", origin)
+	}
+
+	recorder = preflight(s, "https://admin.example.com", http.MethodDelete)
+	if credentials := recorder.Header().Get("Access-Control-Allow-Credentials"); credentials != "true" {
+		t.Errorf(EXPECTED_BUT_GOT, "true", credentials)
+	}
+}
+
+func TestPreflightUnknownPath(t *testing.T) {
+	s := newCORSServer(t)
+	request := httptest.NewRequest(http.MethodOptions, "/cats", nil)
+	request.Header.Set("Origin", "https://app.example.com")
+	request.Header.Set("Access-Control-Request-Method", http.MethodGet)
+	recorder := httptest.NewRecorder()
+
+	s.Handler(recorder, request)
+
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf(EXPECTED_BUT_GOT, http.StatusNotFound, recorder.Code)
+	}
+}
+
+func TestCORSHeadersOnResponses(t *testing.T) {
+	s := newCORSServer(t)
+
+	request := httptest.NewRequest(http.MethodGet, "/dogs", nil)
+	request.Header.Set("Origin", "https://app.example.com")
+	recorder := httptest.NewRecorder()
+	s.Handler(recorder, request)
+
+	if origin := recorder.Header().Get("Access-Control-Allow-Origin"); origin != "https://app.example.com" {
+		t.Errorf(EXPECTED_BUT_GOT, "https://app.example.com", origin)
+	}
+	if exposed := recorder.Header().Get("Access-Control-Expose-Headers"); exposed != "X-Request-ID" {
+		t.Errorf(EXPECTED_BUT_GOT, "X-Request-ID", exposed)
+	}
+
+	request = httptest.NewRequest(http.MethodGet, "/cats", nil)
+	request.Header.Set("Origin", "https://evil.example.org")
+	recorder = httptest.NewRecorder()
+	s.Handler(recorder, request)
+
+	if origin := recorder.Header().Get("Access-Control-Allow-Origin"); origin != "" {
+		t.Errorf(EXPECTED_BUT_GOT, "no allowed origin", origin)
+	}
+}
+
+func TestCompileInvalidCORS(t *testing.T) {
+	extensions := flowSteps(step("a", ""))
+	extensions[CORS_EXTENSION] = "everyone"
+
+	_, problems := CompileFlow("GET", "/dogs", extensions)
+
+	assertProblems(t, problems, "GET /dogs: invalid x-integron-cors everyone")
+}
+
+func TestDefaultCORSPolicyAllowsAnyOrigin(t *testing.T) {
+	s := newTestServer(t, corsSpec)
+	s.CORS = DefaultCORSPolicy()
+	request := httptest.NewRequest(http.MethodGet, "/dogs", nil)
+	request.Header.Set("Origin", "https://anywhere.test")
+	recorder := httptest.NewRecorder()
+
+	s.Handler(recorder, request)
+
+	if origin := recorder.Header().Get("Access-Control-Allow-Origin"); origin != "*" {
+		t.Errorf(EXPECTED_BUT_GOT, "*", origin)
+	}
+}
+
+func TestApplyNeverAllowsCredentialsForAnyOrigin(t *testing.T) {
+	policy := &CORSPolicy{AllowedOrigins: []string{"*"}, AllowCredentials: true}
+	request := httptest.NewRequest(http.MethodGet, "/dogs", nil)
+	request.Header.Set("Origin", "https://evil.example.net")
+	h := http.Header{}
+
+	policy.Apply(h, request)
+
+	if origin := h.Get("Access-Control-Allow-Origin"); origin != "*" {
+		t.Errorf(EXPECTED_BUT_GOT, "*", origin)
+	}
+	if credentials := h.Get("Access-Control-Allow-Credentials"); credentials != "" {
+		t.Errorf(EXPECTED_BUT_GOT, "no credentials", credentials)
+	}
+}
@@ -0,0 +1,236 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/integronlabs/integron/helpers"
+)
+
+// DEBUG_HEADER carries the debug token of a request whose execution should be recorded.
+const DEBUG_HEADER = "X-Integron-Debug"
+
+// EXECUTION_HEADER answers a recorded request with the id of its execution.
+const EXECUTION_HEADER = "X-Integron-Execution"
+
+// EXECUTIONS_PATH is where the admin endpoints serve recorded executions by id.
+const EXECUTIONS_PATH = "/admin/executions/"
+
+const DEFAULT_MAX_EXECUTIONS = 100
+
+// Execution is the step-by-step record of a request served in debug mode.
+type Execution struct {
+	ID         string           `json:"id"`
+	RequestID  string           `json:"requestId"`
+	Operation  string           `json:"operation"`
+	Method     string           `json:"method"`
+	Path       string           `json:"path"`
+	Start      time.Time        `json:"start"`
+	DurationMs int64            `json:"durationMs"`
+	Steps      []*StepExecution `json:"steps"`
+
+	// mu guards the execution and all of its steps, which parallel branches record concurrently.
+	mu sync.Mutex
+}
+
+// StepExecution records how a single step ran. Inputs and outputs are redacted JSON snapshots.
+type StepExecution struct {
+	Name       string                     `json:"name"`
+	Type       string                     `json:"type"`
+	Input      map[string]json.RawMessage `json:"input,omitempty"`
+	Output     json.RawMessage            `json:"output,omitempty"`
+	Next       string                     `json:"next"`
+	DurationMs int64                      `json:"durationMs"`
+	Error      string                     `json:"error,omitempty"`
+	// Steps lists the steps run by this one, such as the branches of a parallel step.
+	Steps []*StepExecution `json:"steps,omitempty"`
+
+	execution *Execution
+}
+
+func (e *Execution) MarshalJSON() ([]byte, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	type execution Execution
+	return json.Marshal((*execution)(e))
+}
+
+// snapshot encodes a value as it is now, without the secrets it contains. Secrets are redacted in
+// the decoded value rather than in its encoding, where JSON escapes characters such as & and ".
+func snapshot(value interface{}) json.RawMessage {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		encoded, _ = json.Marshal(fmt.Sprintf("%v", value))
+	}
+	decoder := json.NewDecoder(bytes.NewReader(encoded))
+	decoder.UseNumber()
+	var decoded interface{}
+	if err := decoder.Decode(&decoded); err != nil {
+		return json.RawMessage(helpers.Redact(string(encoded)))
+	}
+	encoded, _ = json.Marshal(helpers.RedactIn(decoded))
+	return json.RawMessage(encoded)
+}
+
+// executionFrame locates the steps being run in an execution; parent is nil for the steps of the flow.
+type executionFrame struct {
+	execution *Execution
+	parent    *StepExecution
+}
+
+type executionKey struct{}
+
+func withExecution(ctx context.Context, execution *Execution) context.Context {
+	return context.WithValue(ctx, executionKey{}, executionFrame{execution: execution})
+}
+
+func executionFrom(ctx context.Context) (executionFrame, bool) {
+	frame, ok := ctx.Value(executionKey{}).(executionFrame)
+	return frame, ok
+}
+
+// startStepExecution records a step of the execution of the context, if any, and returns the
+// context in which the step reports its inputs and records the steps it runs itself.
+func startStepExecution(ctx context.Context, step *Step) (context.Context, *StepExecution) {
+	frame, ok := executionFrom(ctx)
+	if !ok {
+		return ctx, nil
+	}
+	record := &StepExecution{Name: step.Name, Type: step.Type, execution: frame.execution}
+	frame.execution.mu.Lock()
+	if frame.parent == nil {
+		frame.execution.Steps = append(frame.execution.Steps, record)
+	} else {
+		frame.parent.Steps = append(frame.parent.Steps, record)
+	}
+	frame.execution.mu.Unlock()
+
+	ctx = context.WithValue(ctx, executionKey{}, executionFrame{execution: frame.execution, parent: record})
+	return helpers.WithInputRecorder(ctx, record.recordInput), record
+}
+
+func (s *StepExecution) recordInput(name string, value interface{}) {
+	encoded := snapshot(value)
+	s.execution.mu.Lock()
+	defer s.execution.mu.Unlock()
+	if s.Input == nil {
+		s.Input = make(map[string]json.RawMessage)
+	}
+	s.Input[name] = encoded
+}
+
+func (s *StepExecution) finish(output interface{}, next string, err error, duration time.Duration) {
+	if s == nil {
+		return
+	}
+	var encoded json.RawMessage
+	if err == nil {
+		encoded = snapshot(output)
+	}
+	s.execution.mu.Lock()
+	defer s.execution.mu.Unlock()
+	s.Output = encoded
+	s.Next = next
+	s.DurationMs = duration.Milliseconds()
+	if err != nil {
+		s.Error = helpers.Redact(err.Error())
+	}
+}
+
+// recordErrorTarget replaces the next step of the last step of the flow with the step handling its failure.
+func recordErrorTarget(ctx context.Context, target string) {
+	frame, ok := executionFrom(ctx)
+	if !ok || frame.parent != nil {
+		return
+	}
+	frame.execution.mu.Lock()
+	defer frame.execution.mu.Unlock()
+	if steps := frame.execution.Steps; len(steps) > 0 {
+		steps[len(steps)-1].Next = target
+	}
+}
+
+func (e *Execution) finish() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.DurationMs = time.Since(e.Start).Milliseconds()
+}
+
+// ExecutionStore keeps the most recent executions.
+type ExecutionStore struct {
+	mu         sync.Mutex
+	max        int
+	order      []string
+	executions map[string]*Execution
+}
+
+// NewExecutionStore returns a store keeping up to max executions, DEFAULT_MAX_EXECUTIONS when max is not positive.
+func NewExecutionStore(max int) *ExecutionStore {
+	if max <= 0 {
+		max = DEFAULT_MAX_EXECUTIONS
+	}
+	return &ExecutionStore{max: max, executions: make(map[string]*Execution)}
+}
+
+func (s *ExecutionStore) add(execution *Execution) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.order) >= s.max {
+		delete(s.executions, s.order[0])
+		s.order = s.order[1:]
+	}
+	s.order = append(s.order, execution.ID)
+	s.executions[execution.ID] = execution
+}
+
+// Get returns the execution with the id, or nil when it was never recorded or has been evicted.
+func (s *ExecutionStore) Get(id string) *Execution {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.executions[id]
+}
+
+// Handler serves the execution named by the id path value, e.g. of GET /admin/executions/{id}.
+func (s *ExecutionStore) Handler(w http.ResponseWriter, r *http.Request) {
+	execution := s.Get(r.PathValue("id"))
+	if execution == nil {
+		Error(r, w, "Execution not found", http.StatusNotFound, "NOT_FOUND")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(execution)
+}
+
+// startExecution records the execution of a request sent with the debug token. The token is
+// removed from the request so that steps never see it.
+func (s *Server) startExecution(r *http.Request, operation string, method string, path string) *Execution {
+	if s.Executions == nil || s.DebugToken == "" {
+		return nil
+	}
+	token := r.Header.Get(DEBUG_HEADER)
+	if token == "" {
+		return nil
+	}
+	r.Header.Del(DEBUG_HEADER)
+	if subtle.ConstantTimeCompare([]byte(token), []byte(s.DebugToken)) != 1 {
+		helpers.Log(r.Context()).Warnf("Ignoring %s header with an invalid token", DEBUG_HEADER)
+		return nil
+	}
+	execution := &Execution{
+		ID:        helpers.NewRequestID(),
+		RequestID: helpers.RequestIDFromContext(r.Context()),
+		Operation: operation,
+		Method:    method,
+		Path:      path,
+		Start:     time.Now(),
+		Steps:     []*StepExecution{},
+	}
+	s.Executions.add(execution)
+	return execution
+}
@@ -0,0 +1,192 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/integronlabs/integron/helpers"
+)
+
+const debugSpec = `
+openapi: 3.0.3
+info:
+  title: test
+  version: 1.0.0
+paths:
+  /facts/{id}:
+    get:
+      operationId: getFact
+      parameters:
+        - name: id
+          in: path
+          required: true
+          schema:
+            type: string
+      responses:
+        '200':
+          description: ok
+      x-integron-steps:
+        - name: upstream
+          type: test-http
+          method: GET
+          url: '%s/facts/$.request.path.id'
+          headers:
+            X-Fact: $.request.path.id
+          onError: fallback
+          responses:
+            '200':
+              output:
+                message: $.body.message
+              next: ''
+        - name: fallback
+          type: test-echo
+`
+
+func newDebugServer(t *testing.T) (*Server, *httptest.Server) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	s := newTestServer(t, fmt.Sprintf(debugSpec, upstream.URL))
+	s.Executions = NewExecutionStore(2)
+	s.DebugToken = "s3cret"
+	return s, upstream
+}
+
+func TestHandlerRecordsExecution(t *testing.T) {
+	s, upstream := newDebugServer(t)
+	defer upstream.Close()
+	request := httptest.NewRequest(http.MethodGet, "/facts/7", nil)
+	request.Header.Set(DEBUG_HEADER, "s3cret")
+	recorder := httptest.NewRecorder()
+
+	s.Handler(recorder, request)
+
+	id := recorder.Header().Get(EXECUTION_HEADER)
+	execution := s.Executions.Get(id)
+	if execution == nil {
+		t.Fatalf(EXPECTED_BUT_GOT, "a recorded execution", id)
+	}
+	if link := recorder.Header().Get("Link"); link != fmt.Sprintf(`</admin/executions/%s>; rel="execution"`, id) {
+		t.Errorf(EXPECTED_BUT_GOT, "a link to the execution", link)
+	}
+	if strings.Contains(recorder.Body.String(), "s3cret") {
+		t.Errorf(EXPECTED_BUT_GOT, "no debug token in the response", recorder.Body.String())
+	}
+	if execution.Operation != "getFact" || len(execution.Steps) != 2 {
+		t.Fatalf(EXPECTED_BUT_GOT, "the two steps of getFact", execution)
+	}
+
+	first, second := execution.Steps[0], execution.Steps[1]
+	if first.Name != "upstream" || first.Type != "test-http" || first.Next != "fallback" || first.Error == "" {
+		t.Errorf(EXPECTED_BUT_GOT, "upstream failing over to fallback", first)
+	}
+	var input struct {
+		Method  string              `json:"method"`
+		URL     string              `json:"url"`
+		Headers map[string][]string `json:"headers"`
+	}
+	if err := json.Unmarshal(first.Input["request"], &input); err != nil {
+		t.Fatalf(EXPECTED_NIL_GOT, err)
+	}
+	if input.Method != http.MethodGet || input.URL != upstream.URL+"/facts/7" || input.Headers["X-Fact"][0] != "7" {
+		t.Errorf(EXPECTED_BUT_GOT, "the resolved request", input)
+	}
+	if second.Name != "fallback" || second.Next != "" || second.Error != "" || len(second.Output) == 0 {
+		t.Errorf(EXPECTED_BUT_GOT, "fallback responding", second)
+	}
+}
+
+func TestHandlerIgnoresInvalidDebugToken(t *testing.T) {
+	s, upstream := newDebugServer(t)
+	defer upstream.Close()
+	for _, token := range []string{"", "wrong"} {
+		request := httptest.NewRequest(http.MethodGet, "/facts/7", nil)
+		if token != "" {
+			request.Header.Set(DEBUG_HEADER, token)
+		}
+		recorder := httptest.NewRecorder()
+
+		s.Handler(recorder, request)
+
+		if id := recorder.Header().Get(EXECUTION_HEADER); id != "" {
+			t.Errorf(EXPECTED_BUT_GOT, "no execution", id)
+		}
+	}
+}
+
+func TestRunStepRecordsNestedSteps(t *testing.T) {
+	upstream, _ := flakyServer(0, http.StatusOK, "")
+	defer upstream.Close()
+	execution := &Execution{}
+	ctx, parent := startStepExecution(withExecution(context.Background(), execution), &Step{Name: "branches", Type: "parallel"})
+
+	if _, _, err := RunStep(ctx, httpStep(upstream.URL, nil), map[string]interface{}{}); err != nil {
+		t.Fatalf(EXPECTED_NIL_GOT, err)
+	}
+
+	if len(execution.Steps) != 1 || len(parent.Steps) != 1 {
+		t.Fatalf(EXPECTED_BUT_GOT, "upstream nested in branches", execution.Steps)
+	}
+	if output := string(parent.Steps[0].Output); output != `{"message":"success"}` {
+		t.Errorf(EXPECTED_BUT_GOT, `{"message":"success"}`, output)
+	}
+}
+
+func TestExecutionStore(t *testing.T) {
+	store := NewExecutionStore(2)
+	for _, id := range []string{"a", "b", "c"} {
+		store.add(&Execution{ID: id})
+	}
+
+	if store.Get("a") != nil {
+		t.Errorf(EXPECTED_BUT_GOT, "a to be evicted", store.Get("a"))
+	}
+	tests := []struct {
+		id     string
+		status int
+	}{
+		{"c", http.StatusOK},
+		{"a", http.StatusNotFound},
+	}
+	for _, test := range tests {
+		request := httptest.NewRequest(http.MethodGet, EXECUTIONS_PATH+test.id, nil)
+		request.SetPathValue("id", test.id)
+		recorder := httptest.NewRecorder()
+
+		store.Handler(recorder, request)
+
+		if recorder.Code != test.status {
+			t.Errorf(EXPECTED_BUT_GOT, test.status, recorder.Code)
+		}
+	}
+}
+
+func TestSnapshotRedactsEscapedSecrets(t *testing.T) {
+	secret := `p&ss<"w>rd\`
+	t.Setenv("INTEGRON_TEST_SECRET", secret)
+	if _, err := helpers.ResolveSecrets(context.Background(), "${env:INTEGRON_TEST_SECRET}"); err != nil {
+		t.Fatalf(EXPECTED_NIL_GOT, err)
+	}
+
+	encoded := snapshot(map[string]interface{}{
+		"url":     "https://dogapi.dog/facts?key=" + secret,
+		"headers": http.Header{"Authorization": {"Bearer " + secret}},
+		"amount":  json.Number("9007199254740993"),
+	})
+
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		t.Fatalf(EXPECTED_NIL_GOT, err)
+	}
+	if strings.Contains(fmt.Sprint(decoded), secret) || !strings.Contains(string(encoded), helpers.REDACTED) {
+		t.Errorf(EXPECTED_BUT_GOT, "redacted secrets", string(encoded))
+	}
+	if !strings.Contains(string(encoded), `"amount":9007199254740993`) {
+		t.Errorf(EXPECTED_BUT_GOT, "the exact amount", string(encoded))
+	}
+}
@@ -0,0 +1,427 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/integronlabs/integron/helpers"
+	"github.com/sirupsen/logrus"
+)
+
+const STEPS_EXTENSION = "x-integron-steps"
+const TIMEOUT_EXTENSION = "x-integron-timeout"
+const ON_ERROR_EXTENSION = "x-integron-on-error"
+const REQUEST_FORMAT_EXTENSION = "x-integron-request-format"
+const LOG_LEVEL_EXTENSION = "x-integron-log-level"
+
+// Problem is a single defect found while compiling a flow.
+type Problem struct {
+	Method  string
+	Path    string
+	Step    string
+	Message string
+}
+
+func (p Problem) String() string {
+	if p.Method == "" {
+		return p.Message
+	}
+	if p.Step == "" {
+		return fmt.Sprintf("%s %s: %s", p.Method, p.Path, p.Message)
+	}
+	return fmt.Sprintf("%s %s step %s: %s", p.Method, p.Path, p.Step, p.Message)
+}
+
+// CompileError lists every problem found in the flows of a document.
+type CompileError struct {
+	Problems []Problem
+}
+
+func (e *CompileError) Error() string {
+	lines := []string{fmt.Sprintf("%d problem(s) found in %s", len(e.Problems), STEPS_EXTENSION)}
+	for _, problem := range e.Problems {
+		lines = append(lines, "  "+problem.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// isReservedTarget reports whether next is handled by the engine rather than naming a step.
+func isReservedTarget(next string) bool {
+	return next == "" || next == "end" || next == "error"
+}
+
+// Compile turns the x-integron-steps of every operation into flows, reporting all problems at once.
+func Compile(doc *openapi3.T) (map[*openapi3.Operation]*Flow, error) {
+	flows := make(map[*openapi3.Operation]*Flow)
+	var problems []Problem
+
+	if _, err := ParseCORSPolicy(doc.Extensions[CORS_EXTENSION]); err != nil {
+		problems = append(problems, Problem{Message: err.Error()})
+	}
+
+	pathItems := doc.Paths.Map()
+	paths := make([]string, 0, len(pathItems))
+	for path := range pathItems {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		operations := pathItems[path].Operations()
+		methods := make([]string, 0, len(operations))
+		for method := range operations {
+			methods = append(methods, method)
+		}
+		sort.Strings(methods)
+
+		for _, method := range methods {
+			operation := operations[method]
+			if _, ok := operation.Extensions[STEPS_EXTENSION]; !ok {
+				logrus.Warnf("%s %s has no %s and is answered with 501 Not Implemented", method, path, STEPS_EXTENSION)
+				continue
+			}
+			flow, flowProblems := CompileFlow(method, path, operation.Extensions)
+			problems = append(problems, flowProblems...)
+			if flow != nil {
+				flow.OperationID = operation.OperationID
+				flows[operation] = flow
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return nil, &CompileError{Problems: problems}
+	}
+	return flows, nil
+}
+
+// CompileFlow compiles the steps of a single operation together with its operation-level extensions.
+func CompileFlow(method string, path string, extensions map[string]interface{}) (*Flow, []Problem) {
+	operationProblem := func(format string, args ...interface{}) Problem {
+		return Problem{Method: method, Path: path, Message: fmt.Sprintf(format, args...)}
+	}
+
+	stepsArray, ok := extensions[STEPS_EXTENSION].([]interface{})
+	if !ok {
+		return nil, []Problem{operationProblem("missing or invalid %s", STEPS_EXTENSION)}
+	}
+
+	var problems []Problem
+	onError, ok := extensions[ON_ERROR_EXTENSION].(string)
+	if _, exists := extensions[ON_ERROR_EXTENSION]; exists && (!ok || onError == "") {
+		problems = append(problems, operationProblem("invalid %s %v", ON_ERROR_EXTENSION, extensions[ON_ERROR_EXTENSION]))
+	}
+
+	flow, stepProblems := compileSteps(method, path, "", stepsArray, onError, map[string]bool{})
+	problems = append(problems, stepProblems...)
+
+	if timeout, ok := extensions[TIMEOUT_EXTENSION]; ok {
+		duration, err := helpers.ParseDuration(timeout)
+		if err != nil || duration <= 0 {
+			problems = append(problems, operationProblem("invalid %s %v", TIMEOUT_EXTENSION, timeout))
+		} else if flow != nil {
+			flow.Timeout = duration
+		}
+	}
+
+	if cors, ok := extensions[CORS_EXTENSION]; ok {
+		policy, err := ParseCORSPolicy(cors)
+		if err != nil {
+			problems = append(problems, operationProblem("%v", err))
+		} else if flow != nil {
+			flow.CORS = policy
+		}
+	}
+
+	if requestFormat, ok := extensions[REQUEST_FORMAT_EXTENSION]; ok {
+		if requestFormat != helpers.REQUEST_FORMAT_STRUCTURED && requestFormat != helpers.REQUEST_FORMAT_LEGACY {
+			problems = append(problems, operationProblem("invalid %s %v", REQUEST_FORMAT_EXTENSION, requestFormat))
+		} else if flow != nil {
+			flow.RequestFormat = requestFormat.(string)
+		}
+	}
+
+	if logLevel, ok := extensions[LOG_LEVEL_EXTENSION]; ok {
+		value, _ := logLevel.(string)
+		level, err := helpers.ParseLogLevel(value)
+		if err != nil || value == "" {
+			problems = append(problems, operationProblem("invalid %s %v", LOG_LEVEL_EXTENSION, logLevel))
+		} else if flow != nil {
+			flow.LogLevel = &level
+		}
+	}
+	return flow, problems
+}
+
+// compileSteps compiles a flow or a branch of it. Branches share the step outputs of the flow, so
+// names holds the step names of the whole operation.
+func compileSteps(method string, path string, prefix string, stepsArray []interface{}, onError string, names map[string]bool) (*Flow, []Problem) {
+	var problems []Problem
+	report := func(step string, format string, args ...interface{}) {
+		problems = append(problems, Problem{Method: method, Path: path, Step: prefix + step, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if len(stepsArray) == 0 {
+		report("", "no steps defined")
+		return nil, problems
+	}
+
+	flow := &Flow{Method: method, Path: path, OnError: onError, Steps: make(map[string]*Step)}
+
+	for i, v := range stepsArray {
+		stepMap, ok := v.(map[string]interface{})
+		if !ok {
+			report(fmt.Sprintf("#%d", i), helpers.INVALID_STEP_DEFINITION)
+			continue
+		}
+		name, ok := stepMap["name"].(string)
+		if !ok || name == "" {
+			report(fmt.Sprintf("#%d", i), "missing or invalid name")
+			continue
+		}
+		if (isReservedTarget(name) && name != "error") || name == "request" || name == METADATA_KEY || name == ERROR_KEY {
+			report(name, "step name is reserved")
+			continue
+		}
+		if names[name] {
+			report(name, "duplicate step name")
+			continue
+		}
+		names[name] = true
+		if i == 0 {
+			flow.Start = name
+		}
+
+		step := &Step{Name: name, Definition: stepMap}
+		flow.Steps[name] = step
+		flow.Order = append(flow.Order, name)
+
+		step.Type, ok = stepMap["type"].(string)
+		if !ok {
+			report(name, "missing or invalid type")
+			continue
+		}
+		if _, err := GetStepHandler(step.Type); err != nil {
+			report(name, "unregistered step type %q", step.Type)
+			continue
+		}
+
+		schema := GetStepSchema(step.Type)
+		for _, field := range schema.Required {
+			if _, ok := stepMap[field]; !ok {
+				report(name, "missing required field %q", field)
+			}
+		}
+
+		if schema.Validate != nil {
+			for _, err := range schema.Validate(stepMap) {
+				report(name, "%v", err)
+			}
+		}
+
+		if schema.Compile != nil {
+			var errs []error
+			step.Compiled, errs = schema.Compile(stepMap)
+			for _, err := range errs {
+				report(name, "%v", err)
+			}
+		}
+
+		step.Targets = schema.targets(stepMap)
+		if _, ok := stepMap["onError"]; ok {
+			step.OnError, ok = stepMap["onError"].(string)
+			if !ok || step.OnError == "" {
+				report(name, "invalid onError %v", stepMap["onError"])
+			} else {
+				step.Targets = append(step.Targets, step.OnError)
+			}
+		}
+
+		for _, err := range step.compileOptions() {
+			report(name, "%v", err)
+		}
+
+		if schema.Branches != nil {
+			branches := schema.Branches(stepMap)
+			branchNames := make([]string, 0, len(branches))
+			for branchName := range branches {
+				branchNames = append(branchNames, branchName)
+			}
+			sort.Strings(branchNames)
+			step.Branches = make(map[string]*Flow, len(branches))
+			for _, branchName := range branchNames {
+				branch, branchProblems := compileSteps(method, path, prefix+name+"/"+branchName+"/", branches[branchName], "", names)
+				problems = append(problems, branchProblems...)
+				if branch != nil {
+					step.Branches[branchName] = branch
+				}
+			}
+		}
+	}
+
+	for _, name := range flow.Order {
+		for _, next := range flow.Steps[name].Targets {
+			if _, exists := flow.Steps[next]; !exists && !isReservedTarget(next) {
+				report(name, "next target %q does not exist", next)
+			}
+		}
+	}
+
+	if _, exists := flow.Steps[onError]; onError != "" && !exists {
+		report("", "%s target %q does not exist", ON_ERROR_EXTENSION, onError)
+	}
+
+	if flow.Start == "" {
+		return nil, problems
+	}
+
+	reachable := flow.reachable()
+	for _, name := range flow.Order {
+		if !reachable[name] {
+			report(name, "unreachable step")
+		}
+	}
+
+	for _, cycle := range flow.cycles() {
+		report(cycle[0], "cycle %s", strings.Join(cycle, " -> "))
+	}
+
+	return flow, problems
+}
+
+func (schema StepSchema) targets(stepMap map[string]interface{}) []string {
+	if schema.Targets != nil {
+		return schema.Targets(stepMap)
+	}
+	return NextTarget(stepMap)
+}
+
+// compileOptions parses the engine-level fields every step type supports.
+func (step *Step) compileOptions() []error {
+	var errs []error
+	if retry, ok := step.Definition["retry"]; ok {
+		policy, err := ParseRetryPolicy(retry)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		step.Retry = policy
+	}
+	if timeout, ok := step.Definition["timeout"]; ok {
+		duration, err := helpers.ParseDuration(timeout)
+		if err != nil || duration <= 0 {
+			errs = append(errs, fmt.Errorf("invalid timeout %v", timeout))
+		}
+		step.Timeout = duration
+	}
+	for _, provider := range helpers.SecretReferences(step.Definition) {
+		if !helpers.HasSecretProvider(provider) {
+			errs = append(errs, fmt.Errorf("unknown secret provider %q", provider))
+		}
+		step.Secrets = true
+	}
+	return errs
+}
+
+// NewStep compiles a single step definition, as used by steps that run nested step sequences.
+func NewStep(stepMap map[string]interface{}) (*Step, error) {
+	name, ok := stepMap["name"].(string)
+	if !ok {
+		return nil, fmt.Errorf(helpers.INVALID_STEP_DEFINITION)
+	}
+	stepType, ok := stepMap["type"].(string)
+	if !ok {
+		return nil, fmt.Errorf("missing or invalid step type")
+	}
+	step := &Step{Name: name, Type: stepType, Definition: stepMap}
+	schema := GetStepSchema(stepType)
+	step.Targets = schema.targets(stepMap)
+	errs := step.compileOptions()
+	if schema.Compile != nil {
+		var compileErrs []error
+		step.Compiled, compileErrs = schema.Compile(stepMap)
+		errs = append(errs, compileErrs...)
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return step, nil
+}
+
+// reachable walks the graph from the start step; the error handlers are reachable from any step that can fail.
+func (f *Flow) reachable() map[string]bool {
+	visited := map[string]bool{}
+	queue := []string{f.Start}
+	if f.OnError != "" {
+		queue = append(queue, f.OnError)
+	}
+	if _, exists := f.Steps["error"]; exists {
+		queue = append(queue, "error")
+	}
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+		step, exists := f.Steps[name]
+		if !exists || visited[name] {
+			continue
+		}
+		visited[name] = true
+		queue = append(queue, step.Targets...)
+	}
+	return visited
+}
+
+// edges lists the steps a step can continue to, including the step handling its failure.
+func (f *Flow) edges(step *Step) []string {
+	target := f.errorTarget(step)
+	if target == step.Name {
+		return step.Targets
+	}
+	return append(append([]string{}, step.Targets...), target)
+}
+
+// cycles returns every cycle in the graph as the list of step names forming it.
+func (f *Flow) cycles() [][]string {
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+	state := map[string]int{}
+	var stack []string
+	var cycles [][]string
+
+	var visit func(name string)
+	visit = func(name string) {
+		state[name] = visiting
+		stack = append(stack, name)
+		for _, next := range f.edges(f.Steps[name]) {
+			if _, exists := f.Steps[next]; !exists {
+				continue
+			}
+			switch state[next] {
+			case unvisited:
+				visit(next)
+			case visiting:
+				for i := len(stack) - 1; i >= 0; i-- {
+					if stack[i] == next {
+						cycle := append(append([]string{}, stack[i:]...), next)
+						cycles = append(cycles, cycle)
+						break
+					}
+				}
+			}
+		}
+		stack = stack[:len(stack)-1]
+		state[name] = done
+	}
+
+	for _, name := range f.Order {
+		if state[name] == unvisited {
+			visit(name)
+		}
+	}
+	return cycles
+}
@@ -0,0 +1,394 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/sirupsen/logrus"
+)
+
+const EXPECTED_NIL_GOT = "Expected nil, got %v"
+const EXPECTED_ERROR_GOT_NIL = "Expected error, got nil"
+const EXPECTED_BUT_GOT = "Expected %v, got %v"
+
+func init() {
+	RegisterStep("test-next", func(ctx context.Context, stepMap map[string]interface{}, stepOutputs map[string]interface{}) (interface{}, string, error) {
+		next, _ := stepMap["next"].(string)
+		return nil, next, nil
+	})
+	RegisterStepSchema("test-next", StepSchema{Required: []string{"next"}})
+	RegisterStep("test-branches", func(ctx context.Context, stepMap map[string]interface{}, stepOutputs map[string]interface{}) (interface{}, string, error) {
+		return nil, "", errors.New("not implemented")
+	})
+	RegisterStepSchema("test-branches", StepSchema{
+		Branches: func(stepMap map[string]interface{}) map[string][]interface{} {
+			branch, _ := stepMap["branch"].([]interface{})
+			return map[string][]interface{}{"only": branch}
+		},
+	})
+	RegisterStep("test-compile", func(ctx context.Context, stepMap map[string]interface{}, stepOutputs map[string]interface{}) (interface{}, string, error) {
+		return CurrentStep(ctx).Compiled, "", nil
+	})
+	RegisterStepSchema("test-compile", StepSchema{
+		Compile: func(stepMap map[string]interface{}) (interface{}, []error) {
+			target, ok := stepMap["target"].(string)
+			if !ok {
+				return nil, []error{errors.New("missing target")}
+			}
+			return "compiled " + target, nil
+		},
+	})
+	RegisterStep("test-validate", func(ctx context.Context, stepMap map[string]interface{}, stepOutputs map[string]interface{}) (interface{}, string, error) {
+		return nil, "", nil
+	})
+	RegisterStepSchema("test-validate", StepSchema{
+		Validate: func(stepMap map[string]interface{}) []error {
+			if _, ok := stepMap["target"]; !ok {
+				return []error{errors.New("missing target")}
+			}
+			return nil
+		},
+	})
+}
+
+func step(name string, next string) map[string]interface{} {
+	return map[string]interface{}{"name": name, "type": "test-next", "next": next}
+}
+
+func flowSteps(steps ...interface{}) map[string]interface{} {
+	return map[string]interface{}{STEPS_EXTENSION: steps}
+}
+
+func assertProblems(t *testing.T, problems []Problem, expected ...string) {
+	if len(problems) != len(expected) {
+		t.Fatalf(EXPECTED_BUT_GOT, expected, problems)
+	}
+	for i, problem := range problems {
+		if problem.String() != expected[i] {
+			t.Errorf(EXPECTED_BUT_GOT, expected[i], problem.String())
+		}
+	}
+}
+
+func TestCompileFlow(t *testing.T) {
+	flow, problems := CompileFlow("GET", "/facts", flowSteps(
+		step("first", "second"),
+		step("second", ""),
+		step("error", ""),
+	))
+
+	assertProblems(t, problems)
+	if flow.Start != "first" {
+		t.Errorf(EXPECTED_BUT_GOT, "first", flow.Start)
+	}
+	if flow.Steps["second"].Type != "test-next" {
+		t.Errorf(EXPECTED_BUT_GOT, "test-next", flow.Steps["second"].Type)
+	}
+}
+
+func TestCompileFlowMissingExtension(t *testing.T) {
+	flow, problems := CompileFlow("GET", "/facts", nil)
+
+	if flow != nil {
+		t.Errorf(EXPECTED_NIL_GOT, flow)
+	}
+	assertProblems(t, problems, "GET /facts: missing or invalid x-integron-steps")
+}
+
+func TestCompileFlowInvalidSteps(t *testing.T) {
+	_, problems := CompileFlow("GET", "/facts", flowSteps(
+		step("first", "missing"),
+		"invalid",
+		map[string]interface{}{"type": "test-next"},
+		step("first", ""),
+		map[string]interface{}{"name": "unknown", "type": "unknown"},
+		map[string]interface{}{"name": "noNext", "type": "test-next"},
+	))
+
+	assertProblems(t, problems,
+		"GET /facts step #1: invalid step definition",
+		"GET /facts step #2: missing or invalid name",
+		"GET /facts step first: duplicate step name",
+		"GET /facts step unknown: unregistered step type \"unknown\"",
+		"GET /facts step noNext: missing required field \"next\"",
+		"GET /facts step first: next target \"missing\" does not exist",
+		"GET /facts step unknown: unreachable step",
+		"GET /facts step noNext: unreachable step",
+	)
+}
+
+func TestCompileFlowCycle(t *testing.T) {
+	_, problems := CompileFlow("GET", "/facts", flowSteps(
+		step("first", "second"),
+		step("second", "first"),
+	))
+
+	assertProblems(t, problems, "GET /facts step first: cycle first -> second -> first")
+}
+
+func TestCompileFlowOnErrorCycle(t *testing.T) {
+	extensions := flowSteps(
+		step("first", ""),
+		step("handler", "retry"),
+		step("retry", ""),
+	)
+	extensions[ON_ERROR_EXTENSION] = "handler"
+
+	_, problems := CompileFlow("GET", "/facts", extensions)
+
+	assertProblems(t, problems, "GET /facts step handler: cycle handler -> retry -> handler")
+}
+
+func TestCompileFlowBranches(t *testing.T) {
+	_, problems := CompileFlow("GET", "/facts", flowSteps(
+		map[string]interface{}{
+			"name": "fanOut",
+			"type": "test-branches",
+			"branch": []interface{}{
+				step("inner", "missing"),
+			},
+		},
+	))
+
+	assertProblems(t, problems, "GET /facts step fanOut/only/inner: next target \"missing\" does not exist")
+}
+
+func TestCompileFlowKeepsBranches(t *testing.T) {
+	flow, problems := CompileFlow("GET", "/facts", flowSteps(
+		map[string]interface{}{
+			"name": "fanOut",
+			"type": "test-branches",
+			"branch": []interface{}{
+				step("inner", "last"),
+				step("last", ""),
+			},
+		},
+	))
+
+	assertProblems(t, problems)
+	branch := flow.Steps["fanOut"].Branches["only"]
+	if branch == nil || branch.Start != "inner" || branch.Steps["last"] == nil {
+		t.Errorf(EXPECTED_BUT_GOT, "the compiled branch", branch)
+	}
+}
+
+func TestCompileFlowCompilesSteps(t *testing.T) {
+	flow, problems := CompileFlow("GET", "/facts", flowSteps(
+		map[string]interface{}{"name": "first", "type": "test-compile", "target": "facts"},
+	))
+
+	assertProblems(t, problems)
+	output, _, err := RunStep(context.Background(), flow.Steps["first"], map[string]interface{}{})
+	if err != nil || output != "compiled facts" {
+		t.Errorf(EXPECTED_BUT_GOT, "compiled facts", output)
+	}
+
+	_, problems = CompileFlow("GET", "/facts", flowSteps(
+		map[string]interface{}{"name": "first", "type": "test-compile"},
+	))
+
+	assertProblems(t, problems, "GET /facts step first: missing target")
+}
+
+func TestCompile(t *testing.T) {
+	paths := openapi3.NewPaths()
+	valid := openapi3.NewOperation()
+	valid.Extensions = map[string]interface{}{
+		STEPS_EXTENSION: []interface{}{step("first", "")},
+	}
+	invalid := openapi3.NewOperation()
+	invalid.Extensions = map[string]interface{}{STEPS_EXTENSION: "invalid"}
+	documented := openapi3.NewOperation()
+	paths.Set("/valid", &openapi3.PathItem{Get: valid, Put: documented})
+	paths.Set("/invalid", &openapi3.PathItem{Post: invalid})
+	doc := &openapi3.T{Paths: paths}
+
+	flows, err := Compile(doc)
+
+	if err == nil {
+		t.Fatal(EXPECTED_ERROR_GOT_NIL)
+	}
+	if flows != nil {
+		t.Errorf(EXPECTED_NIL_GOT, flows)
+	}
+	if !strings.Contains(err.Error(), "POST /invalid: missing or invalid x-integron-steps") {
+		t.Errorf(EXPECTED_BUT_GOT, "POST /invalid problem", err.Error())
+	}
+
+	paths.Delete("/invalid")
+	flows, err = Compile(doc)
+
+	if err != nil {
+		t.Errorf(EXPECTED_NIL_GOT, err)
+	}
+	if flows[valid].Start != "first" {
+		t.Errorf(EXPECTED_BUT_GOT, "first", flows[valid].Start)
+	}
+	if _, ok := flows[documented]; ok {
+		t.Errorf(EXPECTED_BUT_GOT, "no flow for a documentation-only operation", flows[documented])
+	}
+}
+
+func TestCompileFlowInvalidRetry(t *testing.T) {
+	invalid := step("first", "")
+	invalid["retry"] = map[string]interface{}{"maxAttempts": "many"}
+
+	_, problems := CompileFlow("GET", "/facts", flowSteps(invalid))
+
+	assertProblems(t, problems, "GET /facts step first: invalid retry maxAttempts many")
+}
+
+func TestCompileFlowValidate(t *testing.T) {
+	_, problems := CompileFlow("GET", "/facts", flowSteps(
+		map[string]interface{}{"name": "first", "type": "test-validate", "next": ""},
+	))
+
+	assertProblems(t, problems, "GET /facts step first: missing target")
+}
+
+func TestCompileTimeouts(t *testing.T) {
+	timed := step("first", "")
+	timed["timeout"] = "2s"
+	paths := openapi3.NewPaths()
+	operation := openapi3.NewOperation()
+	operation.Extensions = map[string]interface{}{
+		STEPS_EXTENSION:   []interface{}{timed},
+		TIMEOUT_EXTENSION: "5s",
+	}
+	paths.Set("/facts", &openapi3.PathItem{Get: operation})
+
+	flows, err := Compile(&openapi3.T{Paths: paths})
+
+	if err != nil {
+		t.Fatalf(EXPECTED_NIL_GOT, err)
+	}
+	if flows[operation].Timeout != 5*time.Second {
+		t.Errorf(EXPECTED_BUT_GOT, 5*time.Second, flows[operation].Timeout)
+	}
+	if flows[operation].Steps["first"].Timeout != 2*time.Second {
+		t.Errorf(EXPECTED_BUT_GOT, 2*time.Second, flows[operation].Steps["first"].Timeout)
+	}
+
+	operation.Extensions[TIMEOUT_EXTENSION] = "forever"
+	_, err = Compile(&openapi3.T{Paths: paths})
+
+	if err == nil || !strings.Contains(err.Error(), "GET /facts: invalid x-integron-timeout forever") {
+		t.Errorf(EXPECTED_BUT_GOT, "invalid timeout problem", err)
+	}
+}
+
+func TestCompileFlowOnError(t *testing.T) {
+	failing := step("first", "")
+	failing["onError"] = "handleFirst"
+	extensions := flowSteps(
+		failing,
+		step("handleFirst", ""),
+		step("fallback", ""),
+	)
+	extensions[ON_ERROR_EXTENSION] = "fallback"
+
+	flow, problems := CompileFlow("GET", "/facts", extensions)
+
+	assertProblems(t, problems)
+	if flow.OnError != "fallback" {
+		t.Errorf(EXPECTED_BUT_GOT, "fallback", flow.OnError)
+	}
+	if target := flow.errorTarget(flow.Steps["first"]); target != "handleFirst" {
+		t.Errorf(EXPECTED_BUT_GOT, "handleFirst", target)
+	}
+	if target := flow.errorTarget(flow.Steps["handleFirst"]); target != "fallback" {
+		t.Errorf(EXPECTED_BUT_GOT, "fallback", target)
+	}
+	if target := flow.errorTarget(flow.Steps["fallback"]); target != "error" {
+		t.Errorf(EXPECTED_BUT_GOT, "error", target)
+	}
+}
+
+func TestCompileFlowInvalidOnError(t *testing.T) {
+	failing := step("first", "")
+	failing["onError"] = "missing"
+	extensions := flowSteps(failing)
+	extensions[ON_ERROR_EXTENSION] = "alsoMissing"
+
+	_, problems := CompileFlow("GET", "/facts", extensions)
+
+	assertProblems(t, problems,
+		"GET /facts step first: next target \"missing\" does not exist",
+		"GET /facts: x-integron-on-error target \"alsoMissing\" does not exist",
+	)
+}
+
+func TestCompileFlowSecrets(t *testing.T) {
+	withSecret := step("first", "")
+	withSecret["token"] = "${env:INTEGRON_TEST_TOKEN}"
+	unknownProvider := step("second", "")
+	unknownProvider["token"] = "${vault:kv/token}"
+
+	flow, problems := CompileFlow("GET", "/facts", flowSteps(withSecret))
+	assertProblems(t, problems)
+	if !flow.Steps["first"].Secrets {
+		t.Error("Expected step first to reference secrets")
+	}
+
+	_, problems = CompileFlow("GET", "/facts", flowSteps(unknownProvider))
+	assertProblems(t, problems, "GET /facts step second: unknown secret provider \"vault\"")
+}
+
+func TestRunStepResolvesSecrets(t *testing.T) {
+	t.Setenv("INTEGRON_TEST_KEY", "resolved")
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"message": r.Header.Get("X-Api-Key")})
+	}))
+	defer upstream.Close()
+	headers := map[string]interface{}{"X-Api-Key": "${env:INTEGRON_TEST_KEY}"}
+	flow, problems := CompileFlow("GET", "/facts", flowSteps(map[string]interface{}{
+		"name":    "first",
+		"type":    "test-http",
+		"method":  "GET",
+		"url":     upstream.URL,
+		"headers": headers,
+		"responses": map[string]interface{}{
+			"200": map[string]interface{}{"output": map[string]interface{}{"message": "$.body.message"}, "next": ""},
+		},
+	}))
+	assertProblems(t, problems)
+
+	output, _, err := RunStep(context.Background(), flow.Steps["first"], map[string]interface{}{})
+
+	if err != nil {
+		t.Fatalf(EXPECTED_NIL_GOT, err)
+	}
+	if message := output.(map[string]interface{})["message"]; message != "resolved" {
+		t.Errorf(EXPECTED_BUT_GOT, "resolved", message)
+	}
+	if headers["X-Api-Key"] != "${env:INTEGRON_TEST_KEY}" {
+		t.Errorf(EXPECTED_BUT_GOT, "the definition left untouched", headers["X-Api-Key"])
+	}
+}
+
+func TestCompileFlowLogLevel(t *testing.T) {
+	extensions := flowSteps(step("first", ""))
+	extensions[LOG_LEVEL_EXTENSION] = "debug"
+
+	flow, problems := CompileFlow("GET", "/facts", extensions)
+
+	assertProblems(t, problems)
+	if flow.LogLevel == nil || *flow.LogLevel != logrus.DebugLevel {
+		t.Errorf(EXPECTED_BUT_GOT, logrus.DebugLevel, flow.LogLevel)
+	}
+
+	for _, invalid := range []interface{}{"verbose", "", 5} {
+		extensions[LOG_LEVEL_EXTENSION] = invalid
+		_, problems = CompileFlow("GET", "/facts", extensions)
+
+		assertProblems(t, problems, fmt.Sprintf("GET /facts: invalid x-integron-log-level %v", invalid))
+	}
+}
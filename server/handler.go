@@ -1,15 +1,26 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
 	"net/http"
 	"strconv"
+	"time"
 
+	"github.com/getkin/kin-openapi/openapi3"
 	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/integronlabs/integron/auth"
 	"github.com/integronlabs/integron/helpers"
 	"github.com/sirupsen/logrus"
 )
 
+// ERROR_STEP_TIMEOUT bounds the steps handling a failure once the operation timeout has expired.
+const ERROR_STEP_TIMEOUT = 5 * time.Second
+
 func getStatusCode(statusCodeInterface interface{}) int {
 	if status, ok := statusCodeInterface.(int); ok {
 		// conver status to int
@@ -27,9 +38,36 @@ func getStatusCode(statusCodeInterface interface{}) int {
 		// conver status to int
 		return int(status)
 	}
+	if status, ok := statusCodeInterface.(int64); ok {
+		// integer parameters are decoded as int64
+		return int(status)
+	}
 	return 200
 }
 
+// errorStatus maps a failed step to the response status and error code.
+func errorStatus(err error) (int, string) {
+	if helpers.ErrorKind(err) == helpers.ERROR_KIND_TIMEOUT {
+		return http.StatusGatewayTimeout, "TIMEOUT"
+	}
+	return http.StatusInternalServerError, "EXCEPTION"
+}
+
+// requestErrorStatus maps a failed request validation to the response status and error code.
+func requestErrorStatus(err error) (int, string) {
+	var securityErr *openapi3filter.SecurityRequirementsError
+	if !errors.As(err, &securityErr) {
+		return http.StatusBadRequest, "BAD_REQUEST"
+	}
+	// forbidden only when every alternative had valid credentials without the required scopes
+	for _, requirementErr := range securityErr.Errors {
+		if !errors.Is(requirementErr, auth.ErrForbidden) {
+			return http.StatusUnauthorized, "UNAUTHORIZED"
+		}
+	}
+	return http.StatusForbidden, "FORBIDDEN"
+}
+
 func Error(r *http.Request, w http.ResponseWriter, message string, status int, errorCode string) {
 	ctx := r.Context()
 	h := w.Header()
@@ -39,16 +77,16 @@ func Error(r *http.Request, w http.ResponseWriter, message string, status int, e
 	h.Set("X-Content-Type-Options", "nosniff")
 
 	responseHeaders := http.Header{
-		"Content-Type":                 []string{"application/json"},
-		"Access-Control-Allow-Origin":  []string{"*"},
-		"Access-Control-Allow-Methods": []string{"GET, POST, PUT, DELETE"},
-		"Access-Control-Allow-Headers": []string{"Content-Type"},
+		"Content-Type": []string{"application/json"},
 	}
 
 	helpers.FillResponseHeaders(responseHeaders, w)
 
 	body := map[string]interface{}{
-		"message": message,
+		"message": helpers.Redact(message),
+	}
+	if requestID := helpers.RequestIDFromContext(ctx); requestID != "" {
+		body["requestId"] = requestID
 	}
 
 	jsonBody, _ := json.Marshal(body)
@@ -58,109 +96,233 @@ func Error(r *http.Request, w http.ResponseWriter, message string, status int, e
 
 	w.Write(responseBody)
 
-	logrus.WithContext(ctx).WithFields(logrus.Fields{
+	helpers.Log(ctx).WithFields(logrus.Fields{
 		"errorCode":  errorCode,
 		"statusCode": status,
 	}).Errorf("Error: %s", message)
 }
 
-func (s *Server) Handler(w http.ResponseWriter, r *http.Request) {
-	ctx := r.Context()
+// extractInput builds $.request in the request format of the flow, falling back to the server default.
+// Declared path and query parameters are decoded into the types of their schemas.
+func (s *Server) extractInput(r *http.Request, route *routers.Route, flow *Flow, pathParams map[string]string) map[string]interface{} {
+	requestFormat := flow.RequestFormat
+	if requestFormat == "" {
+		requestFormat = s.RequestFormat
+	}
+
+	parameters := append(openapi3.Parameters{}, route.PathItem.Parameters...)
+	parameters = append(parameters, route.Operation.Parameters...)
+	query := r.URL.Query()
+	decoded := make(map[*openapi3.Parameter]interface{})
+	for _, parameterRef := range parameters {
+		if parameterRef.Value == nil {
+			continue
+		}
+		if value, found := helpers.DecodeParameter(parameterRef.Value, pathParams, query); found {
+			decoded[parameterRef.Value] = value
+		}
+	}
 
-	logrus.SetFormatter(s.LogFormatter)
+	if requestFormat != helpers.REQUEST_FORMAT_STRUCTURED {
+		input := helpers.ExtractParams(pathParams, query)
+		for parameter, value := range decoded {
+			input[parameter.Name] = value
+		}
+		_ = json.NewDecoder(r.Body).Decode(&input)
+		return input
+	}
+
+	input := helpers.ExtractRequest(r, pathParams)
+	for parameter, value := range decoded {
+		if values, ok := input[parameter.In].(map[string]interface{}); ok {
+			values[parameter.Name] = value
+		}
+	}
+	return input
+}
+
+func (s *Server) Handler(w http.ResponseWriter, r *http.Request) {
+	// Correlate the request across logs, responses and upstream calls
+	requestID := r.Header.Get(helpers.REQUEST_ID_HEADER)
+	if !helpers.ValidRequestID(requestID) {
+		requestID = helpers.NewRequestID()
+	}
+	ctx := helpers.WithRequestID(r.Context(), requestID)
+	w.Header().Set(helpers.REQUEST_ID_HEADER, requestID)
+	logger := s.logger()
+	ctx = helpers.WithLogger(ctx, logger)
+	r = r.WithContext(ctx)
 
 	// Find route
 	route, pathParams, err := s.Router.FindRoute(r)
 	if err != nil {
-		Error(r, w, "Method not found", http.StatusNotFound, "METHOD_NOT_FOUND")
+		if isPreflight(r) {
+			s.Preflight(w, r)
+			return
+		}
+		s.CORS.Apply(w.Header(), r)
+		s.Error(r, w, errors.New("Method not found"), http.StatusNotFound, "METHOD_NOT_FOUND")
 		return
 	}
+	flow := s.Flows[route.Operation]
+	if flow != nil && flow.LogLevel != nil {
+		ctx = helpers.WithLogger(ctx, s.levelLogger(logger, *flow.LogLevel))
+		r = r.WithContext(ctx)
+	}
+	operation := operationName(route.Operation.OperationID, route.Method, route.Path)
+	if execution := s.startExecution(r, operation, route.Method, route.Path); execution != nil {
+		ctx = withExecution(ctx, execution)
+		r = r.WithContext(ctx)
+		w.Header().Set(EXECUTION_HEADER, execution.ID)
+		w.Header().Add("Link", fmt.Sprintf(`<%s%s>; rel="execution"`, EXECUTIONS_PATH, execution.ID))
+		defer execution.finish()
+	}
+	s.cors(flow).Apply(w.Header(), r)
+	if info := requestInfoFrom(ctx); info != nil {
+		info.Operation = operation
+		info.Method, info.Path = route.Method, route.Path
+	}
 
-	// Validate request
+	// Validate request, collecting the verified claims in the request context
+	ctx = auth.NewContext(ctx)
+	r = r.WithContext(ctx)
 	requestValidationInput := &openapi3filter.RequestValidationInput{
 		Request:    r,
 		PathParams: pathParams,
 		Route:      route,
 	}
+	requestValidationInput.Options = &openapi3filter.Options{
+		// report every offending parameter instead of stopping at the first
+		MultiError: s.ProblemDetails,
+	}
+	if s.Auth != nil {
+		requestValidationInput.Options.AuthenticationFunc = s.Auth.Authenticate
+	}
 
 	err = openapi3filter.ValidateRequest(ctx, requestValidationInput)
 
 	if err != nil {
-		Error(r, w, err.Error(), http.StatusBadRequest, "BAD_REQUEST")
+		status, errorCode := requestErrorStatus(err)
+		s.Error(r, w, err, status, errorCode)
 		return
 	}
 
-	var output interface{}
-	var stepInput interface{}
-	stepOutputs := make(map[string]interface{})
-	input := helpers.ExtractParams(pathParams, r.URL.Query())
+	if flow == nil {
+		// documentation-only operations have no flow
+		s.Error(r, w, errors.New("Operation not implemented"), http.StatusNotImplemented, "NOT_IMPLEMENTED")
+		return
+	}
 
-	_ = json.NewDecoder(r.Body).Decode(&input)
+	if offered := operationMediaTypes(route.Operation); len(offered) > 0 {
+		if _, ok := negotiate(r.Header.Get("Accept"), offered); !ok {
+			s.Error(r, w, errors.New("No acceptable media type"), http.StatusNotAcceptable, "NOT_ACCEPTABLE")
+			return
+		}
+	}
 
+	var output interface{}
+	stepOutputs := make(map[string]interface{})
+	input := s.extractInput(r, route, flow, pathParams)
+	if claims := auth.FromContext(ctx); claims != nil {
+		input["auth"] = claims
+	}
 	stepOutputs["request"] = input
 
-	stepsArray, ok := route.PathItem.GetOperation(route.Method).Extensions["x-integron-steps"].([]interface{})
-
-	if !ok {
-		Error(r, w, "Invalid x-integron-steps", http.StatusInternalServerError, "EXCEPTION")
-		return
+	if flow.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, flow.Timeout)
+		defer cancel()
+		r = r.WithContext(ctx)
 	}
 
-	currentStepKey := stepsArray[0].(map[string]interface{})["name"].(string)
-	steps, err := helpers.CreateStepsMap(stepsArray)
-	if err != nil {
-		Error(r, w, err.Error(), http.StatusInternalServerError, "EXCEPTION")
-		return
-	}
-
-	stepInput = input
+	processStep := s.stepProcessor()
+	r = WithBranchProcessor(r, flow, processStep)
+	currentStepKey := flow.Start
 	for {
 		var next string
-		stepOutputs[currentStepKey], next = s.ProcessStep(r, currentStepKey, w, steps, stepOutputs, stepInput)
+		stepOutputs[currentStepKey], next = processStep(r, currentStepKey, flow, stepOutputs)
 
 		if next == "" {
 			output = stepOutputs[currentStepKey]
 			break
 		} else if next == "end" {
 			return
+		} else if _, exists := flow.Steps[next]; next == "error" && (!exists || currentStepKey == "error") {
+			if err, ok := stepOutputs[currentStepKey].(error); ok {
+				status, errorCode := errorStatus(err)
+				s.Error(r, w, err, status, errorCode)
+			} else {
+				s.Error(r, w, fmt.Errorf("%v", stepOutputs[currentStepKey]), http.StatusInternalServerError, "EXCEPTION")
+			}
+			return
+		}
+		if _, failed := stepOutputs[currentStepKey].(error); failed && r.Context().Err() != nil {
+			// the operation timed out, yet the steps handling the failure still get to respond
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(context.WithoutCancel(r.Context()), ERROR_STEP_TIMEOUT)
+			defer cancel()
+			r = r.WithContext(ctx)
 		}
-		stepInput = stepOutputs[currentStepKey]
 		currentStepKey = next
 	}
 
 	outputMap, ok := output.(map[string]interface{})
 	if !ok {
-		Error(r, w, "Invalid output format", http.StatusInternalServerError, "EXCEPTION")
+		s.Error(r, w, errors.New("Invalid output format"), http.StatusInternalServerError, "EXCEPTION")
 		return
 	}
 	responseCode := getStatusCode(outputMap["status"])
-	jsonBody, _ := json.Marshal(outputMap["body"])
-	responseBody := []byte(jsonBody)
 
-	responseHeaders := http.Header{
-		"Content-Type":                 []string{"application/json"},
-		"Access-Control-Allow-Origin":  []string{"*"},
-		"Access-Control-Allow-Methods": []string{"GET, POST, PUT, DELETE"},
-		"Access-Control-Allow-Headers": []string{"Content-Type"},
-	}
+	responseHeaders := http.Header{}
 	if headers, ok := outputMap["headers"].(map[string]interface{}); ok {
 		for key, value := range headers {
 			responseHeaders.Set(key, value.(string))
 		}
 	}
 
+	// Negotiate the media type unless the flow sets one
+	content := responseContent(route.Operation, responseCode)
+	mediaType, _, _ := mime.ParseMediaType(responseHeaders.Get("Content-Type"))
+	if mediaType == "" {
+		var ok bool
+		offered := mediaTypes(content)
+		if mediaType, ok = negotiate(r.Header.Get("Accept"), offered); !ok {
+			mediaType = DEFAULT_MEDIA_TYPE
+			if len(offered) > 0 {
+				mediaType = offered[0]
+			}
+		}
+		responseHeaders.Set("Content-Type", mediaType)
+	}
+
+	var schema *openapi3.SchemaRef
+	if mediaTypeValue := content.Get(mediaType); mediaTypeValue != nil {
+		schema = mediaTypeValue.Schema
+	}
+	responseBody, err := encodeBody(mediaType, outputMap["body"], schema)
+	if err != nil {
+		s.Error(r, w, err, http.StatusInternalServerError, "EXCEPTION")
+		return
+	}
+
 	// Validate response
 	responseValidationInput := &openapi3filter.ResponseValidationInput{
 		RequestValidationInput: requestValidationInput,
 		Status:                 responseCode,
 		Header:                 responseHeaders,
-		// Body:                   io.NopCloser(strings.NewReader(string(responseBody))),
 	}
-	responseValidationInput.SetBodyBytes(responseBody)
-	err = openapi3filter.ValidateResponse(ctx, responseValidationInput)
+	if isXML(mediaType) || mediaType == "text/csv" {
+		// the validator cannot decode these into structured values, so check the body before serialization
+		responseValidationInput.Options = &openapi3filter.Options{ExcludeResponseBody: true}
+		err = validateBodyValue(content, mediaType, outputMap["body"])
+	}
+	if err == nil {
+		responseValidationInput.SetBodyBytes(responseBody)
+		err = openapi3filter.ValidateResponse(ctx, responseValidationInput)
+	}
 
 	if err != nil {
-		Error(r, w, err.Error(), http.StatusInternalServerError, "EXCEPTION")
+		s.Error(r, w, err, http.StatusInternalServerError, "EXCEPTION")
 		return
 	}
 
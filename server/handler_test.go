@@ -0,0 +1,520 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/integronlabs/integron/auth"
+	"github.com/integronlabs/integron/errorstep"
+	"github.com/integronlabs/integron/helpers"
+	"github.com/integronlabs/integron/object"
+	"github.com/sirupsen/logrus"
+)
+
+func init() {
+	RegisterStep("test-branch", func(ctx context.Context, stepMap map[string]interface{}, stepOutputs map[string]interface{}) (interface{}, string, error) {
+		step, err := NewStep(map[string]interface{}{"name": "nested", "type": "test-echo"})
+		if err != nil {
+			return err.Error(), "error", err
+		}
+		output, _ := ProcessBranchStep(ctx, map[string]*Step{"nested": step}, "nested", stepOutputs)
+		return output, "", nil
+	})
+	RegisterStep("test-error", errorstep.Run)
+	RegisterStep("test-object", object.Run)
+	RegisterStep("test-claims", func(ctx context.Context, stepMap map[string]interface{}, stepOutputs map[string]interface{}) (interface{}, string, error) {
+		return map[string]interface{}{"body": auth.FromContext(ctx)}, "", nil
+	})
+	RegisterStep("test-echo", func(ctx context.Context, stepMap map[string]interface{}, stepOutputs map[string]interface{}) (interface{}, string, error) {
+		return map[string]interface{}{"body": stepOutputs["request"]}, "", nil
+	})
+}
+
+const echoSpec = `
+openapi: 3.0.3
+info:
+  title: test
+  version: 1.0.0
+paths:
+  /echo/{id}:
+    post:
+      parameters:
+        - name: id
+          in: path
+          required: true
+          schema:
+            type: string
+        - name: tag
+          in: query
+          schema:
+            type: string
+        - name: amount
+          in: query
+          schema:
+            type: integer
+      responses:
+        '200':
+          description: ok
+          content:
+            application/json:
+              schema:
+                type: object
+      x-integron-steps:
+        - name: echo
+          type: test-echo
+`
+
+func serveEcho(t *testing.T, s *Server) map[string]interface{} {
+	request := httptest.NewRequest(http.MethodPost, "/echo/1?tag=a&amount=5&extra=x&extra=y", strings.NewReader(`{"name": "world"}`))
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("X-Tenant-Id", "acme")
+	recorder := httptest.NewRecorder()
+
+	s.Handler(recorder, request)
+
+	if recorder.Code != http.StatusOK {
+		t.Fatalf(EXPECTED_BUT_GOT, http.StatusOK, recorder.Body.String())
+	}
+	var body map[string]interface{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+		t.Fatalf(EXPECTED_NIL_GOT, err)
+	}
+	return body
+}
+
+func TestHandlerStructuredRequest(t *testing.T) {
+	s := newTestServer(t, echoSpec)
+
+	request := serveEcho(t, s)
+
+	if request["method"] != "POST" {
+		t.Errorf(EXPECTED_BUT_GOT, "POST", request["method"])
+	}
+	if request["path"].(map[string]interface{})["id"] != "1" {
+		t.Errorf(EXPECTED_BUT_GOT, "1", request["path"])
+	}
+	if request["headers"].(map[string]interface{})["x-tenant-id"] != "acme" {
+		t.Errorf(EXPECTED_BUT_GOT, "acme", request["headers"])
+	}
+	if request["body"].(map[string]interface{})["name"] != "world" {
+		t.Errorf(EXPECTED_BUT_GOT, "world", request["body"])
+	}
+	query := request["query"].(map[string]interface{})
+	if query["tag"] != "a" || query["amount"] != float64(5) {
+		t.Errorf(EXPECTED_BUT_GOT, "typed tag and amount", query)
+	}
+	if extra, _ := query["extra"].([]interface{}); len(extra) != 2 {
+		t.Errorf(EXPECTED_BUT_GOT, "undeclared extra as a list", query["extra"])
+	}
+}
+
+func TestHandlerLegacyRequest(t *testing.T) {
+	for _, requestFormat := range []string{helpers.REQUEST_FORMAT_LEGACY, ""} {
+		s := newTestServer(t, echoSpec)
+		s.RequestFormat = requestFormat
+
+		request := serveEcho(t, s)
+
+		if request["id"] != "1" || request["tag"] != "a" || request["amount"] != float64(5) || request["name"] != "world" {
+			t.Errorf(EXPECTED_BUT_GOT, "flat id, tag and name", request)
+		}
+	}
+}
+
+func TestHandlerOperationRequestFormat(t *testing.T) {
+	s := newTestServer(t, strings.Replace(echoSpec, "      x-integron-steps:", "      x-integron-request-format: legacy\n      x-integron-steps:", 1))
+
+	request := serveEcho(t, s)
+
+	if request["id"] != "1" {
+		t.Errorf(EXPECTED_BUT_GOT, "1", request)
+	}
+}
+
+const securedSpec = `
+openapi: 3.0.3
+info:
+  title: test
+  version: 1.0.0
+security:
+  - apiKey: []
+paths:
+  /echo/{id}:
+    post:
+      parameters:
+        - name: id
+          in: path
+          required: true
+          schema:
+            type: string
+      responses:
+        '200':
+          description: ok
+      x-integron-steps:
+        - name: echo
+          type: test-echo
+components:
+  securitySchemes:
+    apiKey:
+      type: apiKey
+      in: header
+      name: X-API-Key
+`
+
+func TestHandlerAuthentication(t *testing.T) {
+	s := newTestServer(t, securedSpec)
+	s.Auth = &auth.Authenticator{APIKeys: auth.APIKeys{"key-a": {"sub": "partner-a"}}}
+
+	tests := []struct {
+		key      string
+		expected int
+	}{
+		{"", http.StatusUnauthorized},
+		{"key-b", http.StatusUnauthorized},
+		{"key-a", http.StatusOK},
+	}
+	for _, test := range tests {
+		request := httptest.NewRequest(http.MethodPost, "/echo/1", nil)
+		request.Header.Set("X-API-Key", test.key)
+		recorder := httptest.NewRecorder()
+
+		s.Handler(recorder, request)
+
+		if recorder.Code != test.expected {
+			t.Fatalf(EXPECTED_BUT_GOT, test.expected, recorder.Code)
+		}
+		if test.expected == http.StatusOK && !strings.Contains(recorder.Body.String(), `"auth":{"apiKey":{"sub":"partner-a"}}`) {
+			t.Errorf(EXPECTED_BUT_GOT, "claims under auth", recorder.Body.String())
+		}
+	}
+}
+
+func TestHandlerAuthenticationFailedRequirement(t *testing.T) {
+	spec := strings.Replace(securedSpec, "  - apiKey: []\n", "  - apiKey: []\n    secondKey: []\n  - partnerKey: []\n", 1)
+	spec = strings.Replace(spec, "      name: X-API-Key\n", `      name: X-API-Key
+    secondKey:
+      type: apiKey
+      in: header
+      name: X-Second-Key
+    partnerKey:
+      type: apiKey
+      in: header
+      name: X-Partner-Key
+`, 1)
+	s := newTestServer(t, spec)
+	s.Auth = &auth.Authenticator{APIKeys: auth.APIKeys{"key-a": {"sub": "partner-a"}, "key-p": {"sub": "partner-p"}}}
+	request := httptest.NewRequest(http.MethodPost, "/echo/1", nil)
+	request.Header.Set("X-API-Key", "key-a")
+	request.Header.Set("X-Partner-Key", "key-p")
+	recorder := httptest.NewRecorder()
+
+	s.Handler(recorder, request)
+
+	if recorder.Code != http.StatusOK {
+		t.Fatalf(EXPECTED_BUT_GOT, http.StatusOK, recorder.Code)
+	}
+	if !strings.Contains(recorder.Body.String(), `"auth":{"partnerKey":{"sub":"partner-p"}}`) {
+		t.Errorf(EXPECTED_BUT_GOT, "only the claims of partnerKey", recorder.Body.String())
+	}
+}
+
+func TestHandlerClaimsInStepContext(t *testing.T) {
+	s := newTestServer(t, strings.Replace(securedSpec, "type: test-echo", "type: test-claims", 1))
+	s.Auth = &auth.Authenticator{APIKeys: auth.APIKeys{"key-a": {"sub": "partner-a"}}}
+	request := httptest.NewRequest(http.MethodPost, "/echo/1", nil)
+	request.Header.Set("X-API-Key", "key-a")
+	recorder := httptest.NewRecorder()
+
+	s.Handler(recorder, request)
+
+	if recorder.Code != http.StatusOK || !strings.Contains(recorder.Body.String(), `{"apiKey":{"sub":"partner-a"}}`) {
+		t.Errorf(EXPECTED_BUT_GOT, "the claims in the step context", recorder.Body.String())
+	}
+}
+
+func TestStatusRecorder(t *testing.T) {
+	tests := []struct {
+		write    func(w http.ResponseWriter)
+		expected int
+	}{
+		{func(w http.ResponseWriter) {}, http.StatusOK},
+		{func(w http.ResponseWriter) { w.Write([]byte("{}")) }, http.StatusOK},
+		{func(w http.ResponseWriter) { w.WriteHeader(http.StatusNotFound); w.WriteHeader(http.StatusOK) }, http.StatusNotFound},
+	}
+	for _, test := range tests {
+		recorder := &StatusRecorder{ResponseWriter: httptest.NewRecorder()}
+
+		test.write(recorder)
+
+		if recorder.Status() != test.expected {
+			t.Errorf(EXPECTED_BUT_GOT, test.expected, recorder.Status())
+		}
+	}
+}
+
+func TestHandlerStepMiddleware(t *testing.T) {
+	s := newTestServer(t, echoSpec)
+	var calls []string
+	record := func(name string) StepMiddleware {
+		return func(next StepProcessor) StepProcessor {
+			return func(r *http.Request, currentStepKey string, flow *Flow, stepOutputs map[string]interface{}) (interface{}, string) {
+				calls = append(calls, name+" "+currentStepKey)
+				return next(r, currentStepKey, flow, stepOutputs)
+			}
+		}
+	}
+	s.StepMiddleware = []StepMiddleware{record("outer"), record("inner")}
+	request := httptest.NewRequest(http.MethodPost, "/echo/1", strings.NewReader(`{}`))
+	request.Header.Set("Content-Type", "application/json")
+	ctx, info := WithRequestInfo(request.Context())
+
+	s.Handler(httptest.NewRecorder(), request.WithContext(ctx))
+
+	if strings.Join(calls, ", ") != "outer echo, inner echo" {
+		t.Errorf(EXPECTED_BUT_GOT, "outer echo, inner echo", calls)
+	}
+	if info.Operation != "POST /echo/{id}" || info.Path != "/echo/{id}" {
+		t.Errorf(EXPECTED_BUT_GOT, "POST /echo/{id}", info)
+	}
+}
+
+func TestHandlerBranchStepMiddleware(t *testing.T) {
+	s := newTestServer(t, strings.Replace(echoSpec, "type: test-echo", "type: test-branch", 1))
+	var calls []string
+	s.StepMiddleware = []StepMiddleware{func(next StepProcessor) StepProcessor {
+		return func(r *http.Request, currentStepKey string, flow *Flow, stepOutputs map[string]interface{}) (interface{}, string) {
+			calls = append(calls, flow.Operation()+" "+currentStepKey)
+			return next(r, currentStepKey, flow, stepOutputs)
+		}
+	}}
+	request := httptest.NewRequest(http.MethodPost, "/echo/1", strings.NewReader(`{}`))
+	request.Header.Set("Content-Type", "application/json")
+
+	s.Handler(httptest.NewRecorder(), request)
+
+	if strings.Join(calls, ", ") != "POST /echo/{id} echo, POST /echo/{id} nested" {
+		t.Errorf(EXPECTED_BUT_GOT, "echo and its nested step", calls)
+	}
+}
+
+func TestHandlerRequestID(t *testing.T) {
+	s := newTestServer(t, echoSpec)
+	tests := []struct {
+		sent     string
+		accepted bool
+	}{
+		{"req-1", true},
+		{"", false},
+		{"bad id\nforged", false},
+	}
+	for _, test := range tests {
+		request := httptest.NewRequest(http.MethodPost, "/echo/1", strings.NewReader(`{}`))
+		request.Header.Set("Content-Type", "application/json")
+		if test.sent != "" {
+			request.Header.Set(helpers.REQUEST_ID_HEADER, test.sent)
+		}
+		recorder := httptest.NewRecorder()
+
+		s.Handler(recorder, request)
+
+		echoed := recorder.Header().Get(helpers.REQUEST_ID_HEADER)
+		if test.accepted && echoed != test.sent {
+			t.Errorf(EXPECTED_BUT_GOT, test.sent, echoed)
+		}
+		if !test.accepted && (echoed == test.sent || !helpers.ValidRequestID(echoed)) {
+			t.Errorf(EXPECTED_BUT_GOT, "a generated request id", echoed)
+		}
+	}
+}
+
+func TestHandlerLogLevel(t *testing.T) {
+	s := newTestServer(t, strings.Replace(echoSpec, "      x-integron-steps:", "      x-integron-log-level: debug\n      x-integron-steps:", 1))
+	s.Logger = logrus.New()
+	var loggers []*logrus.Logger
+	s.StepMiddleware = []StepMiddleware{func(next StepProcessor) StepProcessor {
+		return func(r *http.Request, currentStepKey string, flow *Flow, stepOutputs map[string]interface{}) (interface{}, string) {
+			loggers = append(loggers, helpers.Log(r.Context()).Logger)
+			return next(r, currentStepKey, flow, stepOutputs)
+		}
+	}}
+
+	for i := 0; i < 2; i++ {
+		request := httptest.NewRequest(http.MethodPost, "/echo/1", strings.NewReader(`{}`))
+		request.Header.Set("Content-Type", "application/json")
+		s.Handler(httptest.NewRecorder(), request)
+	}
+
+	if len(loggers) != 2 || loggers[0].GetLevel() != logrus.DebugLevel {
+		t.Fatalf(EXPECTED_BUT_GOT, "a debug logger for every request", loggers)
+	}
+	if loggers[0] != loggers[1] {
+		t.Errorf(EXPECTED_BUT_GOT, "a logger shared between requests", loggers)
+	}
+	if s.Logger.GetLevel() != logrus.InfoLevel {
+		t.Errorf(EXPECTED_BUT_GOT, logrus.InfoLevel, s.Logger.GetLevel())
+	}
+}
+
+func TestHandlerNotImplemented(t *testing.T) {
+	s := newTestServer(t, echoSpec+`
+  /documented:
+    get:
+      responses:
+        '200':
+          description: ok
+`)
+	recorder := httptest.NewRecorder()
+
+	s.Handler(recorder, httptest.NewRequest(http.MethodGet, "/documented", nil))
+
+	if recorder.Code != http.StatusNotImplemented {
+		t.Errorf(EXPECTED_BUT_GOT, http.StatusNotImplemented, recorder.Code)
+	}
+}
+
+func TestHandlerIntegerPathInURL(t *testing.T) {
+	var paths []string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{}`))
+	}))
+	defer upstream.Close()
+	s := newTestServer(t, fmt.Sprintf(`
+openapi: 3.0.3
+info:
+  title: test
+  version: 1.0.0
+paths:
+  /items/{id}:
+    get:
+      parameters:
+        - name: id
+          in: path
+          required: true
+          schema:
+            type: integer
+            format: int64
+      responses:
+        '200':
+          description: ok
+      x-integron-steps:
+        - name: upstream
+          type: test-http
+          method: GET
+          url: '%s/items/$.request.path.id'
+          responses:
+            '200':
+              output: {}
+              next: ''
+`, upstream.URL))
+
+	for _, id := range []string{"1000000", "9007199254740993"} {
+		recorder := httptest.NewRecorder()
+
+		s.Handler(recorder, httptest.NewRequest(http.MethodGet, "/items/"+id, nil))
+
+		if recorder.Code != http.StatusOK {
+			t.Fatalf(EXPECTED_BUT_GOT, http.StatusOK, recorder.Body.String())
+		}
+		if paths[len(paths)-1] != "/items/"+id {
+			t.Errorf(EXPECTED_BUT_GOT, "/items/"+id, paths[len(paths)-1])
+		}
+	}
+}
+
+func TestHandlerTimeoutErrorSteps(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer upstream.Close()
+	spec := fmt.Sprintf(`
+openapi: 3.0.3
+info:
+  title: test
+  version: 1.0.0
+paths:
+  /slow:
+    get:
+      responses:
+        '503':
+          description: unavailable
+      x-integron-timeout: 50ms
+      x-integron-steps:
+        - name: upstream
+          type: test-http
+          method: GET
+          url: '%s'
+          onError: unavailable
+          responses:
+            '200':
+              output: {}
+              next: ''
+        - name: unavailable
+          type: test-error
+          status: 503
+          output:
+            message: try later
+`, upstream.URL)
+
+	for _, spec := range []string{spec, strings.NewReplacer("          onError: unavailable\n", "", "name: unavailable", "name: error").Replace(spec)} {
+		s := newTestServer(t, spec)
+		recorder := httptest.NewRecorder()
+
+		s.Handler(recorder, httptest.NewRequest(http.MethodGet, "/slow", nil))
+
+		if recorder.Code != http.StatusServiceUnavailable {
+			t.Fatalf(EXPECTED_BUT_GOT, http.StatusServiceUnavailable, recorder.Body.String())
+		}
+		if recorder.Body.String() != `{"message":"try later"}` {
+			t.Errorf(EXPECTED_BUT_GOT, `{"message":"try later"}`, recorder.Body.String())
+		}
+	}
+}
+
+func TestHandlerStatusFromIntegerPath(t *testing.T) {
+	spec := `
+openapi: 3.0.3
+info:
+  title: test
+  version: 1.0.0
+paths:
+  /status/{code}:
+    get:
+      parameters:
+        - name: code
+          in: path
+          required: true
+          schema:
+            type: integer
+      responses:
+        default:
+          description: any
+      x-integron-steps:
+        - name: respond
+`
+	steps := []string{`
+          type: test-object
+          output:
+            status: $.request.path.code
+          next: ''
+`, `
+          type: test-error
+          status: $.request.path.code
+`}
+	for _, step := range steps {
+		s := newTestServer(t, spec+strings.TrimPrefix(step, "\n"))
+		recorder := httptest.NewRecorder()
+
+		s.Handler(recorder, httptest.NewRequest(http.MethodGet, "/status/202", nil))
+
+		if recorder.Code != http.StatusAccepted {
+			t.Errorf(EXPECTED_BUT_GOT, http.StatusAccepted, recorder.Code)
+		}
+	}
+}
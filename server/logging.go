@@ -0,0 +1,23 @@
+package server
+
+import (
+	"github.com/integronlabs/integron/helpers"
+	"github.com/sirupsen/logrus"
+)
+
+// logger returns the logger of the server, the standard logger when none is configured.
+func (s *Server) logger() *logrus.Logger {
+	if s.Logger == nil {
+		return logrus.StandardLogger()
+	}
+	return s.Logger
+}
+
+// levelLogger returns the logger of operations overriding the log level, shared between requests.
+func (s *Server) levelLogger(logger *logrus.Logger, level logrus.Level) *logrus.Logger {
+	if cached, ok := s.levelLoggers.Load(level); ok {
+		return cached.(*logrus.Logger)
+	}
+	cached, _ := s.levelLoggers.LoadOrStore(level, helpers.LoggerWithLevel(logger, level))
+	return cached.(*logrus.Logger)
+}
@@ -0,0 +1,132 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+// StatusRecorder remembers the status Handler writes to a response, for middleware wrapping it.
+type StatusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *StatusRecorder) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *StatusRecorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *StatusRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Status returns the status written to the response, 200 when nothing was written.
+func (w *StatusRecorder) Status() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
+
+// RoundTripperFunc adapts a function to http.RoundTripper, e.g. to instrument upstream calls.
+type RoundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f RoundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+// StepProcessor runs a single step of a flow like ProcessStep does.
+type StepProcessor func(r *http.Request, currentStepKey string, flow *Flow, stepOutputs map[string]interface{}) (interface{}, string)
+
+// StepMiddleware wraps the processing of every step, e.g. to instrument it.
+type StepMiddleware func(next StepProcessor) StepProcessor
+
+// RequestInfo describes the operation that served a request, for middleware wrapping Handler.
+type RequestInfo struct {
+	// Operation is the operationId, or the method and path when the operation has none.
+	Operation string
+	Method    string
+	Path      string
+}
+
+type requestInfoKey struct{}
+
+// WithRequestInfo prepares a context in which Handler records the operation serving the request.
+// Nested middleware share the RequestInfo of the outermost one.
+func WithRequestInfo(ctx context.Context) (context.Context, *RequestInfo) {
+	if info := requestInfoFrom(ctx); info != nil {
+		return ctx, info
+	}
+	info := &RequestInfo{}
+	return context.WithValue(ctx, requestInfoKey{}, info), info
+}
+
+func requestInfoFrom(ctx context.Context) *RequestInfo {
+	info, _ := ctx.Value(requestInfoKey{}).(*RequestInfo)
+	return info
+}
+
+// operationName names an operation by its operationId, or by its method and path.
+func operationName(operationID string, method string, path string) string {
+	if operationID != "" {
+		return operationID
+	}
+	return method + " " + path
+}
+
+// Operation names the operation of the flow.
+func (f *Flow) Operation() string {
+	return operationName(f.OperationID, f.Method, f.Path)
+}
+
+type branchProcessorKey struct{}
+
+// branchProcessor runs the steps of branches within the request being served.
+type branchProcessor struct {
+	r       *http.Request
+	flow    *Flow
+	process StepProcessor
+}
+
+// WithBranchProcessor returns r with a context in which the steps of branches of flow, such as
+// those of a parallel step, are run by process. Handler installs ProcessStep wrapped in the step
+// middleware of the server.
+func WithBranchProcessor(r *http.Request, flow *Flow, process StepProcessor) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), branchProcessorKey{}, branchProcessor{r: r, flow: flow, process: process}))
+}
+
+// ProcessBranchStep runs a step of a branch, such as one of a parallel step, the way Handler runs
+// the steps of the flow: through the step middleware of the server serving ctx. It fails when ctx
+// has no branch processor.
+func ProcessBranchStep(ctx context.Context, steps map[string]*Step, currentStepKey string, stepOutputs map[string]interface{}) (interface{}, string) {
+	processor, ok := ctx.Value(branchProcessorKey{}).(branchProcessor)
+	if !ok {
+		return errors.New("no branch processor to run step " + currentStepKey), "error"
+	}
+	branch := &Flow{
+		Method:      processor.flow.Method,
+		Path:        processor.flow.Path,
+		OperationID: processor.flow.OperationID,
+		Steps:       steps,
+	}
+	return processor.process(processor.r.WithContext(ctx), currentStepKey, branch, stepOutputs)
+}
+
+// stepProcessor returns ProcessStep wrapped in the step middleware, the first being the outermost.
+func (s *Server) stepProcessor() StepProcessor {
+	processor := s.ProcessStep
+	for i := len(s.StepMiddleware) - 1; i >= 0; i-- {
+		processor = s.StepMiddleware[i](processor)
+	}
+	return processor
+}
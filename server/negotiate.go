@@ -0,0 +1,186 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/integronlabs/integron/helpers"
+)
+
+const DEFAULT_MEDIA_TYPE = "application/json"
+
+// acceptRange is one entry of an Accept header.
+type acceptRange struct {
+	mediaType string
+	quality   float64
+}
+
+func parseAccept(accept string) []acceptRange {
+	var ranges []acceptRange
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		quality := 1.0
+		if q, ok := params["q"]; ok {
+			if parsed, err := strconv.ParseFloat(q, 64); err == nil {
+				quality = parsed
+			}
+		}
+		ranges = append(ranges, acceptRange{mediaType: mediaType, quality: quality})
+	}
+	return ranges
+}
+
+// quality returns the quality the most specific matching range of an Accept header gives a media type.
+func quality(ranges []acceptRange, mediaType string) float64 {
+	best, specificity := 0.0, -1
+	for _, r := range ranges {
+		var matched int
+		switch {
+		case r.mediaType == mediaType:
+			matched = 2
+		case strings.HasSuffix(r.mediaType, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(r.mediaType, "*")):
+			matched = 1
+		case r.mediaType == "*/*":
+			matched = 0
+		default:
+			continue
+		}
+		if matched > specificity {
+			best, specificity = r.quality, matched
+		}
+	}
+	return best
+}
+
+// negotiate picks the offered media type the Accept header prefers, the first one without an Accept header.
+func negotiate(accept string, offered []string) (string, bool) {
+	if len(offered) == 0 {
+		return "", false
+	}
+	if strings.TrimSpace(accept) == "" {
+		return offered[0], true
+	}
+	ranges := parseAccept(accept)
+	chosen, best := "", 0.0
+	for _, mediaType := range offered {
+		if q := quality(ranges, mediaType); q > best {
+			chosen, best = mediaType, q
+		}
+	}
+	return chosen, chosen != ""
+}
+
+// mediaTypes lists the concrete media types of a response content, JSON first.
+func mediaTypes(content openapi3.Content) []string {
+	types := make([]string, 0, len(content))
+	for mediaType := range content {
+		if !strings.Contains(mediaType, "*") {
+			types = append(types, mediaType)
+		}
+	}
+	sort.Slice(types, func(i, j int) bool {
+		if (types[i] == DEFAULT_MEDIA_TYPE) != (types[j] == DEFAULT_MEDIA_TYPE) {
+			return types[i] == DEFAULT_MEDIA_TYPE
+		}
+		return types[i] < types[j]
+	})
+	return types
+}
+
+// operationMediaTypes lists the media types of every response an operation declares.
+func operationMediaTypes(operation *openapi3.Operation) []string {
+	var content openapi3.Content = make(openapi3.Content)
+	if operation.Responses != nil {
+		for _, responseRef := range operation.Responses.Map() {
+			if responseRef.Value != nil {
+				for mediaType, value := range responseRef.Value.Content {
+					content[mediaType] = value
+				}
+			}
+		}
+	}
+	return mediaTypes(content)
+}
+
+// responseContent returns the content declared for a response status, falling back to default.
+func responseContent(operation *openapi3.Operation, status int) openapi3.Content {
+	if operation.Responses == nil {
+		return nil
+	}
+	responseRef := operation.Responses.Status(status)
+	if responseRef == nil {
+		responseRef = operation.Responses.Default()
+	}
+	if responseRef == nil || responseRef.Value == nil {
+		return nil
+	}
+	return responseRef.Value.Content
+}
+
+func isJSON(mediaType string) bool {
+	return mediaType == DEFAULT_MEDIA_TYPE || strings.HasSuffix(mediaType, "+json")
+}
+
+func isXML(mediaType string) bool {
+	return mediaType == "application/xml" || mediaType == "text/xml" || strings.HasSuffix(mediaType, "+xml")
+}
+
+// encodeBody serializes a flow's response body in the negotiated media type.
+func encodeBody(mediaType string, body interface{}, schema *openapi3.SchemaRef) ([]byte, error) {
+	switch {
+	case isJSON(mediaType):
+		return json.Marshal(body)
+	case isXML(mediaType):
+		root := ""
+		if schema != nil && schema.Value != nil && schema.Value.XML != nil {
+			root = schema.Value.XML.Name
+		}
+		return helpers.EncodeXML(root, body)
+	case mediaType == "text/csv":
+		return helpers.EncodeCSV(body)
+	}
+
+	switch value := body.(type) {
+	case nil:
+		return []byte{}, nil
+	case string:
+		return []byte(value), nil
+	case map[string]interface{}, []interface{}:
+		if strings.HasPrefix(mediaType, "text/") {
+			return nil, fmt.Errorf("cannot write an object or list as %s", mediaType)
+		}
+		return json.Marshal(body)
+	default:
+		return []byte(fmt.Sprintf("%v", value)), nil
+	}
+}
+
+// validateBodyValue checks a body against the schema of its media type before serialization, for
+// media types the response validator cannot decode into structured values.
+func validateBodyValue(content openapi3.Content, mediaType string, body interface{}) error {
+	mediaTypeValue := content.Get(mediaType)
+	if mediaTypeValue == nil || mediaTypeValue.Schema == nil || mediaTypeValue.Schema.Value == nil {
+		return nil
+	}
+	// normalize to the types a decoded JSON document has
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	if err := mediaTypeValue.Schema.Value.VisitJSON(value, openapi3.VisitAsResponse()); err != nil {
+		return fmt.Errorf("response body doesn't match schema: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func init() {
+	RegisterStep("test-dogs", func(ctx context.Context, stepMap map[string]interface{}, stepOutputs map[string]interface{}) (interface{}, string, error) {
+		body := []interface{}{
+			map[string]interface{}{"id": "1", "name": "Rex", "age": float64(3)},
+			map[string]interface{}{"id": "2", "name": "Fido", "age": float64(5)},
+		}
+		if stepMap["invalid"] == true {
+			body = append(body, map[string]interface{}{"id": "3"})
+		}
+		return map[string]interface{}{"body": body}, "", nil
+	})
+}
+
+const dogsSpec = `
+openapi: 3.0.3
+info:
+  title: test
+  version: 1.0.0
+paths:
+  /dogs:
+    get:
+      responses:
+        '200':
+          description: ok
+          content:
+            application/json:
+              schema:
+                $ref: '#/components/schemas/Dogs'
+            application/xml:
+              schema:
+                $ref: '#/components/schemas/Dogs'
+            text/csv:
+              schema:
+                $ref: '#/components/schemas/Dogs'
+      x-integron-steps:
+        - name: dogs
+          type: test-dogs
+components:
+  schemas:
+    Dogs:
+      type: array
+      xml:
+        name: dogs
+      items:
+        type: object
+        required: [id, name]
+        properties:
+          id:
+            type: string
+          name:
+            type: string
+          age:
+            type: integer
+`
+
+func serveDogs(s *Server, accept string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(http.MethodGet, "/dogs", nil)
+	if accept != "" {
+		request.Header.Set("Accept", accept)
+	}
+	recorder := httptest.NewRecorder()
+	s.Handler(recorder, request)
+	return recorder
+}
+
+func TestNegotiate(t *testing.T) {
+	offered := []string{"application/json", "application/xml", "text/csv"}
+	tests := []struct {
+		accept   string
+		expected string
+		ok       bool
+	}{
+		{"", "application/json", true},
+		{"*/*", "application/json", true},
+		{"text/csv", "text/csv", true},
+		{"application/xml;q=0.5, text/csv;q=0.8", "text/csv", true},
+		{"text/*", "text/csv", true},
+		{"*/*;q=0.1, application/json;q=0", "application/xml", true},
+		{"image/png", "", false},
+	}
+	for _, test := range tests {
+		mediaType, ok := negotiate(test.accept, offered)
+		if mediaType != test.expected || ok != test.ok {
+			t.Errorf(EXPECTED_BUT_GOT, test.expected, mediaType)
+		}
+	}
+}
+
+func TestHandlerNegotiatesCSV(t *testing.T) {
+	recorder := serveDogs(newTestServer(t, dogsSpec), "text/csv")
+
+	if recorder.Code != http.StatusOK {
+		t.Fatalf(EXPECTED_BUT_GOT, http.StatusOK, recorder.Body.String())
+	}
+	if contentType := recorder.Header().Get("Content-Type"); contentType != "text/csv" {
+		t.Errorf(EXPECTED_BUT_GOT, "text/csv", contentType)
+	}
+	expected := "age,id,name\n3,1,Rex\n5,2,Fido\n"
+	if recorder.Body.String() != expected {
+		t.Errorf(EXPECTED_BUT_GOT, expected, recorder.Body.String())
+	}
+}
+
+func TestHandlerNegotiatesXML(t *testing.T) {
+	recorder := serveDogs(newTestServer(t, dogsSpec), "application/xml")
+
+	if recorder.Code != http.StatusOK {
+		t.Fatalf(EXPECTED_BUT_GOT, http.StatusOK, recorder.Body.String())
+	}
+	expected := "<dogs><item><age>3</age><id>1</id><name>Rex</name></item><item><age>5</age><id>2</id><name>Fido</name></item></dogs>"
+	if recorder.Body.String() != expected {
+		t.Errorf(EXPECTED_BUT_GOT, expected, recorder.Body.String())
+	}
+}
+
+func TestHandlerDefaultsToJSON(t *testing.T) {
+	recorder := serveDogs(newTestServer(t, dogsSpec), "")
+
+	if contentType := recorder.Header().Get("Content-Type"); contentType != "application/json" {
+		t.Errorf(EXPECTED_BUT_GOT, "application/json", contentType)
+	}
+	if !strings.HasPrefix(recorder.Body.String(), "[{") {
+		t.Errorf(EXPECTED_BUT_GOT, "a JSON list", recorder.Body.String())
+	}
+}
+
+func TestHandlerNotAcceptable(t *testing.T) {
+	recorder := serveDogs(newTestServer(t, dogsSpec), "image/png")
+
+	if recorder.Code != http.StatusNotAcceptable {
+		t.Errorf(EXPECTED_BUT_GOT, http.StatusNotAcceptable, recorder.Code)
+	}
+}
+
+func TestHandlerValidatesCSVBody(t *testing.T) {
+	spec := strings.Replace(dogsSpec, "type: test-dogs", "type: test-dogs\n          invalid: true", 1)
+
+	recorder := serveDogs(newTestServer(t, spec), "text/csv")
+
+	if recorder.Code != http.StatusInternalServerError {
+		t.Errorf(EXPECTED_BUT_GOT, http.StatusInternalServerError, recorder.Code)
+	}
+}
+
+func TestEncodeBodyText(t *testing.T) {
+	body, err := encodeBody("text/plain", "Woof", nil)
+	if err != nil || string(body) != "Woof" {
+		t.Errorf(EXPECTED_BUT_GOT, "Woof", string(body))
+	}
+	if _, err := encodeBody("text/plain", map[string]interface{}{"a": "b"}, nil); err == nil {
+		t.Error(EXPECTED_ERROR_GOT_NIL)
+	}
+}
@@ -0,0 +1,124 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/integronlabs/integron/helpers"
+	"github.com/sirupsen/logrus"
+)
+
+const PROBLEM_CONTENT_TYPE = "application/problem+json"
+const PROBLEM_TYPE_PREFIX = "urn:integron:problem:"
+
+// problemType turns an error code such as BAD_REQUEST into urn:integron:problem:bad-request.
+func problemType(errorCode string) string {
+	return PROBLEM_TYPE_PREFIX + strings.ReplaceAll(strings.ToLower(errorCode), "_", "-")
+}
+
+// invalidParams lists every offending parameter or body location of a request validation error.
+func invalidParams(err error) []map[string]interface{} {
+	// only split top-level multi errors, request errors wrap their own
+	if multiError, ok := err.(openapi3.MultiError); ok {
+		var params []map[string]interface{}
+		for _, e := range multiError {
+			params = append(params, invalidParams(e)...)
+		}
+		return params
+	}
+
+	var requestError *openapi3filter.RequestError
+	if !errors.As(err, &requestError) {
+		return []map[string]interface{}{{"detail": err.Error()}}
+	}
+
+	if parameter := requestError.Parameter; parameter != nil {
+		detail := requestError.Reason
+		if requestError.Err != nil {
+			detail = requestError.Err.Error()
+		}
+		return []map[string]interface{}{{
+			"in":        parameter.In,
+			"parameter": parameter.Name,
+			"detail":    detail,
+		}}
+	}
+
+	if requestError.RequestBody != nil && requestError.Err != nil {
+		var params []map[string]interface{}
+		var schemaErrors openapi3.MultiError
+		if !errors.As(requestError.Err, &schemaErrors) {
+			schemaErrors = openapi3.MultiError{requestError.Err}
+		}
+		for _, e := range schemaErrors {
+			var schemaError *openapi3.SchemaError
+			if errors.As(e, &schemaError) {
+				params = append(params, map[string]interface{}{
+					"in":      "body",
+					"pointer": "#/" + strings.Join(schemaError.JSONPointer(), "/"),
+					"detail":  schemaError.Reason,
+				})
+			} else {
+				params = append(params, map[string]interface{}{"in": "body", "detail": e.Error()})
+			}
+		}
+		return params
+	}
+
+	return []map[string]interface{}{{"detail": requestError.Error()}}
+}
+
+// ProblemError writes an RFC 7807 problem details response.
+func ProblemError(r *http.Request, w http.ResponseWriter, err error, status int, errorCode string) {
+	ctx := r.Context()
+	h := w.Header()
+
+	h.Del("Content-Length")
+
+	h.Set("X-Content-Type-Options", "nosniff")
+
+	responseHeaders := http.Header{
+		"Content-Type": []string{PROBLEM_CONTENT_TYPE},
+	}
+
+	helpers.FillResponseHeaders(responseHeaders, w)
+
+	body := map[string]interface{}{
+		"type":     problemType(errorCode),
+		"title":    http.StatusText(status),
+		"status":   status,
+		"detail":   helpers.Redact(err.Error()),
+		"instance": r.URL.Path,
+		"code":     errorCode,
+	}
+	if requestID := helpers.RequestIDFromContext(ctx); requestID != "" {
+		body["requestId"] = requestID
+	}
+	if errorCode == "BAD_REQUEST" {
+		body["errors"] = invalidParams(err)
+	}
+
+	jsonBody, _ := json.Marshal(body)
+
+	w.WriteHeader(status)
+
+	w.Write(jsonBody)
+
+	helpers.Log(ctx).WithFields(logrus.Fields{
+		"errorCode":  errorCode,
+		"statusCode": status,
+	}).Errorf("Error: %s", err.Error())
+}
+
+// Error writes an error response, as problem details when the server is configured for them.
+func (s *Server) Error(r *http.Request, w http.ResponseWriter, err error, status int, errorCode string) {
+	if s.ProblemDetails {
+		ProblemError(r, w, err, status, errorCode)
+		return
+	}
+	Error(r, w, err.Error(), status, errorCode)
+}
@@ -0,0 +1,171 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/integronlabs/integron/helpers"
+)
+
+const testSpec = `
+openapi: 3.0.3
+info:
+  title: test
+  version: 1.0.0
+paths:
+  /facts/{id}:
+    post:
+      parameters:
+        - name: id
+          in: path
+          required: true
+          schema:
+            type: integer
+        - name: amount
+          in: query
+          required: true
+          schema:
+            type: integer
+      requestBody:
+        required: true
+        content:
+          application/json:
+            schema:
+              type: object
+              required: [name]
+              properties:
+                name:
+                  type: string
+                age:
+                  type: integer
+      responses:
+        '200':
+          description: ok
+          content:
+            application/json:
+              schema:
+                type: object
+      x-integron-steps:
+        - name: respond
+          type: test-next
+          next: ""
+`
+
+func newTestServer(t *testing.T, spec string) *Server {
+	loader := openapi3.NewLoader()
+	doc, err := loader.LoadFromData([]byte(spec))
+	if err != nil {
+		t.Fatalf(EXPECTED_NIL_GOT, err)
+	}
+	if err := doc.Validate(context.Background()); err != nil {
+		t.Fatalf(EXPECTED_NIL_GOT, err)
+	}
+	s, err := New(doc)
+	if err != nil {
+		t.Fatalf(EXPECTED_NIL_GOT, err)
+	}
+	s.RequestFormat = helpers.REQUEST_FORMAT_STRUCTURED
+	return s
+}
+
+func TestProblemDetailsValidation(t *testing.T) {
+	s := newTestServer(t, testSpec)
+	s.ProblemDetails = true
+	request := httptest.NewRequest(http.MethodPost, "/facts/abc?amount=many", nil)
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("X-Request-ID", "req-1")
+	recorder := httptest.NewRecorder()
+
+	s.Handler(recorder, request)
+
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf(EXPECTED_BUT_GOT, http.StatusBadRequest, recorder.Code)
+	}
+	if contentType := recorder.Header().Get("Content-Type"); contentType != PROBLEM_CONTENT_TYPE {
+		t.Errorf(EXPECTED_BUT_GOT, PROBLEM_CONTENT_TYPE, contentType)
+	}
+	var problem map[string]interface{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &problem); err != nil {
+		t.Fatalf(EXPECTED_NIL_GOT, err)
+	}
+	if problem["type"] != "urn:integron:problem:bad-request" || problem["title"] != "Bad Request" || problem["status"] != float64(400) {
+		t.Errorf(EXPECTED_BUT_GOT, "bad request problem", problem)
+	}
+	if problem["instance"] != "/facts/abc" || problem["requestId"] != "req-1" {
+		t.Errorf(EXPECTED_BUT_GOT, "instance and request id", problem)
+	}
+	errs, _ := problem["errors"].([]interface{})
+	if len(errs) != 3 {
+		t.Fatalf(EXPECTED_BUT_GOT, 3, problem["errors"])
+	}
+	if errs[0].(map[string]interface{})["parameter"] != "id" || errs[1].(map[string]interface{})["parameter"] != "amount" {
+		t.Errorf(EXPECTED_BUT_GOT, "id and amount", errs)
+	}
+	if errs[2].(map[string]interface{})["in"] != "body" {
+		t.Errorf(EXPECTED_BUT_GOT, "body", errs[2])
+	}
+}
+
+func TestProblemDetailsBodyPointer(t *testing.T) {
+	s := newTestServer(t, testSpec)
+	s.ProblemDetails = true
+	request := httptest.NewRequest(http.MethodPost, "/facts/1?amount=2", jsonBody(`{"age": "old"}`))
+	request.Header.Set("Content-Type", "application/json")
+	recorder := httptest.NewRecorder()
+
+	s.Handler(recorder, request)
+
+	var problem map[string]interface{}
+	json.Unmarshal(recorder.Body.Bytes(), &problem)
+	errs, _ := problem["errors"].([]interface{})
+	pointers := map[interface{}]bool{}
+	for _, e := range errs {
+		pointers[e.(map[string]interface{})["pointer"]] = true
+	}
+	if !pointers["#/name"] || !pointers["#/age"] {
+		t.Errorf(EXPECTED_BUT_GOT, "#/name and #/age", errs)
+	}
+}
+
+func TestProblemDetailsNotFound(t *testing.T) {
+	s := newTestServer(t, testSpec)
+	s.ProblemDetails = true
+	recorder := httptest.NewRecorder()
+
+	s.Handler(recorder, httptest.NewRequest(http.MethodGet, "/missing", nil))
+
+	var problem map[string]interface{}
+	json.Unmarshal(recorder.Body.Bytes(), &problem)
+	if problem["status"] != float64(404) || problem["code"] != "METHOD_NOT_FOUND" {
+		t.Errorf(EXPECTED_BUT_GOT, "404 METHOD_NOT_FOUND", problem)
+	}
+	if _, ok := problem["errors"]; ok {
+		t.Errorf(EXPECTED_NIL_GOT, problem["errors"])
+	}
+}
+
+func TestErrorWithoutProblemDetails(t *testing.T) {
+	s := newTestServer(t, testSpec)
+	recorder := httptest.NewRecorder()
+
+	request := httptest.NewRequest(http.MethodGet, "/missing", nil)
+	request.Header.Set("X-Request-ID", "req-1")
+
+	s.Handler(recorder, request)
+
+	if contentType := recorder.Header().Get("Content-Type"); contentType != "application/json" {
+		t.Errorf(EXPECTED_BUT_GOT, "application/json", contentType)
+	}
+	if body := recorder.Body.String(); body != `{"message":"Method not found","requestId":"req-1"}` {
+		t.Errorf(EXPECTED_BUT_GOT, `{"message":"Method not found","requestId":"req-1"}`, body)
+	}
+}
+
+func jsonBody(body string) *strings.Reader {
+	return strings.NewReader(body)
+}
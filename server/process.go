@@ -1,55 +1,214 @@
 package server
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
+	"sync"
+	"time"
 
 	"github.com/integronlabs/integron/helpers"
 
 	"github.com/sirupsen/logrus"
 )
 
-func (s *Server) ProcessStep(r *http.Request, currentStepKey string, w http.ResponseWriter, steps map[string]interface{}, stepOutputs map[string]interface{}, stepInput interface{}) (interface{}, string) {
-	ctx := r.Context()
+const METADATA_KEY = "_meta"
+const ERROR_KEY = "_error"
 
-	logrus.WithContext(ctx).Debugf("Processing step: %s", currentStepKey)
+// errorDetails describes a failed step for the error handling steps under $._error.
+func errorDetails(step *Step, err error) map[string]interface{} {
+	details := map[string]interface{}{
+		"step":    step.Name,
+		"type":    step.Type,
+		"message": helpers.Redact(err.Error()),
+		"kind":    helpers.ErrorKind(err),
+	}
+	var upstreamError *helpers.UpstreamError
+	if errors.As(err, &upstreamError) {
+		details["status"] = upstreamError.StatusCode
+	}
+	return details
+}
 
-	var next string
-	var err error
+// errorTarget picks the step handling a failure: the step's onError, the operation default, or the error step.
+func (f *Flow) errorTarget(step *Step) string {
+	for _, target := range []string{step.OnError, f.OnError} {
+		if target != "" && target != step.Name {
+			return target
+		}
+	}
+	return "error"
+}
 
-	step, ok := steps[currentStepKey]
-	if !ok {
-		return fmt.Errorf(helpers.INVALID_STEP_DEFINITION), "error"
+type outputsLockKey struct{}
+
+// WithSharedOutputs returns a context in which steps can run concurrently on the same step outputs,
+// such as the steps of the branches of a parallel step. The step outputs are then written under a
+// lock and every handler reads a snapshot of them.
+func WithSharedOutputs(ctx context.Context) context.Context {
+	if _, ok := ctx.Value(outputsLockKey{}).(*sync.Mutex); ok {
+		return ctx
 	}
-	stepMap, ok := step.(map[string]interface{})
+	return context.WithValue(ctx, outputsLockKey{}, &sync.Mutex{})
+}
+
+// lockOutputs locks the step outputs when they are shared in ctx and returns the function unlocking them.
+func lockOutputs(ctx context.Context) func() {
+	mu, ok := ctx.Value(outputsLockKey{}).(*sync.Mutex)
 	if !ok {
-		return fmt.Errorf(helpers.INVALID_STEP_DEFINITION), "error"
+		return func() {}
 	}
+	mu.Lock()
+	return mu.Unlock
+}
 
-	stepType, ok := stepMap["type"].(string)
-	if !ok {
-		return fmt.Errorf("missing or invalid step type"), "error"
+// SetStepOutput stores the output of a step, e.g. one of a branch, under $.<name>.
+func SetStepOutput(ctx context.Context, stepOutputs map[string]interface{}, name string, output interface{}) {
+	unlock := lockOutputs(ctx)
+	defer unlock()
+	stepOutputs[name] = output
+}
+
+// readableOutputs returns the step outputs a handler reads: a snapshot when they are shared, so that
+// steps writing concurrently never change the map while the handler reads it.
+func readableOutputs(ctx context.Context, stepOutputs map[string]interface{}) map[string]interface{} {
+	if _, ok := ctx.Value(outputsLockKey{}).(*sync.Mutex); !ok {
+		return stepOutputs
+	}
+	unlock := lockOutputs(ctx)
+	defer unlock()
+	snapshot := make(map[string]interface{}, len(stepOutputs))
+	for name, output := range stepOutputs {
+		snapshot[name] = output
 	}
+	return snapshot
+}
+
+// recordMetadata stores how a step ran under $._meta.<step>.
+func recordMetadata(ctx context.Context, stepOutputs map[string]interface{}, name string, metadata map[string]interface{}) {
+	unlock := lockOutputs(ctx)
+	defer unlock()
+	previous, _ := stepOutputs[METADATA_KEY].(map[string]interface{})
+	// snapshots read by running steps may hold the previous map, so it is replaced rather than changed
+	metadataMap := make(map[string]interface{}, len(previous)+1)
+	for step, stepMetadata := range previous {
+		metadataMap[step] = stepMetadata
+	}
+	metadataMap[name] = metadata
+	stepOutputs[METADATA_KEY] = metadataMap
+}
+
+// invoke calls a step handler once, bounded by the step timeout.
+func invoke(ctx context.Context, handler StepHandler, step *Step, definition map[string]interface{}, stepOutputs map[string]interface{}) (interface{}, string, error) {
+	if step.Timeout <= 0 {
+		return handler(ctx, definition, stepOutputs)
+	}
+	stepCtx, cancel := context.WithTimeout(ctx, step.Timeout)
+	defer cancel()
+	return handler(stepCtx, definition, stepOutputs)
+}
 
-	handler, err := GetStepHandler(stepType)
+type stepKey struct{}
+
+// CurrentStep returns the compiled step whose handler runs with ctx, e.g. for the handler to reuse
+// its compiled branches.
+func CurrentStep(ctx context.Context) *Step {
+	step, _ := ctx.Value(stepKey{}).(*Step)
+	return step
+}
+
+// RunStep invokes the handler of a step, applying its timeout and retry policy, and records the step metadata.
+// When the request is being debugged the step is recorded in its execution.
+func RunStep(ctx context.Context, step *Step, stepOutputs map[string]interface{}) (interface{}, string, error) {
+	ctx = context.WithValue(ctx, stepKey{}, step)
+	ctx, record := startStepExecution(ctx, step)
+	start := time.Now()
+	output, next, err := runStep(ctx, step, stepOutputs)
+	record.finish(output, next, err, time.Since(start))
+	return output, next, err
+}
+
+func runStep(ctx context.Context, step *Step, stepOutputs map[string]interface{}) (interface{}, string, error) {
+	handler, err := GetStepHandler(step.Type)
 	if err != nil {
-		return fmt.Errorf("unknown step type: %s", stepType), "error"
+		return nil, "error", fmt.Errorf("unknown step type: %s", step.Type)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err.Error(), "error", fmt.Errorf("step %s not started: %w", step.Name, err)
+	}
+
+	// secrets are resolved on every run so that rotated values are picked up
+	definition := step.Definition
+	if step.Secrets {
+		resolved, err := helpers.ResolveSecretsIn(ctx, step.Definition)
+		if err != nil {
+			return err.Error(), "error", err
+		}
+		definition = resolved.(map[string]interface{})
+	}
+
+	// steps running branches write the outputs of their steps, the others only read them
+	readable := stepOutputs
+	if step.Branches == nil {
+		readable = readableOutputs(ctx, stepOutputs)
+	}
+
+	maxAttempts := 1
+	if step.Retry != nil {
+		maxAttempts = step.Retry.MaxAttempts
 	}
 
-	if stepType == "error" {
-		if inputString, ok := stepInput.(string); ok {
-			Error(r, w, inputString, http.StatusInternalServerError, "EXCEPTION")
-		} else {
-			Error(r, w, stepInput.(error).Error(), http.StatusInternalServerError, "EXCEPTION")
+	start := time.Now()
+	var output interface{}
+	var next string
+	attempt := 1
+	for ; ; attempt++ {
+		// the responses of a step handle retryable statuses only once no attempt is left
+		attemptCtx := ctx
+		if attempt < maxAttempts {
+			attemptCtx = helpers.WithRetryableStatuses(ctx, step.Retry.RetryableStatuses)
+		}
+		output, next, err = invoke(attemptCtx, handler, step, definition, readable)
+		if err == nil || attempt >= maxAttempts || !step.Retry.retryable(err) {
+			break
+		}
+		wait := step.Retry.backoff(attempt, err)
+		helpers.Log(ctx).WithFields(logrus.Fields{
+			"step":    step.Name,
+			"attempt": attempt,
+		}).Warnf("Step %s failed, retrying in %s: %v", step.Name, wait, err)
+		if sleepErr := sleep(ctx, wait); sleepErr != nil {
+			break
 		}
-		return nil, "end"
 	}
 
-	stepOutput, next, err := handler(ctx, stepMap, stepOutputs)
+	recordMetadata(ctx, stepOutputs, step.Name, map[string]interface{}{
+		"attempts":   attempt,
+		"durationMs": time.Since(start).Milliseconds(),
+	})
+	return output, next, err
+}
+
+func (s *Server) ProcessStep(r *http.Request, currentStepKey string, flow *Flow, stepOutputs map[string]interface{}) (interface{}, string) {
+	ctx := r.Context()
+
+	helpers.Log(ctx).Debugf("Processing step: %s", currentStepKey)
+
+	step, ok := flow.Steps[currentStepKey]
+	if !ok {
+		return fmt.Errorf(helpers.INVALID_STEP_DEFINITION), "error"
+	}
+
+	stepOutput, next, err := RunStep(ctx, step, stepOutputs)
 	if err != nil {
-		return err.Error(), "error"
+		SetStepOutput(ctx, stepOutputs, ERROR_KEY, errorDetails(step, err))
+		target := flow.errorTarget(step)
+		recordErrorTarget(ctx, target)
+		return err, target
 	}
-	logrus.WithContext(ctx).Debugf("Step %s completed", currentStepKey)
-	logrus.WithContext(ctx).Debugf("Step outputs: %v", stepOutput)
+	helpers.Log(ctx).Debugf("Step %s completed", currentStepKey)
+	helpers.Log(ctx).Debugf("Step outputs: %v", stepOutput)
 	return stepOutput, next
 }
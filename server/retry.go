@@ -0,0 +1,141 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/integronlabs/integron/helpers"
+)
+
+var defaultRetryableStatuses = []int{429, 502, 503, 504}
+var defaultRetryableErrors = []string{helpers.ERROR_KIND_TIMEOUT, helpers.ERROR_KIND_CONNECTION}
+
+// RetryPolicy is the compiled `retry` block of a step.
+type RetryPolicy struct {
+	MaxAttempts       int
+	InitialBackoff    time.Duration
+	MaxBackoff        time.Duration
+	Jitter            float64
+	RetryableStatuses map[int]bool
+	RetryableErrors   map[string]bool
+}
+
+// ParseRetryPolicy reads a `retry` block, filling in defaults for missing fields.
+func ParseRetryPolicy(value interface{}) (*RetryPolicy, error) {
+	retryMap, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("invalid retry format")
+	}
+
+	policy := &RetryPolicy{
+		MaxAttempts:       3,
+		InitialBackoff:    100 * time.Millisecond,
+		MaxBackoff:        5 * time.Second,
+		RetryableStatuses: make(map[int]bool),
+		RetryableErrors:   make(map[string]bool),
+	}
+
+	if maxAttempts, ok := retryMap["maxAttempts"]; ok {
+		attempts, ok := maxAttempts.(float64)
+		if !ok || attempts < 1 || attempts != float64(int(attempts)) {
+			return nil, fmt.Errorf("invalid retry maxAttempts %v", maxAttempts)
+		}
+		policy.MaxAttempts = int(attempts)
+	}
+	for key, target := range map[string]*time.Duration{"initialBackoff": &policy.InitialBackoff, "maxBackoff": &policy.MaxBackoff} {
+		if value, ok := retryMap[key]; ok {
+			duration, err := helpers.ParseDuration(value)
+			if err != nil || duration < 0 {
+				return nil, fmt.Errorf("invalid retry %s %v", key, value)
+			}
+			*target = duration
+		}
+	}
+	if jitter, ok := retryMap["jitter"]; ok {
+		fraction, ok := jitter.(float64)
+		if !ok || fraction < 0 || fraction > 1 {
+			return nil, fmt.Errorf("invalid retry jitter %v", jitter)
+		}
+		policy.Jitter = fraction
+	}
+
+	statuses := defaultRetryableStatuses
+	if value, ok := retryMap["retryableStatuses"]; ok {
+		list, ok := value.([]interface{})
+		if !ok {
+			return nil, errors.New("invalid retry retryableStatuses format")
+		}
+		statuses = nil
+		for _, item := range list {
+			status, ok := item.(float64)
+			if !ok {
+				return nil, fmt.Errorf("invalid retryable status %v", item)
+			}
+			statuses = append(statuses, int(status))
+		}
+	}
+	for _, status := range statuses {
+		policy.RetryableStatuses[status] = true
+	}
+
+	kinds := defaultRetryableErrors
+	if value, ok := retryMap["retryableErrors"]; ok {
+		list, ok := value.([]interface{})
+		if !ok {
+			return nil, errors.New("invalid retry retryableErrors format")
+		}
+		kinds = nil
+		for _, item := range list {
+			kind, ok := item.(string)
+			if !ok || (kind != helpers.ERROR_KIND_TIMEOUT && kind != helpers.ERROR_KIND_CONNECTION && kind != helpers.ERROR_KIND_STEP) {
+				return nil, fmt.Errorf("invalid retryable error %v", item)
+			}
+			kinds = append(kinds, kind)
+		}
+	}
+	for _, kind := range kinds {
+		policy.RetryableErrors[kind] = true
+	}
+
+	return policy, nil
+}
+
+// retryable reports whether a failed attempt should be tried again.
+func (p *RetryPolicy) retryable(err error) bool {
+	var upstreamError *helpers.UpstreamError
+	if errors.As(err, &upstreamError) {
+		return p.RetryableStatuses[upstreamError.StatusCode]
+	}
+	return p.RetryableErrors[helpers.ErrorKind(err)]
+}
+
+// backoff returns the wait before the given retry, honoring a Retry-After sent by the upstream.
+func (p *RetryPolicy) backoff(retry int, err error) time.Duration {
+	wait := p.InitialBackoff << (retry - 1)
+	if wait > p.MaxBackoff || wait <= 0 {
+		wait = p.MaxBackoff
+	}
+	if p.Jitter > 0 {
+		wait -= time.Duration(rand.Float64() * p.Jitter * float64(wait))
+	}
+	var upstreamError *helpers.UpstreamError
+	if errors.As(err, &upstreamError) && upstreamError.RetryAfter > wait {
+		wait = min(upstreamError.RetryAfter, p.MaxBackoff)
+	}
+	return wait
+}
+
+// sleep waits for the given duration unless the context ends first.
+func sleep(ctx context.Context, wait time.Duration) error {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,318 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/integronlabs/integron/helpers"
+	httpOperation "github.com/integronlabs/integron/http"
+)
+
+func init() {
+	RegisterStep("test-http", func(ctx context.Context, stepMap map[string]interface{}, stepOutputs map[string]interface{}) (interface{}, string, error) {
+		return httpOperation.Run(ctx, http.DefaultClient, stepMap, stepOutputs)
+	})
+}
+
+// flakyServer fails with the given status for the first failures requests.
+func flakyServer(failures int32, status int, retryAfter string) (*httptest.Server, *int32) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) <= failures {
+			if retryAfter != "" {
+				w.Header().Set("Retry-After", retryAfter)
+			}
+			w.WriteHeader(status)
+			return
+		}
+		w.Write([]byte(`{"message": "success"}`))
+	}))
+	return server, &calls
+}
+
+func httpStep(url string, retry map[string]interface{}) *Step {
+	definition := map[string]interface{}{
+		"name":   "upstream",
+		"type":   "test-http",
+		"method": "GET",
+		"url":    url,
+		"responses": map[string]interface{}{
+			"200": map[string]interface{}{
+				"output": map[string]interface{}{"message": "$.body.message"},
+				"next":   "",
+			},
+		},
+	}
+	if retry != nil {
+		definition["retry"] = retry
+	}
+	step, err := NewStep(definition)
+	if err != nil {
+		panic(err)
+	}
+	return step
+}
+
+func TestRunStepRetriesStatus(t *testing.T) {
+	upstream, calls := flakyServer(2, http.StatusServiceUnavailable, "")
+	defer upstream.Close()
+	step := httpStep(upstream.URL, map[string]interface{}{
+		"maxAttempts":    float64(3),
+		"initialBackoff": "1ms",
+	})
+	stepOutputs := map[string]interface{}{}
+
+	output, next, err := RunStep(context.Background(), step, stepOutputs)
+
+	if err != nil {
+		t.Fatalf(EXPECTED_NIL_GOT, err)
+	}
+	if next != "" {
+		t.Errorf(EXPECTED_BUT_GOT, "", next)
+	}
+	if output.(map[string]interface{})["message"] != "success" {
+		t.Errorf(EXPECTED_BUT_GOT, "success", output)
+	}
+	if *calls != 3 {
+		t.Errorf(EXPECTED_BUT_GOT, 3, *calls)
+	}
+	metadata := stepOutputs[METADATA_KEY].(map[string]interface{})["upstream"].(map[string]interface{})
+	if metadata["attempts"] != 3 {
+		t.Errorf(EXPECTED_BUT_GOT, 3, metadata["attempts"])
+	}
+}
+
+func TestRunStepGivesUp(t *testing.T) {
+	upstream, calls := flakyServer(5, http.StatusBadGateway, "")
+	defer upstream.Close()
+	step := httpStep(upstream.URL, map[string]interface{}{
+		"maxAttempts":    float64(2),
+		"initialBackoff": "1ms",
+	})
+
+	_, next, err := RunStep(context.Background(), step, map[string]interface{}{})
+
+	if err == nil {
+		t.Error(EXPECTED_ERROR_GOT_NIL)
+	}
+	if next != "error" {
+		t.Errorf(EXPECTED_BUT_GOT, "error", next)
+	}
+	if *calls != 2 {
+		t.Errorf(EXPECTED_BUT_GOT, 2, *calls)
+	}
+}
+
+func TestRunStepRetriesHandledStatus(t *testing.T) {
+	for _, handled := range []string{"default", "503"} {
+		upstream, calls := flakyServer(3, http.StatusServiceUnavailable, "")
+		step := httpStep(upstream.URL, map[string]interface{}{
+			"maxAttempts":    float64(3),
+			"initialBackoff": "1ms",
+		})
+		step.Definition["responses"].(map[string]interface{})[handled] = map[string]interface{}{
+			"output": map[string]interface{}{"status": "$.status"},
+			"next":   "fallback",
+		}
+
+		output, next, err := RunStep(context.Background(), step, map[string]interface{}{})
+		upstream.Close()
+
+		if err != nil {
+			t.Fatalf(EXPECTED_NIL_GOT, err)
+		}
+		// the last attempt is routed to the responses of the step
+		if next != "fallback" || output.(map[string]interface{})["status"] != 503 {
+			t.Errorf(EXPECTED_BUT_GOT, "fallback with status 503", output)
+		}
+		if *calls != 3 {
+			t.Errorf(EXPECTED_BUT_GOT, 3, *calls)
+		}
+	}
+}
+
+func TestRunStepDoesNotRetryOtherStatuses(t *testing.T) {
+	upstream, calls := flakyServer(1, http.StatusNotFound, "")
+	defer upstream.Close()
+	step := httpStep(upstream.URL, map[string]interface{}{
+		"initialBackoff": "1ms",
+	})
+
+	_, _, err := RunStep(context.Background(), step, map[string]interface{}{})
+
+	if err == nil {
+		t.Error(EXPECTED_ERROR_GOT_NIL)
+	}
+	if *calls != 1 {
+		t.Errorf(EXPECTED_BUT_GOT, 1, *calls)
+	}
+}
+
+func TestRunStepWithoutRetry(t *testing.T) {
+	upstream, calls := flakyServer(1, http.StatusServiceUnavailable, "")
+	defer upstream.Close()
+	step := httpStep(upstream.URL, nil)
+
+	_, _, err := RunStep(context.Background(), step, map[string]interface{}{})
+
+	if err == nil {
+		t.Error(EXPECTED_ERROR_GOT_NIL)
+	}
+	if *calls != 1 {
+		t.Errorf(EXPECTED_BUT_GOT, 1, *calls)
+	}
+}
+
+func TestRunStepRetriesConnectionErrors(t *testing.T) {
+	upstream, _ := flakyServer(0, http.StatusOK, "")
+	url := upstream.URL
+	upstream.Close()
+	step := httpStep(url, map[string]interface{}{
+		"maxAttempts":    float64(2),
+		"initialBackoff": "1ms",
+	})
+	stepOutputs := map[string]interface{}{}
+
+	_, _, err := RunStep(context.Background(), step, stepOutputs)
+
+	if err == nil {
+		t.Error(EXPECTED_ERROR_GOT_NIL)
+	}
+	metadata := stepOutputs[METADATA_KEY].(map[string]interface{})["upstream"].(map[string]interface{})
+	if metadata["attempts"] != 2 {
+		t.Errorf(EXPECTED_BUT_GOT, 2, metadata["attempts"])
+	}
+}
+
+func TestRunStepHonorsRetryAfter(t *testing.T) {
+	upstream, _ := flakyServer(1, http.StatusTooManyRequests, "1")
+	defer upstream.Close()
+	step := httpStep(upstream.URL, map[string]interface{}{
+		"initialBackoff": "1ms",
+		"maxBackoff":     "50ms",
+	})
+
+	start := time.Now()
+	_, _, err := RunStep(context.Background(), step, map[string]interface{}{})
+
+	if err != nil {
+		t.Errorf(EXPECTED_NIL_GOT, err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond || elapsed >= time.Second {
+		t.Errorf(EXPECTED_BUT_GOT, "wait capped at maxBackoff", elapsed)
+	}
+}
+
+func TestParseRetryPolicy(t *testing.T) {
+	policy, err := ParseRetryPolicy(map[string]interface{}{
+		"maxAttempts":       float64(4),
+		"initialBackoff":    "200ms",
+		"maxBackoff":        "1s",
+		"jitter":            0.5,
+		"retryableStatuses": []interface{}{float64(500)},
+		"retryableErrors":   []interface{}{"timeout"},
+	})
+
+	if err != nil {
+		t.Fatalf(EXPECTED_NIL_GOT, err)
+	}
+	if policy.MaxAttempts != 4 || policy.InitialBackoff != 200*time.Millisecond || policy.MaxBackoff != time.Second {
+		t.Errorf(EXPECTED_BUT_GOT, "parsed policy", policy)
+	}
+	if !policy.RetryableStatuses[500] || policy.RetryableStatuses[503] {
+		t.Errorf(EXPECTED_BUT_GOT, "only 500", policy.RetryableStatuses)
+	}
+	if !policy.RetryableErrors["timeout"] || policy.RetryableErrors["connection"] {
+		t.Errorf(EXPECTED_BUT_GOT, "only timeout", policy.RetryableErrors)
+	}
+	if wait := policy.backoff(3, nil); wait > 800*time.Millisecond || wait < 400*time.Millisecond {
+		t.Errorf(EXPECTED_BUT_GOT, "between 400ms and 800ms", wait)
+	}
+}
+
+func TestParseRetryPolicyInvalid(t *testing.T) {
+	invalid := []interface{}{
+		"always",
+		map[string]interface{}{"maxAttempts": float64(0)},
+		map[string]interface{}{"initialBackoff": "soon"},
+		map[string]interface{}{"jitter": float64(2)},
+		map[string]interface{}{"retryableStatuses": "5xx"},
+		map[string]interface{}{"retryableErrors": []interface{}{"sometimes"}},
+	}
+	for _, value := range invalid {
+		if _, err := ParseRetryPolicy(value); err == nil {
+			t.Errorf("Expected error for %v, got nil", value)
+		}
+	}
+}
+
+func TestRunStepTimeout(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(200 * time.Millisecond):
+		}
+	}))
+	defer upstream.Close()
+	step := httpStep(upstream.URL, nil)
+	step.Timeout = 10 * time.Millisecond
+
+	start := time.Now()
+	_, next, err := RunStep(context.Background(), step, map[string]interface{}{})
+
+	if err == nil {
+		t.Fatal(EXPECTED_ERROR_GOT_NIL)
+	}
+	if next != "error" {
+		t.Errorf(EXPECTED_BUT_GOT, "error", next)
+	}
+	if status, errorCode := errorStatus(err); status != http.StatusGatewayTimeout || errorCode != "TIMEOUT" {
+		t.Errorf(EXPECTED_BUT_GOT, "504 TIMEOUT", errorCode)
+	}
+	if time.Since(start) >= 200*time.Millisecond {
+		t.Error("Expected step to be cut off by its timeout")
+	}
+}
+
+func TestRunStepExpiredContext(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Nanosecond)
+	defer cancel()
+	<-ctx.Done()
+
+	_, next, err := RunStep(ctx, httpStep("http://example.com", nil), map[string]interface{}{})
+
+	if err == nil {
+		t.Fatal(EXPECTED_ERROR_GOT_NIL)
+	}
+	if next != "error" {
+		t.Errorf(EXPECTED_BUT_GOT, "error", next)
+	}
+	if status, _ := errorStatus(err); status != http.StatusGatewayTimeout {
+		t.Errorf(EXPECTED_BUT_GOT, http.StatusGatewayTimeout, status)
+	}
+}
+
+func TestErrorDetails(t *testing.T) {
+	step := httpStep("http://example.com", nil)
+	err := &helpers.UpstreamError{StatusCode: http.StatusNotFound, Err: errors.New("could not find actions for status 404")}
+
+	details := errorDetails(step, err)
+
+	if details["step"] != "upstream" || details["type"] != "test-http" {
+		t.Errorf(EXPECTED_BUT_GOT, "upstream test-http", details)
+	}
+	if details["kind"] != helpers.ERROR_KIND_UPSTREAM_STATUS {
+		t.Errorf(EXPECTED_BUT_GOT, helpers.ERROR_KIND_UPSTREAM_STATUS, details["kind"])
+	}
+	if details["status"] != http.StatusNotFound {
+		t.Errorf(EXPECTED_BUT_GOT, http.StatusNotFound, details["status"])
+	}
+	if details["message"] != "could not find actions for status 404" {
+		t.Errorf(EXPECTED_BUT_GOT, "could not find actions for status 404", details["message"])
+	}
+}
@@ -3,6 +3,7 @@ package server
 import "errors"
 
 var stepRegistry = make(map[string]StepHandler)
+var stepSchemaRegistry = make(map[string]StepSchema)
 
 // RegisterStep registers a step handler for a specific type.
 func RegisterStep(stepType string, handler StepHandler) {
@@ -17,3 +18,25 @@ func GetStepHandler(stepType string) (StepHandler, error) {
 	}
 	return handler, nil
 }
+
+// RegisterStepSchema registers the static shape of a step type used when compiling flows.
+func RegisterStepSchema(stepType string, schema StepSchema) {
+	stepSchemaRegistry[stepType] = schema
+}
+
+// GetStepSchema retrieves the schema of a step type, falling back to a plain `next` field.
+func GetStepSchema(stepType string) StepSchema {
+	schema, exists := stepSchemaRegistry[stepType]
+	if !exists {
+		return StepSchema{}
+	}
+	return schema
+}
+
+// NextTarget returns the `next` field of a step, the default target of every step type.
+func NextTarget(stepMap map[string]interface{}) []string {
+	if next, ok := stepMap["next"].(string); ok {
+		return []string{next}
+	}
+	return nil
+}
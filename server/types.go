@@ -2,14 +2,103 @@ package server
 
 import (
 	"context"
+	"sync"
+	"time"
 
+	"github.com/getkin/kin-openapi/openapi3"
 	"github.com/getkin/kin-openapi/routers"
+	"github.com/getkin/kin-openapi/routers/gorillamux"
+	"github.com/integronlabs/integron/auth"
 	"github.com/sirupsen/logrus"
 )
 
 type Server struct {
-	Router       routers.Router
-	LogFormatter logrus.Formatter
+	Router routers.Router
+	Flows  map[*openapi3.Operation]*Flow
+	// Logger writes the entries of every request; nil uses the standard logger.
+	Logger *logrus.Logger
+	// ProblemDetails makes error responses RFC 7807 application/problem+json documents.
+	ProblemDetails bool
+	// RequestFormat is the default shape of $.request: "legacy" flat parameters, used when empty,
+	// or "structured".
+	RequestFormat string
+	// CORS is the policy of operations that do not override it; nil disables CORS.
+	CORS *CORSPolicy
+	// Auth enforces the security requirements of operations; without it they always fail.
+	Auth *auth.Authenticator
+	// StepMiddleware wraps the processing of every step, the first being the outermost.
+	StepMiddleware []StepMiddleware
+	// Executions keeps the step-by-step runs of requests sent with DebugToken in DEBUG_HEADER;
+	// nil disables the debug mode.
+	Executions *ExecutionStore
+	DebugToken string
+
+	// levelLoggers caches the loggers of operations overriding the log level, by level.
+	levelLoggers sync.Map
+}
+
+// New compiles the flows of a loaded OpenAPI document and returns a Server routing its operations.
+func New(doc *openapi3.T) (*Server, error) {
+	flows, err := Compile(doc)
+	if err != nil {
+		return nil, err
+	}
+	router, err := gorillamux.NewRouter(doc)
+	if err != nil {
+		return nil, err
+	}
+	return &Server{Router: router, Flows: flows}, nil
 }
 
 type StepHandler func(ctx context.Context, stepMap map[string]interface{}, stepOutputs map[string]interface{}) (interface{}, string, error)
+
+// StepSchema describes what a step type needs so that flows can be checked before serving.
+type StepSchema struct {
+	// Required lists the fields every step of this type must define.
+	Required []string
+	// Targets lists the step names a step can continue to. Defaults to NextTarget.
+	Targets func(stepMap map[string]interface{}) []string
+	// Branches lists nested step sequences that are compiled as flows of their own.
+	Branches func(stepMap map[string]interface{}) map[string][]interface{}
+	// Validate reports type-specific problems of a step definition.
+	Validate func(stepMap map[string]interface{}) []error
+	// Compile parses a step definition once when the flow is compiled, reporting its problems like
+	// Validate. The result is kept as Step.Compiled for the handler to reuse, see CurrentStep.
+	Compile func(stepMap map[string]interface{}) (interface{}, []error)
+}
+
+// Step is a compiled entry of x-integron-steps.
+type Step struct {
+	Name       string
+	Type       string
+	Targets    []string
+	OnError    string
+	Retry      *RetryPolicy
+	Timeout    time.Duration
+	Definition map[string]interface{}
+	// Secrets is set when the definition references secrets resolved before every run.
+	Secrets bool
+	// Branches holds the compiled step sequences of step types with StepSchema.Branches, by name.
+	Branches map[string]*Flow
+	// Compiled is the result of StepSchema.Compile.
+	Compiled interface{}
+}
+
+// Flow is the compiled step graph of an operation.
+type Flow struct {
+	Method      string
+	Path        string
+	OperationID string
+	Start       string
+	Steps       map[string]*Step
+	Timeout     time.Duration
+	OnError     string
+	// RequestFormat overrides the server default shape of $.request.
+	RequestFormat string
+	// Order lists the step names as they are defined in the spec.
+	Order []string
+	// CORS overrides the server CORS policy.
+	CORS *CORSPolicy
+	// LogLevel overrides the level of the server logger.
+	LogLevel *logrus.Level
+}
@@ -0,0 +1,121 @@
+package switchstep
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/integronlabs/integron/helpers"
+	"github.com/integronlabs/integron/server"
+)
+
+// Targets lists the next steps of every case and the default.
+func Targets(stepMap map[string]interface{}) []string {
+	var targets []string
+	cases, _ := stepMap["cases"].([]interface{})
+	for _, c := range cases {
+		if caseMap, ok := c.(map[string]interface{}); ok {
+			if next, ok := caseMap["next"].(string); ok {
+				targets = append(targets, next)
+			}
+		}
+	}
+	if defaultNext, ok := stepMap["default"].(string); ok {
+		targets = append(targets, defaultNext)
+	}
+	return targets
+}
+
+// switchCase is a case of a switch step with its parsed when expression.
+type switchCase struct {
+	when       string
+	next       string
+	expression *helpers.Expression
+}
+
+// Compile parses the when expression of every case, so that invalid ones are found when flows are
+// compiled rather than by the first request reaching them.
+func Compile(stepMap map[string]interface{}) (interface{}, []error) {
+	cases, ok := stepMap["cases"].([]interface{})
+	if !ok {
+		return nil, []error{fmt.Errorf("invalid cases format")}
+	}
+	var errs []error
+	compiled := make([]switchCase, 0, len(cases))
+	for i, c := range cases {
+		caseMap, ok := c.(map[string]interface{})
+		if !ok {
+			errs = append(errs, fmt.Errorf("invalid case format at index %d", i))
+			continue
+		}
+		when, ok := caseMap["when"].(string)
+		if !ok {
+			errs = append(errs, fmt.Errorf("invalid when format at index %d", i))
+			continue
+		}
+		next, ok := caseMap["next"].(string)
+		if !ok {
+			errs = append(errs, fmt.Errorf("invalid next format at index %d", i))
+			continue
+		}
+		expression, err := helpers.CompileExpression(when)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("case %d: %w", i, err))
+			continue
+		}
+		compiled = append(compiled, switchCase{when: when, next: next, expression: expression})
+	}
+	return compiled, errs
+}
+
+// compiledCases returns the cases compiled with the flow, compiling them when the step runs on its own.
+func compiledCases(ctx context.Context, stepMap map[string]interface{}) ([]switchCase, error) {
+	if step := server.CurrentStep(ctx); step != nil {
+		if cases, ok := step.Compiled.([]switchCase); ok {
+			return cases, nil
+		}
+	}
+	compiled, errs := Compile(stepMap)
+	if len(errs) > 0 {
+		return nil, errs[0]
+	}
+	return compiled.([]switchCase), nil
+}
+
+func Run(ctx context.Context, stepMap map[string]interface{}, stepOutputs map[string]interface{}) (interface{}, string, error) {
+	// get values
+
+	cases, err := compiledCases(ctx, stepMap)
+	if err != nil {
+		return err.Error(), "error", err
+	}
+	defaultNext, ok := stepMap["default"].(string)
+	if !ok {
+		err := fmt.Errorf("invalid default format")
+		return err.Error(), "error", err
+	}
+
+	// the cases evaluated until one matched
+	var evaluated []interface{}
+	defer func() {
+		helpers.RecordInput(ctx, "cases", evaluated)
+	}()
+
+	for _, c := range cases {
+		matched, err := c.expression.Evaluate(ctx, stepOutputs)
+		if err != nil {
+			helpers.Log(ctx).Errorf("could not evaluate case: %v", err)
+			return err.Error(), "error", err
+		}
+
+		helpers.Log(ctx).Debugf("case %q: %v", c.when, matched)
+		evaluated = append(evaluated, map[string]interface{}{"when": c.when, "matched": matched})
+
+		if matched {
+			return map[string]interface{}{"case": c.when, "next": c.next}, c.next, nil
+		}
+	}
+
+	helpers.Log(ctx).Debugf("default: %v", defaultNext)
+
+	return map[string]interface{}{"case": "default", "next": defaultNext}, defaultNext, nil
+}
@@ -0,0 +1,194 @@
+package switchstep
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/integronlabs/integron/helpers"
+	"github.com/integronlabs/integron/server"
+)
+
+const EXPECTED_NIL_GOT = "Expected nil, got %v"
+const EXPECTED_ERROR_GOT_NIL = "Expected error, got nil"
+const EXPECTED_BUT_GOT = "Expected %v, got %v"
+
+var stepOutputs = map[string]interface{}{
+	"request": map[string]interface{}{
+		"amount": "12",
+	},
+}
+
+func init() {
+	server.RegisterStep("switch", Run)
+	server.RegisterStepSchema("switch", server.StepSchema{Targets: Targets, Compile: Compile})
+}
+
+func validStepMap() map[string]interface{} {
+	return map[string]interface{}{
+		"cases": []interface{}{
+			map[string]interface{}{
+				"when": "$.request.amount > 100",
+				"next": "huge",
+			},
+			map[string]interface{}{
+				"when": "$.request.amount > 10",
+				"next": "big",
+			},
+		},
+		"default": "small",
+	}
+}
+
+func TestRun(t *testing.T) {
+	output, next, err := Run(context.Background(), validStepMap(), stepOutputs)
+
+	if err != nil {
+		t.Errorf(EXPECTED_NIL_GOT, err)
+	}
+	if next != "big" {
+		t.Errorf(EXPECTED_BUT_GOT, "big", next)
+	}
+	if output.(map[string]interface{})["case"] != "$.request.amount > 10" {
+		t.Errorf(EXPECTED_BUT_GOT, "$.request.amount > 10", output)
+	}
+}
+
+func TestRunDefault(t *testing.T) {
+	stepMap := validStepMap()
+	stepMap["cases"] = []interface{}{}
+
+	_, next, err := Run(context.Background(), stepMap, stepOutputs)
+
+	if err != nil {
+		t.Errorf(EXPECTED_NIL_GOT, err)
+	}
+	if next != "small" {
+		t.Errorf(EXPECTED_BUT_GOT, "small", next)
+	}
+}
+
+func TestRunInvalidCases(t *testing.T) {
+	stepMap := validStepMap()
+	stepMap["cases"] = "invalid"
+
+	output, next, err := Run(context.Background(), stepMap, stepOutputs)
+
+	if err == nil {
+		t.Error(EXPECTED_ERROR_GOT_NIL)
+	}
+	if output != "invalid cases format" {
+		t.Errorf(EXPECTED_BUT_GOT, "invalid cases format", output)
+	}
+	if next != "error" {
+		t.Errorf(EXPECTED_BUT_GOT, "error", next)
+	}
+}
+
+func TestRunMissingDefault(t *testing.T) {
+	stepMap := validStepMap()
+	delete(stepMap, "default")
+
+	_, next, err := Run(context.Background(), stepMap, stepOutputs)
+
+	if err == nil {
+		t.Error(EXPECTED_ERROR_GOT_NIL)
+	}
+	if next != "error" {
+		t.Errorf(EXPECTED_BUT_GOT, "error", next)
+	}
+}
+
+func TestRunInvalidExpression(t *testing.T) {
+	stepMap := validStepMap()
+	stepMap["cases"] = []interface{}{
+		map[string]interface{}{
+			"when": "$.request.amount >",
+			"next": "big",
+		},
+	}
+
+	_, next, err := Run(context.Background(), stepMap, stepOutputs)
+
+	if err == nil {
+		t.Error(EXPECTED_ERROR_GOT_NIL)
+	}
+	if next != "error" {
+		t.Errorf(EXPECTED_BUT_GOT, "error", next)
+	}
+}
+
+func TestRunInvalidCase(t *testing.T) {
+	stepMap := validStepMap()
+	stepMap["cases"] = []interface{}{
+		map[string]interface{}{
+			"when": "$.request.amount > 10",
+		},
+	}
+
+	_, next, err := Run(context.Background(), stepMap, stepOutputs)
+
+	if err == nil {
+		t.Error(EXPECTED_ERROR_GOT_NIL)
+	}
+	if next != "error" {
+		t.Errorf(EXPECTED_BUT_GOT, "error", next)
+	}
+}
+
+func TestRunRecordsEvaluatedCases(t *testing.T) {
+	recorded := make(map[string]interface{})
+	ctx := helpers.WithInputRecorder(context.Background(), func(name string, value interface{}) {
+		recorded[name] = value
+	})
+
+	Run(ctx, validStepMap(), stepOutputs)
+
+	expected := []interface{}{
+		map[string]interface{}{"when": "$.request.amount > 100", "matched": false},
+		map[string]interface{}{"when": "$.request.amount > 10", "matched": true},
+	}
+	if !reflect.DeepEqual(recorded["cases"], expected) {
+		t.Errorf(EXPECTED_BUT_GOT, expected, recorded["cases"])
+	}
+}
+
+func TestCompile(t *testing.T) {
+	stepMap := validStepMap()
+	stepMap["cases"] = []interface{}{
+		map[string]interface{}{"when": "$.request.amount >", "next": "big"},
+		"invalid",
+		map[string]interface{}{"when": "$.request.amount > 10", "next": "big"},
+	}
+
+	compiled, errs := Compile(stepMap)
+
+	if len(errs) != 2 || !strings.HasPrefix(errs[0].Error(), `case 0: invalid expression "$.request.amount >"`) || errs[1].Error() != "invalid case format at index 1" {
+		t.Errorf(EXPECTED_BUT_GOT, "the invalid expression and case", errs)
+	}
+	if cases := compiled.([]switchCase); len(cases) != 1 || cases[0].next != "big" {
+		t.Errorf(EXPECTED_BUT_GOT, "the valid case", compiled)
+	}
+}
+
+func TestRunUsesCompiledCases(t *testing.T) {
+	stepMap := validStepMap()
+	stepMap["name"] = "route"
+	stepMap["type"] = "switch"
+	step, err := server.NewStep(stepMap)
+	if err != nil {
+		t.Fatalf(EXPECTED_NIL_GOT, err)
+	}
+	// the cases are parsed once, so changing the definition afterwards has no effect
+	stepMap["cases"] = "invalid"
+
+	_, next, err := server.RunStep(context.Background(), step, stepOutputs)
+
+	if err != nil {
+		t.Errorf(EXPECTED_NIL_GOT, err)
+	}
+	if next != "big" {
+		t.Errorf(EXPECTED_BUT_GOT, "big", next)
+	}
+}
@@ -0,0 +1,107 @@
+package tracing
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/integronlabs/integron/helpers"
+	"github.com/integronlabs/integron/server"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const ATTRIBUTE_STEP_NAME = attribute.Key("integron.step.name")
+const ATTRIBUTE_STEP_TYPE = attribute.Key("integron.step.type")
+const ATTRIBUTE_STEP_NEXT = attribute.Key("integron.step.next")
+const ATTRIBUTE_OPERATION = attribute.Key("integron.operation")
+
+// recordError marks a span as failed. Like log entries, spans never carry resolved secrets, which
+// errors quoting an upstream URL can contain.
+func recordError(span trace.Span, err error) {
+	message := helpers.Redact(err.Error())
+	span.RecordError(errors.New(message))
+	span.SetStatus(codes.Error, message)
+}
+
+// Handler starts a server span for every request served by next, usually Server.Handler,
+// continuing the trace of an incoming traceparent header.
+func Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, info := server.WithRequestInfo(ctx)
+		ctx, span := tracer().Start(ctx, r.Method, trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(
+			semconv.HTTPRequestMethodKey.String(r.Method),
+			semconv.URLPath(r.URL.Path),
+		))
+		defer span.End()
+
+		recorder := &server.StatusRecorder{ResponseWriter: w}
+		next.ServeHTTP(recorder, r.WithContext(ctx))
+
+		if info.Path != "" {
+			span.SetName(info.Method + " " + info.Path)
+			span.SetAttributes(semconv.HTTPRoute(info.Path), ATTRIBUTE_OPERATION.String(info.Operation))
+		}
+		status := recorder.Status()
+		span.SetAttributes(semconv.HTTPResponseStatusCode(status))
+		if status >= 500 {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+	})
+}
+
+// Step is a server.StepMiddleware starting a span for every step. Steps run with the span in
+// their context, so the upstream calls of http steps become its children.
+func Step(next server.StepProcessor) server.StepProcessor {
+	return func(r *http.Request, currentStepKey string, flow *server.Flow, stepOutputs map[string]interface{}) (interface{}, string) {
+		attributes := []attribute.KeyValue{ATTRIBUTE_STEP_NAME.String(currentStepKey), ATTRIBUTE_OPERATION.String(flow.Operation())}
+		if step, ok := flow.Steps[currentStepKey]; ok {
+			attributes = append(attributes, ATTRIBUTE_STEP_TYPE.String(step.Type))
+		}
+		ctx, span := tracer().Start(r.Context(), "step "+currentStepKey, trace.WithAttributes(attributes...))
+		defer span.End()
+
+		output, nextStep := next(r.WithContext(ctx), currentStepKey, flow, stepOutputs)
+
+		span.SetAttributes(ATTRIBUTE_STEP_NEXT.String(nextStep))
+		// ProcessStep returns the error as the output of a failed step
+		if err, failed := output.(error); failed {
+			recordError(span, err)
+		}
+		return output, nextStep
+	}
+}
+
+// Transport starts a client span for every call made through next and sends its trace context
+// to the upstream in a traceparent header.
+func Transport(next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return server.RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		ctx, span := tracer().Start(r.Context(), r.Method, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+			semconv.HTTPRequestMethodKey.String(r.Method),
+			semconv.URLFull(helpers.Redact(r.URL.Redacted())),
+			semconv.ServerAddress(r.URL.Hostname()),
+		))
+		defer span.End()
+
+		r = r.Clone(ctx)
+		otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(r.Header))
+		response, err := next.RoundTrip(r)
+		if err != nil {
+			recordError(span, err)
+			return nil, err
+		}
+		span.SetAttributes(semconv.HTTPResponseStatusCode(response.StatusCode))
+		if response.StatusCode >= 400 {
+			span.SetStatus(codes.Error, fmt.Sprintf("%d %s", response.StatusCode, http.StatusText(response.StatusCode)))
+		}
+		return response, nil
+	})
+}
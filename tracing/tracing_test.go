@@ -0,0 +1,238 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	httpOperation "github.com/integronlabs/integron/http"
+	"github.com/integronlabs/integron/server"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+const EXPECTED_NIL_GOT = "Expected nil, got %v"
+const EXPECTED_ERROR_GOT_NIL = "Expected error, got nil"
+const EXPECTED_BUT_GOT = "Expected %v, got %v"
+
+const incomingTraceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+// client calls upstreams like http steps do once serve instruments their clients.
+var client = &http.Client{Transport: Transport(nil)}
+
+func init() {
+	server.RegisterStep("tracing-http", func(ctx context.Context, stepMap map[string]interface{}, stepOutputs map[string]interface{}) (interface{}, string, error) {
+		return httpOperation.Run(ctx, client, stepMap, stepOutputs)
+	})
+	server.RegisterStep("tracing-fail", func(ctx context.Context, stepMap map[string]interface{}, stepOutputs map[string]interface{}) (interface{}, string, error) {
+		err := errors.New("failed")
+		return err.Error(), "error", err
+	})
+}
+
+const tracingSpec = `
+openapi: 3.0.3
+info:
+  title: test
+  version: 1.0.0
+paths:
+  /facts:
+    get:
+      operationId: getFacts
+      responses:
+        '200':
+          description: ok
+          content:
+            application/json:
+              schema:
+                type: object
+      x-integron-steps:
+        - name: upstream
+          type: tracing-http
+          method: GET
+          url: UPSTREAM
+          responses:
+            '200':
+              output:
+                body: $.body
+              next: ""
+  /broken:
+    get:
+      responses:
+        '500':
+          description: failed
+      x-integron-steps:
+        - name: fail
+          type: tracing-fail
+          next: ""
+  /keyed:
+    get:
+      responses:
+        '200':
+          description: ok
+      x-integron-steps:
+        - name: upstream
+          type: tracing-http
+          method: GET
+          url: UPSTREAM/keyed?key=${env:INTEGRON_TRACING_KEY}
+          responses:
+            '200':
+              output: {}
+              next: ""
+`
+
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	return recorder
+}
+
+func newTracedServer(t *testing.T, upstreamURL string) http.Handler {
+	doc, err := openapi3.NewLoader().LoadFromData([]byte(strings.ReplaceAll(tracingSpec, "UPSTREAM", upstreamURL)))
+	if err != nil {
+		t.Fatalf(EXPECTED_NIL_GOT, err)
+	}
+	s, err := server.New(doc)
+	if err != nil {
+		t.Fatalf(EXPECTED_NIL_GOT, err)
+	}
+	s.StepMiddleware = []server.StepMiddleware{Step}
+	return Handler(http.HandlerFunc(s.Handler))
+}
+
+func TestTracePropagation(t *testing.T) {
+	spans := recordSpans(t)
+	var upstreamTraceparent string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamTraceparent = r.Header.Get("traceparent")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{}`))
+	}))
+	defer upstream.Close()
+	request := httptest.NewRequest(http.MethodGet, "/facts", nil)
+	request.Header.Set("traceparent", incomingTraceparent)
+
+	newTracedServer(t, upstream.URL).ServeHTTP(httptest.NewRecorder(), request)
+
+	ended := spans.Ended()
+	if len(ended) != 3 {
+		t.Fatalf(EXPECTED_BUT_GOT, 3, len(ended))
+	}
+	client, step, serverSpan := ended[0], ended[1], ended[2]
+	if serverSpan.Name() != "GET /facts" || step.Name() != "step upstream" || client.Name() != "GET" {
+		t.Errorf(EXPECTED_BUT_GOT, "GET /facts, step upstream, GET", []string{serverSpan.Name(), step.Name(), client.Name()})
+	}
+	if traceID := serverSpan.SpanContext().TraceID().String(); traceID != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf(EXPECTED_BUT_GOT, "the incoming trace id", traceID)
+	}
+	if step.Parent().SpanID() != serverSpan.SpanContext().SpanID() || client.Parent().SpanID() != step.SpanContext().SpanID() {
+		t.Error("Expected the client span in the step span in the server span")
+	}
+	expected := "00-4bf92f3577b34da6a3ce929d0e0e4736-" + client.SpanContext().SpanID().String() + "-01"
+	if upstreamTraceparent != expected {
+		t.Errorf(EXPECTED_BUT_GOT, expected, upstreamTraceparent)
+	}
+	for _, attribute := range step.Attributes() {
+		if attribute.Key == ATTRIBUTE_STEP_TYPE && attribute.Value.AsString() != "tracing-http" {
+			t.Errorf(EXPECTED_BUT_GOT, "tracing-http", attribute.Value.AsString())
+		}
+	}
+}
+
+func TestTraceFailedStep(t *testing.T) {
+	spans := recordSpans(t)
+
+	newTracedServer(t, "http://localhost").ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/broken", nil))
+
+	ended := spans.Ended()
+	if len(ended) != 2 {
+		t.Fatalf(EXPECTED_BUT_GOT, 2, len(ended))
+	}
+	for _, span := range ended {
+		if span.Status().Code != codes.Error {
+			t.Errorf(EXPECTED_BUT_GOT, codes.Error, span.Status())
+		}
+	}
+}
+
+func TestTraceRedactsSecrets(t *testing.T) {
+	spans := recordSpans(t)
+	t.Setenv("INTEGRON_TRACING_KEY", "tracing-secret")
+	upstream := httptest.NewServer(http.NotFoundHandler())
+	upstream.Close()
+
+	newTracedServer(t, upstream.URL).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/keyed", nil))
+
+	ended := spans.Ended()
+	if len(ended) != 3 {
+		t.Fatalf(EXPECTED_BUT_GOT, 3, len(ended))
+	}
+	for _, span := range ended {
+		exported := fmt.Sprint(span.Attributes(), span.Status(), span.Events())
+		if strings.Contains(exported, "tracing-secret") {
+			t.Errorf(EXPECTED_BUT_GOT, "no secret", exported)
+		}
+	}
+	if client := fmt.Sprint(ended[0].Attributes(), ended[0].Status()); !strings.Contains(client, "key=[REDACTED]") {
+		t.Errorf(EXPECTED_BUT_GOT, "a redacted url", client)
+	}
+}
+
+func TestLogHook(t *testing.T) {
+	recordSpans(t)
+	var output bytes.Buffer
+	logger := logrus.New()
+	logger.SetOutput(&output)
+	logger.SetFormatter(&logrus.JSONFormatter{})
+	logger.AddHook(LogHook{})
+	ctx, span := tracer().Start(context.Background(), "test")
+	defer span.End()
+
+	logger.WithContext(ctx).Info("traced")
+	logger.WithContext(context.Background()).Info("untraced")
+
+	lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+	if !strings.Contains(lines[0], `"trace_id":"`+span.SpanContext().TraceID().String()+`"`) || !strings.Contains(lines[0], `"span_id":"`) {
+		t.Errorf(EXPECTED_BUT_GOT, "trace and span ids", lines[0])
+	}
+	if strings.Contains(lines[1], "trace_id") {
+		t.Errorf(EXPECTED_BUT_GOT, "no trace id", lines[1])
+	}
+}
+
+func TestSetupFileExporter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "traces.json")
+	shutdown, err := Setup(context.Background(), EXPORTER_FILE, path)
+	if err != nil {
+		t.Fatalf(EXPECTED_NIL_GOT, err)
+	}
+	_, span := tracer().Start(context.Background(), "exported")
+	span.End()
+
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatalf(EXPECTED_NIL_GOT, err)
+	}
+	exported, _ := os.ReadFile(path)
+	if !strings.Contains(string(exported), `"Name":"exported"`) || !strings.Contains(string(exported), `"Value":"integron"`) {
+		t.Errorf(EXPECTED_BUT_GOT, "the exported span", string(exported))
+	}
+}
+
+func TestSetupUnknownExporter(t *testing.T) {
+	if _, err := Setup(context.Background(), "zipkin", ""); err == nil {
+		t.Error(EXPECTED_ERROR_GOT_NIL)
+	}
+}
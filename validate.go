@@ -0,0 +1,31 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/integronlabs/integron/server"
+)
+
+func validate(args []string) int {
+	flags := flag.NewFlagSet("validate", flag.ExitOnError)
+	openapiSpecPath := flags.String("spec", envOrDefault("INTEGRON_SPEC", "docs/openapi.yaml"), "Path to the OpenAPI spec (INTEGRON_SPEC)")
+	flags.Parse(args)
+
+	doc, upstreams, err := loadSpec(context.Background(), *openapiSpecPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	registerSteps(upstreams)
+
+	if _, err := server.Compile(doc); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	fmt.Fprintf(os.Stdout, "%s is valid\n", *openapiSpecPath)
+	return 0
+}